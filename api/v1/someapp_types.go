@@ -18,7 +18,10 @@ package v1
 
 import (
 	core_v1 "k8s.io/api/core/v1"
+	networking_v1 "k8s.io/api/networking/v1"
+	rbac_v1 "k8s.io/api/rbac/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 var (
@@ -26,6 +29,31 @@ var (
 	AppTypeScript = "script"
 	StableStage   = "stable"
 	CanaryStage   = "canary"
+
+	ServiceTypeHeadless = "Headless"
+
+	DeletionPolicyDelete = "Delete"
+	DeletionPolicyOrphan = "Orphan"
+	DeletionPolicyRetain = "Retain"
+
+	// condition types of status.conditions
+	ConditionWaitingForStable  = "WaitingForStable"
+	ConditionDrifted           = "Drifted"
+	ConditionAvailable         = "Available"
+	ConditionPaused            = "Paused"
+	ConditionDryRun            = "DryRun"
+	ConditionAdopted           = "Adopted"
+	ConditionWaitingForCallers = "WaitingForCallers"
+
+	// annotation to pause reconcile of child resources, same as spec.paused
+	PausedAnnotation = "ops.some.cn/paused"
+	// annotation to dry-run reconcile, changes of child resources only planned in status.plan
+	DryRunAnnotation = "ops.some.cn/dry-run"
+	// annotation to adopt existing deployment, service, hpa, vs and dr of the same name,
+	// not owned by any someapp, immutable deployment selector is kept
+	AdoptAnnotation = "ops.some.cn/adopt"
+	// annotation to rolling restart pods, copied to pod template, like kubectl rollout restart
+	RestartedAtAnnotation = "ops.some.cn/restartedAt"
 )
 
 // NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
@@ -60,15 +88,36 @@ type SomeappSpec struct {
 	// +optional
 	ImagePullSecret string `json:"imageSecret"`
 
+	// pod serviceaccount, if not set, use namespace default serviceaccount
+	// +optional
+	ServiceAccount *SomeServiceAccount `json:"serviceAccount,omitempty"`
+
 	// k8s standard containers resources
 	// +kubebuilder:validation:Required
 	Containers []core_v1.Container `json:"containers"`
 
-	// only use configmap or secret,
-	// like configmap name a, secret name b
+	// volumes add to pod, and mount to containers
+	// mounts merged with containers volumeMounts, same mountPath in containers first
+	// +listType=map
+	// +listMapKey=name
+	// +optional
+	Volumes []SomeVolume `json:"volumes,omitempty"`
+
+	// Deprecated: use volumes, mapped to volumes by the operator for one release, then removed.
+	// only use configmap or secret, like configmap-a or secret-b, name without prefix is a configmap,
+	// file some_config.yaml of it mount to /app/some_config.yaml of container app
 	// +optional
 	SomeVolume string `json:"someVolume,omitempty"`
 
+	// by default, deployment will rolling restart when referenced configmaps or secrets changed,
+	// set true to disable it
+	// +optional
+	DisableConfigRestart bool `json:"disableConfigRestart,omitempty"`
+
+	// inline config, operator create an immutable configmap for each content revision
+	// +optional
+	Config *SomeConfig `json:"config,omitempty"`
+
 	// create hpa, with min-->max
 	// if not set, will not create hpa
 	// +kubebuilder:validation:Pattern=\d+\->\d+
@@ -80,14 +129,505 @@ type SomeappSpec struct {
 	// +optional
 	HpaCpuUsage int32 `json:"hpaCpuUsage,omitempty"`
 
+	// service of spec.type == api, if not set or no ports,
+	// create ClusterIP service with port 80 to container app port http, api or unnamed
+	// +optional
+	Service *SomeServiceSpec `json:"service,omitempty"`
+
+	// expose stable service out of cluster, only used when spec.type == api
+	// with enableIstio and gateway, attach stable vs to istio gateway, or create ingress
+	// +optional
+	Expose *SomeExpose `json:"expose,omitempty"`
+
+	// create pdb, minAvailable or maxUnavailable, only one can be set
+	// if not set, and hpa min >= 2, will create pdb with maxUnavailable=1
+	// +optional
+	Disruption *SomeDisruption `json:"disruption,omitempty"`
+
+	// create networkpolicy for pods, allow callers and dependencies by someapp name,
+	// namespaces or cidrs, if not set, will not create networkpolicy
+	// +optional
+	Network *SomeNetwork `json:"network,omitempty"`
+
 	// only used when spec.type == api
 	// stage=stable, will create stable vs, dr
 	// stage=canary, will createOrPatch canary vs,dr
 	// canary vs default weight=0
-	// +kubebuilder:validation:XValidation:rule="self == oldSelf",message="spec.enableIstio is immutable"
+	// set false later, will delete stable vs, dr, or remove canary route and subset
 	// +kubebuilder:default=false
 	// +optional
 	EnableIstio bool `json:"enableIstio,omitempty"`
+
+	// istio config, only used when enableIstio
+	// +optional
+	Istio *SomeIstioConfig `json:"istio,omitempty"`
+
+	// what to do with child resources when someapp deleted, default Delete with someapp,
+	// Orphan or Retain kinds to keep them, for migrate app to other someapps or off the operator
+	// +optional
+	DeletionPolicy *SomeDeletionPolicy `json:"deletionPolicy,omitempty"`
+
+	// pause reconcile, child resources will not be created, changed or deleted,
+	// status still reported, same as annotation ops.some.cn/paused: "true"
+	// +optional
+	Paused bool `json:"paused,omitempty"`
+}
+
+// IsPaused check spec.paused or paused annotation
+func (s *Someapp) IsPaused() bool {
+	return s.Spec.Paused || s.GetAnnotations()[PausedAnnotation] == "true"
+}
+
+// SomeServiceAccount is the pod serviceaccount,
+// create=true, operator create serviceaccount and role/rolebinding of rules,
+// create=false, only use an existing serviceaccount of name
+// +kubebuilder:validation:XValidation:rule="self.create || has(self.name)",message="name is required when create is false"
+// +kubebuilder:validation:XValidation:rule="self.create || (!has(self.rules) && !has(self.annotations))",message="rules and annotations only work when create is true"
+type SomeServiceAccount struct {
+	// serviceaccount name, default the deployment name when create
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// create serviceaccount owned by this someapp
+	// +optional
+	Create bool `json:"create,omitempty"`
+
+	// serviceaccount annotations, like workload identity
+	// eks.amazonaws.com/role-arn or iam.gke.io/gcp-service-account
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+
+	// +optional
+	AutomountToken *bool `json:"automountToken,omitempty"`
+
+	// k8s standard rbac rules, create role and rolebinding in namespace
+	// +optional
+	Rules []rbac_v1.PolicyRule `json:"rules,omitempty"`
+}
+
+// SomeVolume is a pod volume with where to mount it,
+// only one of configMap, secret, emptyDir, persistentVolumeClaim, projected, downwardAPI can be set
+// +kubebuilder:validation:XValidation:rule="[has(self.configMap),has(self.secret),has(self.emptyDir),has(self.persistentVolumeClaim),has(self.projected),has(self.downwardAPI)].filter(x, x).size() == 1",message="exactly one volume source must be set"
+type SomeVolume struct {
+	// volume name, must be unique in pod
+	// +kubebuilder:validation:Required
+	Name string `json:"name"`
+
+	// +optional
+	ConfigMap *core_v1.ConfigMapVolumeSource `json:"configMap,omitempty"`
+
+	// +optional
+	Secret *core_v1.SecretVolumeSource `json:"secret,omitempty"`
+
+	// +optional
+	EmptyDir *core_v1.EmptyDirVolumeSource `json:"emptyDir,omitempty"`
+
+	// +optional
+	PersistentVolumeClaim *core_v1.PersistentVolumeClaimVolumeSource `json:"persistentVolumeClaim,omitempty"`
+
+	// inline config, like configmap and secret items projected into one dir
+	// +optional
+	Projected *core_v1.ProjectedVolumeSource `json:"projected,omitempty"`
+
+	// +optional
+	DownwardAPI *core_v1.DownwardAPIVolumeSource `json:"downwardAPI,omitempty"`
+
+	// where to mount this volume, if empty, volume only add to pod
+	// +optional
+	Mounts []SomeVolumeMount `json:"mounts,omitempty"`
+}
+
+// SomeVolumeMount mount a volume to one container
+type SomeVolumeMount struct {
+	// container name, default app
+	// +kubebuilder:default=app
+	// +optional
+	Container string `json:"container,omitempty"`
+
+	// +kubebuilder:validation:Required
+	MountPath string `json:"mountPath"`
+
+	// +optional
+	SubPath string `json:"subPath,omitempty"`
+
+	// +optional
+	ReadOnly bool `json:"readOnly,omitempty"`
+}
+
+// SomeConfig is inline config data, files mount to container app, env add to container app
+type SomeConfig struct {
+	// filename --> content, mount under mountPath
+	// +kubebuilder:validation:XValidation:rule="self.all(k, !k.startsWith('env.'))",message="file name can not start with env."
+	// +optional
+	Files map[string]string `json:"files,omitempty"`
+
+	// env name --> value
+	// +optional
+	Env map[string]string `json:"env,omitempty"`
+
+	// dir of files in container app, default /app/config
+	// +kubebuilder:default=/app/config
+	// +optional
+	MountPath string `json:"mountPath,omitempty"`
+
+	// how many old config revisions to keep, default 5
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:default=5
+	// +optional
+	RevisionHistoryLimit *int32 `json:"revisionHistoryLimit,omitempty"`
+}
+
+// SomeServiceSpec is the service of someapp
+type SomeServiceSpec struct {
+	// Headless is ClusterIP service with clusterIP None
+	// +kubebuilder:validation:Enum=ClusterIP;NodePort;LoadBalancer;Headless
+	// +kubebuilder:default=ClusterIP
+	// +optional
+	Type string `json:"type,omitempty"`
+
+	// +listType=map
+	// +listMapKey=name
+	// +optional
+	Ports []SomeServicePort `json:"ports,omitempty"`
+
+	// service annotations, like cloud loadbalancer config
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+
+	// +kubebuilder:validation:Enum=None;ClientIP
+	// +optional
+	SessionAffinity core_v1.ServiceAffinity `json:"sessionAffinity,omitempty"`
+}
+
+// SomeServicePort is one port of service, istio use name prefix or appProtocol select protocol
+type SomeServicePort struct {
+	// +kubebuilder:validation:Required
+	Name string `json:"name"`
+
+	// +kubebuilder:validation:Required
+	Port int32 `json:"port"`
+
+	// container port number or name, default same as port
+	// +optional
+	TargetPort *intstr.IntOrString `json:"targetPort,omitempty"`
+
+	// +kubebuilder:validation:Enum=TCP;UDP;SCTP
+	// +kubebuilder:default=TCP
+	// +optional
+	Protocol core_v1.Protocol `json:"protocol,omitempty"`
+
+	// like http, http2, grpc, tcp
+	// +optional
+	AppProtocol *string `json:"appProtocol,omitempty"`
+
+	// only used when type is NodePort or LoadBalancer
+	// +optional
+	NodePort int32 `json:"nodePort,omitempty"`
+}
+
+// SomeExpose is the north-south entry of someapp
+type SomeExpose struct {
+	// external hostnames, like app.example.com
+	// +kubebuilder:validation:MinItems=1
+	Hosts []string `json:"hosts"`
+
+	// path prefixes, default /
+	// +optional
+	Paths []string `json:"paths,omitempty"`
+
+	// istio gateway, like istio-system/ingressgateway, tls config on the gateway
+	// +optional
+	Gateway string `json:"gateway,omitempty"`
+
+	// ingress tls secret of hosts, only used by ingress
+	// +optional
+	TLSSecret string `json:"tlsSecret,omitempty"`
+
+	// ingress class, only used by ingress
+	// +optional
+	IngressClassName *string `json:"ingressClassName,omitempty"`
+
+	// service port name of ingress backend, default http, only used by ingress
+	// +optional
+	Port string `json:"port,omitempty"`
+}
+
+// SomeDisruption is the pdb of someapp deployment
+// +kubebuilder:validation:XValidation:rule="!(has(self.minAvailable) && has(self.maxUnavailable))",message="only one of minAvailable and maxUnavailable can be set"
+type SomeDisruption struct {
+	// +optional
+	MinAvailable *intstr.IntOrString `json:"minAvailable,omitempty"`
+
+	// +optional
+	MaxUnavailable *intstr.IntOrString `json:"maxUnavailable,omitempty"`
+}
+
+// SomeDeletionPolicy of child resources, kept ones have ownerReference to someapp removed before deleted
+// +kubebuilder:validation:XValidation:rule="self.policy != 'Retain' || (has(self.kinds) && size(self.kinds) > 0)",message="kinds must be set when policy is Retain"
+type SomeDeletionPolicy struct {
+	// Delete child resources with someapp, Orphan keep all of them, Retain keep kinds listed
+	// +kubebuilder:validation:Enum=Delete;Orphan;Retain
+	// +kubebuilder:default=Delete
+	Policy string `json:"policy"`
+
+	// kinds kept when policy is Retain
+	// +optional
+	Kinds []SomeChildKind `json:"kinds,omitempty"`
+}
+
+// SomeChildKind kind of child resources
+// +kubebuilder:validation:Enum=Deployment;Service;HorizontalPodAutoscaler;PodDisruptionBudget;NetworkPolicy;Ingress;ServiceAccount;Role;RoleBinding;ConfigMap;VirtualService;DestinationRule;PeerAuthentication;AuthorizationPolicy
+type SomeChildKind string
+
+// Retains check child resources of kind kept when someapp deleted
+func (p *SomeDeletionPolicy) Retains(kind string) bool {
+	if p == nil {
+		return false
+	}
+	switch p.Policy {
+	case DeletionPolicyOrphan:
+		return true
+	case DeletionPolicyRetain:
+		for _, k := range p.Kinds {
+			if string(k) == kind {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RetainsAny check some child resources kept when someapp deleted
+func (p *SomeDeletionPolicy) RetainsAny() bool {
+	return p != nil && (p.Policy == DeletionPolicyOrphan || (p.Policy == DeletionPolicyRetain && len(p.Kinds) > 0))
+}
+
+// SomeNetwork is the networkpolicy of someapp pods,
+// ingress or egress not set means not restricted
+type SomeNetwork struct {
+	// allowed callers
+	// +optional
+	Ingress *SomeNetworkRule `json:"ingress,omitempty"`
+
+	// allowed dependencies, dns to kube-system always allowed
+	// +optional
+	Egress *SomeNetworkRule `json:"egress,omitempty"`
+}
+
+// SomeNetworkRule allow traffic from or to these peers, all empty means deny all
+type SomeNetworkRule struct {
+	// someapp spec.name, like app-a in same namespace, or ns-b/app-b
+	// +optional
+	Apps []string `json:"apps,omitempty"`
+
+	// all pods in these namespaces
+	// +optional
+	Namespaces []string `json:"namespaces,omitempty"`
+
+	// ip blocks, like 10.0.0.0/8
+	// +optional
+	CIDRs []string `json:"cidrs,omitempty"`
+
+	// k8s standard networkpolicy ports, empty means all ports
+	// +optional
+	Ports []networking_v1.NetworkPolicyPort `json:"ports,omitempty"`
+}
+
+// SomeIstioConfig is the istio config of someapp
+type SomeIstioConfig struct {
+	// http route policy, render on the stable route by stable someapp,
+	// and on the canary route by canary someapp
+	// +optional
+	Http *SomeIstioHttp `json:"http,omitempty"`
+
+	// destinationrule traffic policy, stable someapp set it on dr level,
+	// canary someapp set it on its own subset, so canary can carry different policy
+	// +optional
+	TrafficPolicy *SomeIstioTrafficPolicy `json:"trafficPolicy,omitempty"`
+
+	// peerauthentication and authorizationpolicy select pods with label app,
+	// only stable someapp create them
+	// +optional
+	Security *SomeIstioSecurity `json:"security,omitempty"`
+
+	// percent of traffic to canary on its route, others to stable, only used by canary someapp,
+	// if not set, weights in vs are kept, canary route created with weight 0
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	// +optional
+	Weight *int32 `json:"weight,omitempty"`
+}
+
+// SomeIstioHttp is timeout, retries and fault of http route, durations like 1s, 500ms
+type SomeIstioHttp struct {
+	// +optional
+	Timeout string `json:"timeout,omitempty"`
+
+	// +optional
+	Retries *SomeIstioRetries `json:"retries,omitempty"`
+
+	// fault injection, for chaos testing only
+	// +optional
+	Fault *SomeIstioFault `json:"fault,omitempty"`
+}
+
+// SomeIstioRetries is retry policy of http route
+type SomeIstioRetries struct {
+	// +kubebuilder:validation:Minimum=0
+	Attempts int32 `json:"attempts"`
+
+	// +optional
+	PerTryTimeout string `json:"perTryTimeout,omitempty"`
+
+	// like 5xx,connect-failure,reset
+	// +optional
+	RetryOn string `json:"retryOn,omitempty"`
+}
+
+// SomeIstioFault is fault injection of http route
+type SomeIstioFault struct {
+	// +optional
+	Delay *SomeIstioFaultDelay `json:"delay,omitempty"`
+
+	// +optional
+	Abort *SomeIstioFaultAbort `json:"abort,omitempty"`
+}
+
+// SomeIstioFaultDelay delay percent of requests
+type SomeIstioFaultDelay struct {
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	Percent int32 `json:"percent"`
+
+	FixedDelay string `json:"fixedDelay"`
+}
+
+// SomeIstioFaultAbort abort percent of requests with httpStatus
+type SomeIstioFaultAbort struct {
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	Percent int32 `json:"percent"`
+
+	// +kubebuilder:validation:Minimum=200
+	// +kubebuilder:validation:Maximum=599
+	HttpStatus int32 `json:"httpStatus"`
+}
+
+// SomeIstioTrafficPolicy is the traffic policy of destinationrule, durations like 1s, 500ms
+type SomeIstioTrafficPolicy struct {
+	// +optional
+	ConnectionPool *SomeIstioConnectionPool `json:"connectionPool,omitempty"`
+
+	// circuit breaking
+	// +optional
+	OutlierDetection *SomeIstioOutlierDetection `json:"outlierDetection,omitempty"`
+
+	// +optional
+	LoadBalancer *SomeIstioLoadBalancer `json:"loadBalancer,omitempty"`
+
+	// tls mode to upstream
+	// +kubebuilder:validation:Enum=DISABLE;SIMPLE;MUTUAL;ISTIO_MUTUAL
+	// +optional
+	TLSMode string `json:"tlsMode,omitempty"`
+}
+
+// SomeIstioConnectionPool is tcp and http connection pool limits
+type SomeIstioConnectionPool struct {
+	// +optional
+	MaxConnections int32 `json:"maxConnections,omitempty"`
+
+	// +optional
+	ConnectTimeout string `json:"connectTimeout,omitempty"`
+
+	// +optional
+	Http1MaxPendingRequests int32 `json:"http1MaxPendingRequests,omitempty"`
+
+	// +optional
+	Http2MaxRequests int32 `json:"http2MaxRequests,omitempty"`
+
+	// +optional
+	MaxRequestsPerConnection int32 `json:"maxRequestsPerConnection,omitempty"`
+
+	// +optional
+	MaxRetries int32 `json:"maxRetries,omitempty"`
+
+	// +optional
+	IdleTimeout string `json:"idleTimeout,omitempty"`
+}
+
+// SomeIstioOutlierDetection eject unhealthy hosts from load balancing pool
+type SomeIstioOutlierDetection struct {
+	// +optional
+	Consecutive5xxErrors *int32 `json:"consecutive5xxErrors,omitempty"`
+
+	// +optional
+	Interval string `json:"interval,omitempty"`
+
+	// +optional
+	BaseEjectionTime string `json:"baseEjectionTime,omitempty"`
+
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	// +optional
+	MaxEjectionPercent int32 `json:"maxEjectionPercent,omitempty"`
+}
+
+// SomeIstioLoadBalancer is simple lb type or consistent hash, only one can be set
+// +kubebuilder:validation:XValidation:rule="!(has(self.simple) && has(self.consistentHash))",message="only one of simple and consistentHash can be set"
+type SomeIstioLoadBalancer struct {
+	// +kubebuilder:validation:Enum=LEAST_REQUEST;ROUND_ROBIN;RANDOM;PASSTHROUGH
+	// +optional
+	Simple string `json:"simple,omitempty"`
+
+	// +optional
+	ConsistentHash *SomeIstioConsistentHash `json:"consistentHash,omitempty"`
+}
+
+// SomeIstioConsistentHash hash key of consistent hash lb, only one can be set
+// +kubebuilder:validation:XValidation:rule="[has(self.httpHeaderName),has(self.httpCookie),has(self.useSourceIp) && self.useSourceIp,has(self.httpQueryParameterName)].filter(x, x).size() == 1",message="exactly one hash key must be set"
+type SomeIstioConsistentHash struct {
+	// +optional
+	HttpHeaderName string `json:"httpHeaderName,omitempty"`
+
+	// +optional
+	HttpCookie *SomeIstioHttpCookie `json:"httpCookie,omitempty"`
+
+	// +optional
+	UseSourceIp bool `json:"useSourceIp,omitempty"`
+
+	// +optional
+	HttpQueryParameterName string `json:"httpQueryParameterName,omitempty"`
+}
+
+// SomeIstioHttpCookie is the cookie of consistent hash, generated if not exist when ttl set
+type SomeIstioHttpCookie struct {
+	Name string `json:"name"`
+
+	// +optional
+	Path string `json:"path,omitempty"`
+
+	// +optional
+	Ttl string `json:"ttl,omitempty"`
+}
+
+// SomeIstioSecurity is mtls mode and allowed callers of someapp
+type SomeIstioSecurity struct {
+	// if not set, will not create peerauthentication
+	// +kubebuilder:validation:Enum=STRICT;PERMISSIVE;DISABLE
+	// +optional
+	MTLSMode string `json:"mtlsMode,omitempty"`
+
+	// allowed callers, all empty will not create authorizationpolicy
+	// someapp spec.name, like app-a in same namespace, or ns-b/app-b, use their serviceaccounts
+	// +optional
+	Apps []string `json:"apps,omitempty"`
+
+	// +optional
+	Namespaces []string `json:"namespaces,omitempty"`
+
+	// like cluster.local/ns/default/sa/sleep
+	// +optional
+	Principals []string `json:"principals,omitempty"`
 }
 
 // SomeappStatus defines the observed state of Someapp
@@ -95,6 +635,21 @@ type SomeappStatus struct {
 	// Important: Run "make" to regenerate code after modifying this file
 	Status             someAppSts `json:"status"`
 	ObservedGeneration int64      `json:"observedGeneration"`
+
+	// conditions of someapp, like WaitingForStable of canary,
+	// Drifted when child resources changed out of band and reverted,
+	// Available when deployment rolled out and all replicas available,
+	// Paused when spec.paused or paused annotation set,
+	// DryRun when dry-run annotation or operator flag set,
+	// Adopted when existing resources adopted
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// changes of child resources planned by dry-run, not applied
+	// +optional
+	Plan []string `json:"plan,omitempty"`
 }
 
 type someAppSts struct {
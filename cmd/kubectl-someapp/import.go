@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/pflag"
+	istio_network_v1beta1 "istio.io/client-go/pkg/apis/networking/v1beta1"
+	apps_v1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	core_v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8s_yaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+
+	"github.com/changqings/some-app-operator/pkg/importer"
+)
+
+func importCommand() *command {
+	var filename string
+	return &command{
+		offline: true,
+		flags: func(fs *pflag.FlagSet) {
+			fs.StringVarP(&filename, "filename", "f", "", "file or directory of manifests, - for stdin, if not set, scan namespace in cluster")
+		},
+		run: func(ctx context.Context, o *options, _ string, _ []string) error {
+			var (
+				objs importer.Objects
+				err  error
+			)
+			if len(filename) > 0 {
+				namespace := o.namespace
+				if len(namespace) == 0 {
+					namespace = "default"
+				}
+				objs, err = readObjects(filename, namespace)
+			} else {
+				if err := o.complete(); err != nil {
+					return err
+				}
+				objs, err = listObjects(ctx, o.client, o.namespace)
+			}
+			if err != nil {
+				return err
+			}
+
+			workloads := importer.Group(objs)
+			if len(workloads) == 0 {
+				return errors.New("no deployment to import")
+			}
+
+			flagged := 0
+			for i, w := range workloads {
+				someApp, notes := importer.Convert(w)
+				if len(notes) > 0 {
+					flagged++
+				}
+
+				u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(someApp)
+				if err != nil {
+					return err
+				}
+				unstructured.RemoveNestedField(u, "metadata", "creationTimestamp")
+				unstructured.RemoveNestedField(u, "status")
+				data, err := yaml.Marshal(u)
+				if err != nil {
+					return err
+				}
+
+				if i > 0 {
+					fmt.Fprintln(os.Stdout, "---")
+				}
+				fmt.Fprintf(os.Stdout, "# imported from deployment %s/%s\n", w.Deployment.Namespace, w.Deployment.Name)
+				for _, note := range notes {
+					fmt.Fprintf(os.Stdout, "# warning: %s\n", note)
+				}
+				if _, err := os.Stdout.Write(data); err != nil {
+					return err
+				}
+			}
+			fmt.Fprintf(os.Stderr, "%d someapps imported, %d with warnings, see comments\n", len(workloads), flagged)
+			return nil
+		},
+	}
+}
+
+// listObjects list deployments, services, hpas, vs and dr of namespace
+func listObjects(ctx context.Context, c client.Client, namespace string) (importer.Objects, error) {
+	var objs importer.Objects
+
+	deployments := &apps_v1.DeploymentList{}
+	services := &core_v1.ServiceList{}
+	hpas := &autoscalingv2.HorizontalPodAutoscalerList{}
+	for _, list := range []client.ObjectList{deployments, services, hpas} {
+		if err := c.List(ctx, list, client.InNamespace(namespace)); err != nil {
+			return objs, err
+		}
+	}
+	for i := range deployments.Items {
+		objs.Deployments = append(objs.Deployments, &deployments.Items[i])
+	}
+	for i := range services.Items {
+		objs.Services = append(objs.Services, &services.Items[i])
+	}
+	for i := range hpas.Items {
+		objs.Hpas = append(objs.Hpas, &hpas.Items[i])
+	}
+
+	// istio may not be installed
+	vss := &istio_network_v1beta1.VirtualServiceList{}
+	if err := c.List(ctx, vss, client.InNamespace(namespace)); err != nil {
+		fmt.Fprintln(os.Stderr, "warning: list virtualservices:", err)
+	}
+	drs := &istio_network_v1beta1.DestinationRuleList{}
+	if err := c.List(ctx, drs, client.InNamespace(namespace)); err != nil {
+		fmt.Fprintln(os.Stderr, "warning: list destinationrules:", err)
+	}
+	objs.VirtualServices = vss.Items
+	objs.DestinationRules = drs.Items
+	return objs, nil
+}
+
+// readObjects read objects of file, stdin, or yaml and json files of directory,
+// lists like kubectl get -o yaml output are expanded, namespace set if not
+func readObjects(filename, namespace string) (importer.Objects, error) {
+	var objs importer.Objects
+
+	read := func(in io.Reader, name string) error {
+		decoder := k8s_yaml.NewYAMLOrJSONDecoder(in, 4096)
+		for {
+			u := &unstructured.Unstructured{}
+			if err := decoder.Decode(&u.Object); err != nil {
+				if errors.Is(err, io.EOF) {
+					return nil
+				}
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			if len(u.Object) == 0 {
+				continue
+			}
+			items := []unstructured.Unstructured{*u}
+			if u.IsList() {
+				list, err := u.ToList()
+				if err != nil {
+					return fmt.Errorf("%s: %w", name, err)
+				}
+				items = list.Items
+			}
+			for i := range items {
+				if err := addObject(&objs, &items[i], namespace); err != nil {
+					return fmt.Errorf("%s: %s %s: %w", name, items[i].GetKind(), items[i].GetName(), err)
+				}
+			}
+		}
+	}
+
+	if filename == "-" {
+		return objs, read(os.Stdin, "stdin")
+	}
+	err := filepath.WalkDir(filename, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		// files in directory are filtered by extension, a file given is always read
+		if path != filename {
+			switch filepath.Ext(path) {
+			case ".yaml", ".yml", ".json":
+			default:
+				return nil
+			}
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return read(f, path)
+	})
+	return objs, err
+}
+
+// addObject add object of kinds to import, others are skipped
+func addObject(objs *importer.Objects, u *unstructured.Unstructured, namespace string) error {
+	if len(u.GetNamespace()) == 0 {
+		u.SetNamespace(namespace)
+	}
+
+	gvk := u.GroupVersionKind()
+	var obj interface{}
+	switch gvk.GroupKind().String() {
+	case "Deployment.apps":
+		d := &apps_v1.Deployment{}
+		objs.Deployments = append(objs.Deployments, d)
+		obj = d
+	case "Service":
+		s := &core_v1.Service{}
+		objs.Services = append(objs.Services, s)
+		obj = s
+	case "HorizontalPodAutoscaler.autoscaling":
+		if gvk.Version != "v2" {
+			fmt.Fprintf(os.Stderr, "warning: hpa %s of %s skipped, only autoscaling/v2\n", u.GetName(), gvk.Version)
+			return nil
+		}
+		h := &autoscalingv2.HorizontalPodAutoscaler{}
+		objs.Hpas = append(objs.Hpas, h)
+		obj = h
+	// v1 and v1beta1 of istio have the same schema
+	case "VirtualService.networking.istio.io":
+		vs := &istio_network_v1beta1.VirtualService{}
+		objs.VirtualServices = append(objs.VirtualServices, vs)
+		obj = vs
+	case "DestinationRule.networking.istio.io":
+		dr := &istio_network_v1beta1.DestinationRule{}
+		objs.DestinationRules = append(objs.DestinationRules, dr)
+		obj = dr
+	default:
+		return nil
+	}
+	return runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, obj)
+}
@@ -0,0 +1,192 @@
+/*
+Copyright 2023 changqings.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// kubectl-someapp is a kubectl plugin for day-to-day operations of someapps,
+// install it to PATH and run kubectl someapp
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/pflag"
+	istio_network_v1beta1 "istio.io/client-go/pkg/apis/networking/v1beta1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	opsv1 "github.com/changqings/some-app-operator/api/v1"
+)
+
+const usage = `kubectl someapp operates someapps
+
+Usage:
+  kubectl someapp <command> NAME [flags]
+  kubectl someapp render -f FILE [flags]
+  kubectl someapp import [-f FILE|DIR] [flags]
+
+Commands:
+  status      show children and conditions of someapp
+  promote     copy containers of canary someapp to stable, wait stable rolled out, then delete canary
+  abort       move traffic of canary someapp back to stable
+  rollback    rollback container images to a deployment revision
+  pause       pause reconcile of someapp, child resources can be hand edited
+  resume      resume reconcile of someapp, hand edits are reverted
+  set-weight  set percent of traffic to canary someapp
+  restart     rolling restart pods of someapp
+  render      print manifests the operator would create for someapps in file, offline
+  import      print someapps of deployments with their service, hpa, vs and dr in namespace or files,
+              fields someapp can not represent are listed as comments
+
+Flags:
+  -n, --namespace   namespace of someapp, default namespace of kubeconfig context
+      --kubeconfig  path to kubeconfig
+      --context     kubeconfig context
+`
+
+var scheme = runtime.NewScheme()
+
+func init() {
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(istio_network_v1beta1.AddToScheme(scheme))
+	utilruntime.Must(opsv1.AddToScheme(scheme))
+}
+
+// command of plugin, run with someapp name and other args
+type command struct {
+	// args after NAME, like weight of set-weight
+	args int
+	// offline command take no NAME and complete client itself if needed, like render and import
+	offline bool
+	// add flags of command
+	flags func(fs *pflag.FlagSet)
+	run   func(ctx context.Context, o *options, name string, args []string) error
+}
+
+var commands = map[string]*command{
+	"status":     statusCommand(),
+	"promote":    promoteCommand(),
+	"abort":      abortCommand(),
+	"rollback":   rollbackCommand(),
+	"pause":      pauseCommand(true),
+	"resume":     pauseCommand(false),
+	"set-weight": setWeightCommand(),
+	"restart":    restartCommand(),
+	"render":     renderCommand(),
+	"import":     importCommand(),
+}
+
+// options of kubeconfig, client and namespace of someapp
+type options struct {
+	kubeconfig  string
+	kubeContext string
+	namespace   string
+
+	client client.Client
+}
+
+func main() {
+	if err := run(context.Background(), os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string) error {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" || args[0] == "help" {
+		fmt.Print(usage)
+		return nil
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprint(os.Stderr, usage)
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+
+	o := &options{}
+	fs := pflag.NewFlagSet("kubectl someapp "+args[0], pflag.ContinueOnError)
+	fs.StringVarP(&o.namespace, "namespace", "n", "", "namespace of someapp")
+	fs.StringVar(&o.kubeconfig, "kubeconfig", "", "path to kubeconfig")
+	fs.StringVar(&o.kubeContext, "context", "", "kubeconfig context")
+	if cmd.flags != nil {
+		cmd.flags(fs)
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if cmd.offline {
+		if fs.NArg() != cmd.args {
+			return fmt.Errorf("%s needs %d args, got %v", args[0], cmd.args, fs.Args())
+		}
+		return cmd.run(ctx, o, "", fs.Args())
+	}
+	if fs.NArg() != cmd.args+1 {
+		return fmt.Errorf("%s needs NAME and %d more args, got %v", args[0], cmd.args, fs.Args())
+	}
+
+	if err := o.complete(); err != nil {
+		return err
+	}
+	return cmd.run(ctx, o, fs.Arg(0), fs.Args()[1:])
+}
+
+// complete load kubeconfig, create client and default namespace of context
+func (o *options) complete() error {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = o.kubeconfig
+	config := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{
+		CurrentContext: o.kubeContext,
+		Context:        clientcmdapi.Context{Namespace: o.namespace},
+	})
+
+	restConfig, err := config.ClientConfig()
+	if err != nil {
+		return err
+	}
+	// namespace of flag, or of context
+	if o.namespace, _, err = config.Namespace(); err != nil {
+		return err
+	}
+
+	o.client, err = client.New(restConfig, client.Options{Scheme: scheme})
+	return err
+}
+
+// getSomeapp get someapp of name in namespace of options
+func (o *options) getSomeapp(ctx context.Context, name string) (*opsv1.Someapp, error) {
+	someApp := &opsv1.Someapp{}
+	if err := o.client.Get(ctx, client.ObjectKey{Namespace: o.namespace, Name: name}, someApp); err != nil {
+		return nil, err
+	}
+	return someApp, nil
+}
+
+// patchSomeapp merge patch someapp changed by mutate, conflict if changed by others at the same time
+func (o *options) patchSomeapp(ctx context.Context, someApp *opsv1.Someapp, mutate func(someApp *opsv1.Someapp) error) error {
+	patch := client.MergeFromWithOptions(someApp.DeepCopy(), client.MergeFromWithOptimisticLock{})
+	if err := mutate(someApp); err != nil {
+		return err
+	}
+	return o.client.Patch(ctx, someApp, patch)
+}
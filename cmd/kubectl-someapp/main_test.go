@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	apps_v1 "k8s.io/api/apps/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	opsv1 "github.com/changqings/some-app-operator/api/v1"
+)
+
+func testSomeapp(name, version string, enableIstio bool) *opsv1.Someapp {
+	return &opsv1.Someapp{
+		ObjectMeta: meta_v1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: opsv1.SomeappSpec{
+			AppName:     "app-a",
+			AppType:     opsv1.AppTypeApi,
+			AppVersion:  version,
+			EnableIstio: enableIstio,
+		},
+	}
+}
+
+func TestRevision(t *testing.T) {
+	stable := testSomeapp("app-a", opsv1.StableStage, true)
+	deploy := &apps_v1.Deployment{ObjectMeta: meta_v1.ObjectMeta{
+		Name: "app-a", Namespace: "default", UID: types.UID("uid-deploy"),
+		Annotations: map[string]string{revisionAnnotation: "3"},
+	}}
+	replicaSet := func(name, revision string, owner types.UID) *apps_v1.ReplicaSet {
+		isController := true
+		return &apps_v1.ReplicaSet{ObjectMeta: meta_v1.ObjectMeta{
+			Name: name, Namespace: "default",
+			Annotations:     map[string]string{revisionAnnotation: revision},
+			OwnerReferences: []meta_v1.OwnerReference{{APIVersion: "apps/v1", Kind: "Deployment", Name: "app-a", UID: owner, Controller: &isController}},
+		}}
+	}
+
+	tests := []struct {
+		name     string
+		rss      []client.Object
+		revision int64
+		want     string
+		wantErr  bool
+	}{
+		{
+			name: "previous",
+			rss:  []client.Object{replicaSet("rs-1", "1", deploy.UID), replicaSet("rs-2", "2", deploy.UID), replicaSet("rs-3", "3", deploy.UID)},
+			want: "rs-2",
+		},
+		{
+			name: "previous of this deployment only",
+			rss:  []client.Object{replicaSet("rs-1", "1", deploy.UID), replicaSet("other-2", "2", "uid-other"), replicaSet("rs-3", "3", deploy.UID)},
+			want: "rs-1",
+		},
+		{
+			name:     "given revision",
+			rss:      []client.Object{replicaSet("rs-1", "1", deploy.UID), replicaSet("rs-2", "2", deploy.UID)},
+			revision: 1,
+			want:     "rs-1",
+		},
+		{name: "revision not found", rss: []client.Object{replicaSet("rs-1", "1", deploy.UID)}, revision: 5, wantErr: true},
+		{name: "no previous", rss: []client.Object{replicaSet("rs-3", "3", deploy.UID)}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := &options{namespace: "default", client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(deploy).WithObjects(tt.rss...).Build()}
+			rs, err := o.revision(context.Background(), stable, tt.revision)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("revision() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && rs.Name != tt.want {
+				t.Errorf("revision() = %s, want %s", rs.Name, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetCanary(t *testing.T) {
+	tests := []struct {
+		name    string
+		someApp *opsv1.Someapp
+		wantErr string
+	}{
+		{name: "canary", someApp: testSomeapp("app-a-canary", "canary-v1", true)},
+		{name: "stable", someApp: testSomeapp("app-a-canary", opsv1.StableStage, true), wantErr: "is stable"},
+		{name: "istio not enabled", someApp: testSomeapp("app-a-canary", "canary-v1", false), wantErr: "not enable istio"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := &options{namespace: "default", client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(tt.someApp).Build()}
+			_, err := o.getCanary(context.Background(), tt.someApp.Name)
+			if len(tt.wantErr) == 0 && err != nil || len(tt.wantErr) > 0 && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("getCanary() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGetStableAndSetWeight(t *testing.T) {
+	stable := testSomeapp("app-a", opsv1.StableStage, true)
+	canary := testSomeapp("app-a-canary", "canary-v1", true)
+	o := &options{namespace: "default", client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(stable, canary).Build()}
+	ctx := context.Background()
+
+	got, err := o.getStable(ctx, "app-a")
+	if err != nil || got.Name != stable.Name {
+		t.Fatalf("getStable() = %v, %v, want %s", got, err, stable.Name)
+	}
+	if _, err := o.getStable(ctx, "app-b"); err == nil {
+		t.Errorf("getStable() of app-b found, want error")
+	}
+
+	if err := o.setWeight(ctx, canary, 30); err != nil {
+		t.Fatalf("setWeight() error = %v", err)
+	}
+	updated, err := o.getSomeapp(ctx, canary.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := updated.Spec.Istio.Weight; w == nil || *w != 30 {
+		t.Errorf("weight = %v, want 30", w)
+	}
+}
+
+func TestReadSomeapps(t *testing.T) {
+	in := `apiVersion: ops.some.cn/v1
+kind: Someapp
+metadata:
+  name: app-a
+spec:
+  name: app-a
+  type: api
+  version: stable
+  containers: []
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: skipped
+---
+apiVersion: ops.some.cn/v1
+kind: Someapp
+metadata:
+  name: app-b
+  namespace: other
+spec:
+  name: app-b
+  type: api
+  version: stable
+  containers: []
+`
+	someApps, err := readSomeapps(strings.NewReader(in), "default")
+	if err != nil {
+		t.Fatalf("readSomeapps() error = %v", err)
+	}
+	var got []string
+	for _, someApp := range someApps {
+		got = append(got, someApp.Namespace+"/"+someApp.Name)
+	}
+	if strings.Join(got, ",") != "default/app-a,other/app-b" {
+		t.Errorf("readSomeapps() = %v", got)
+	}
+
+	if _, err := readSomeapps(strings.NewReader("apiVersion: ops.some.cn/v1\nkind: Someapp\nspec:\n  unknown: 1\n"), "default"); err == nil {
+		t.Errorf("readSomeapps() of unknown field, want error")
+	}
+}
+
+func TestReadObjects(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"deploy.yaml": `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app-a
+`,
+		"list.json": `{"apiVersion": "v1", "kind": "List", "items": [
+  {"apiVersion": "v1", "kind": "Service", "metadata": {"name": "app-a", "namespace": "other"}},
+  {"apiVersion": "autoscaling/v1", "kind": "HorizontalPodAutoscaler", "metadata": {"name": "app-a"}},
+  {"apiVersion": "autoscaling/v2", "kind": "HorizontalPodAutoscaler", "metadata": {"name": "app-a"}},
+  {"apiVersion": "networking.istio.io/v1", "kind": "VirtualService", "metadata": {"name": "app-a"}}
+]}`,
+		"notes.txt": "not yaml",
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	objs, err := readObjects(dir, "default")
+	if err != nil {
+		t.Fatalf("readObjects() error = %v", err)
+	}
+	if len(objs.Deployments) != 1 || objs.Deployments[0].Namespace != "default" {
+		t.Errorf("deployments = %v, want app-a in default", objs.Deployments)
+	}
+	if len(objs.Services) != 1 || objs.Services[0].Namespace != "other" {
+		t.Errorf("services = %v, want app-a in other", objs.Services)
+	}
+	if len(objs.Hpas) != 1 || len(objs.VirtualServices) != 1 || len(objs.DestinationRules) != 0 {
+		t.Errorf("hpas = %d, vs = %d, dr = %d, want 1, 1, 0", len(objs.Hpas), len(objs.VirtualServices), len(objs.DestinationRules))
+	}
+
+	// a file given is read whatever its extension
+	if _, err := readObjects(filepath.Join(dir, "notes.txt"), "default"); err == nil {
+		t.Errorf("readObjects() of notes.txt, want error")
+	}
+}
+
+func TestWaitRolledOut(t *testing.T) {
+	pollInterval = time.Millisecond
+	replicas := int32(2)
+	deploy := func(generation, observed int64, updated, available int32) *apps_v1.Deployment {
+		return &apps_v1.Deployment{
+			ObjectMeta: meta_v1.ObjectMeta{Name: "app-a", Namespace: "default", Generation: generation},
+			Spec:       apps_v1.DeploymentSpec{Replicas: &replicas},
+			Status: apps_v1.DeploymentStatus{ObservedGeneration: observed, Replicas: updated,
+				UpdatedReplicas: updated, AvailableReplicas: available},
+		}
+	}
+
+	tests := []struct {
+		name     string
+		observed int64
+		deploy   *apps_v1.Deployment
+		wantErr  string
+	}{
+		{name: "rolled out", observed: 2, deploy: deploy(3, 3, 2, 2)},
+		{name: "someapp not reconciled", observed: 1, deploy: deploy(3, 3, 2, 2), wantErr: "not reconciled by operator"},
+		{name: "deployment not observed", observed: 2, deploy: deploy(4, 3, 2, 2), wantErr: "not observed"},
+		{name: "replicas not updated", observed: 2, deploy: deploy(3, 3, 1, 1), wantErr: "1 of 2 replicas updated"},
+		{name: "deployment not found", observed: 2, wantErr: "deployment not found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stable := testSomeapp("app-a", opsv1.StableStage, true)
+			stable.Generation = 2
+			stable.Status.ObservedGeneration = tt.observed
+			b := fake.NewClientBuilder().WithScheme(scheme).WithObjects(stable)
+			if tt.deploy != nil {
+				b = b.WithObjects(tt.deploy)
+			}
+			o := &options{namespace: "default", client: b.Build()}
+
+			err := o.waitRolledOut(context.Background(), stable, 20*time.Millisecond)
+			if len(tt.wantErr) == 0 && err != nil || len(tt.wantErr) > 0 && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("waitRolledOut() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8s_yaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/yaml"
+
+	opsv1 "github.com/changqings/some-app-operator/api/v1"
+	"github.com/changqings/some-app-operator/pkg/istio"
+	"github.com/changqings/some-app-operator/pkg/render"
+)
+
+func renderCommand() *command {
+	var (
+		filename         string
+		istioAPIVersion  string
+		istioTrustDomain string
+	)
+	return &command{
+		offline: true,
+		flags: func(fs *pflag.FlagSet) {
+			fs.StringVarP(&filename, "filename", "f", "", "file of someapps, - for stdin, other kinds are skipped")
+			fs.StringVar(&istioAPIVersion, "istio-api-version", istio.APIVersionV1, "networking.istio.io version of vs and dr, v1 or v1beta1")
+			fs.StringVar(&istioTrustDomain, "istio-trust-domain", istio.DefaultTrustDomain, "trust domain of istio mesh, like --istio-trust-domain of the operator")
+		},
+		run: func(ctx context.Context, o *options, _ string, _ []string) error {
+			if len(filename) == 0 {
+				return errors.New("render needs -f FILE")
+			}
+			if istioAPIVersion != istio.APIVersionV1 && istioAPIVersion != istio.APIVersionV1beta1 {
+				return fmt.Errorf("istio-api-version must be %s or %s", istio.APIVersionV1, istio.APIVersionV1beta1)
+			}
+
+			in := os.Stdin
+			if filename != "-" {
+				f, err := os.Open(filename)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				in = f
+			}
+
+			namespace := o.namespace
+			if len(namespace) == 0 {
+				namespace = "default"
+			}
+			someApps, err := readSomeapps(in, namespace)
+			if err != nil {
+				return err
+			}
+			if len(someApps) == 0 {
+				return fmt.Errorf("no someapp in %s", filename)
+			}
+
+			objs, err := render.Render(ctx, someApps, istioAPIVersion, istioTrustDomain)
+			if err != nil {
+				return err
+			}
+			return printManifests(os.Stdout, objs)
+		},
+	}
+}
+
+// readSomeapps read someapps of yaml or json documents, namespace set if not
+func readSomeapps(in io.Reader, namespace string) ([]*opsv1.Someapp, error) {
+	var someApps []*opsv1.Someapp
+	decoder := k8s_yaml.NewYAMLOrJSONDecoder(in, 4096)
+	for {
+		u := &unstructured.Unstructured{}
+		if err := decoder.Decode(&u.Object); err != nil {
+			if errors.Is(err, io.EOF) {
+				return someApps, nil
+			}
+			return nil, err
+		}
+		if u.GroupVersionKind() != opsv1.GroupVersion.WithKind("Someapp") {
+			continue
+		}
+
+		someApp := &opsv1.Someapp{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructuredWithValidation(u.Object, someApp, true); err != nil {
+			return nil, fmt.Errorf("someapp %s: %w", u.GetName(), err)
+		}
+		if len(someApp.Namespace) == 0 {
+			someApp.Namespace = namespace
+		}
+		someApps = append(someApps, someApp)
+	}
+}
+
+// printManifests print objects as yaml documents
+func printManifests(out io.Writer, objs []*unstructured.Unstructured) error {
+	for i, obj := range objs {
+		data, err := yaml.Marshal(obj.Object)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			fmt.Fprintln(out, "---")
+		}
+		if _, err := out.Write(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
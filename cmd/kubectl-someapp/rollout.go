@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/spf13/pflag"
+	apps_v1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	opsv1 "github.com/changqings/some-app-operator/api/v1"
+	"github.com/changqings/some-app-operator/pkg/deployment"
+)
+
+// revision of deployment and its replicasets
+const revisionAnnotation = "deployment.kubernetes.io/revision"
+
+// pollInterval of waiting stable rolled out
+var pollInterval = 2 * time.Second
+
+func promoteCommand() *command {
+	var keepCanary bool
+	var timeout time.Duration
+	return &command{
+		flags: func(fs *pflag.FlagSet) {
+			fs.BoolVar(&keepCanary, "keep-canary", false, "not delete canary someapp after promoted")
+			fs.DurationVar(&timeout, "timeout", 10*time.Minute, "time to wait for stable rolled out before canary deleted")
+		},
+		run: func(ctx context.Context, o *options, name string, _ []string) error {
+			canary, err := o.getCanary(ctx, name)
+			if err != nil {
+				return err
+			}
+			stable, err := o.getStable(ctx, canary.Spec.AppName)
+			if err != nil {
+				return err
+			}
+			if stable.IsPaused() {
+				return fmt.Errorf("someapp/%s paused, stable would not roll out", stable.Name)
+			}
+
+			// stable rolls to canary containers, traffic kept by canary route until canary deleted
+			if err := o.patchSomeapp(ctx, stable, func(stable *opsv1.Someapp) error {
+				stable.Spec.Containers = canary.Spec.Containers
+				stable.Spec.Volumes = canary.Spec.Volumes
+				stable.Spec.SomeVolume = canary.Spec.SomeVolume
+				stable.Spec.Config = canary.Spec.Config
+				stable.Spec.ImagePullSecret = canary.Spec.ImagePullSecret
+				return nil
+			}); err != nil {
+				return err
+			}
+			fmt.Printf("someapp/%s promoted with containers of someapp/%s\n", stable.Name, canary.Name)
+
+			if keepCanary {
+				return nil
+			}
+			// canary deleted only after stable pods of new containers take its traffic,
+			// or canary weight moved at once to old stable pods
+			fmt.Printf("waiting for someapp/%s rolled out\n", stable.Name)
+			if err := o.waitRolledOut(ctx, stable, timeout); err != nil {
+				return fmt.Errorf("%w, someapp/%s not deleted", err, canary.Name)
+			}
+			// canary route and subset removed by finalizer
+			if err := o.client.Delete(ctx, canary); err != nil {
+				return err
+			}
+			fmt.Printf("someapp/%s deleted\n", canary.Name)
+			return nil
+		},
+	}
+}
+
+// waitRolledOut wait until generation of someApp reconciled by operator,
+// and its deployment observed the new spec with all replicas updated and available
+func (o *options) waitRolledOut(ctx context.Context, someApp *opsv1.Someapp, timeout time.Duration) error {
+	generation := someApp.GetGeneration()
+	reason := "not checked"
+	err := wait.PollUntilContextTimeout(ctx, pollInterval, timeout, true, func(ctx context.Context) (bool, error) {
+		current, err := o.getSomeapp(ctx, someApp.Name)
+		if err != nil {
+			return false, err
+		}
+		if current.Status.ObservedGeneration < generation {
+			reason = fmt.Sprintf("generation %d not reconciled by operator", generation)
+			return false, nil
+		}
+
+		deploy := &apps_v1.Deployment{}
+		if err := o.client.Get(ctx, client.ObjectKey{Namespace: current.Namespace, Name: deployment.Name(current)}, deploy); err != nil {
+			if apierrors.IsNotFound(err) {
+				reason = "deployment not found"
+				return false, nil
+			}
+			return false, err
+		}
+		available, _, message := deployment.Available(deploy)
+		reason = message
+		return available, nil
+	})
+	if err != nil {
+		return fmt.Errorf("someapp/%s not rolled out in %s, %s: %w", someApp.Name, timeout, reason, err)
+	}
+	return nil
+}
+
+func abortCommand() *command {
+	var deleteCanary bool
+	return &command{
+		flags: func(fs *pflag.FlagSet) {
+			fs.BoolVar(&deleteCanary, "delete", false, "delete canary someapp after traffic moved back")
+		},
+		run: func(ctx context.Context, o *options, name string, _ []string) error {
+			canary, err := o.getCanary(ctx, name)
+			if err != nil {
+				return err
+			}
+			if err := o.setWeight(ctx, canary, 0); err != nil {
+				return err
+			}
+			fmt.Printf("someapp/%s aborted, all traffic to stable\n", canary.Name)
+
+			if !deleteCanary {
+				return nil
+			}
+			if err := o.client.Delete(ctx, canary); err != nil {
+				return err
+			}
+			fmt.Printf("someapp/%s deleted\n", canary.Name)
+			return nil
+		},
+	}
+}
+
+func rollbackCommand() *command {
+	var toRevision int64
+	return &command{
+		flags: func(fs *pflag.FlagSet) {
+			fs.Int64Var(&toRevision, "to-revision", 0, "deployment revision to rollback to, 0 means the previous one")
+		},
+		run: func(ctx context.Context, o *options, name string, _ []string) error {
+			someApp, err := o.getSomeapp(ctx, name)
+			if err != nil {
+				return err
+			}
+			rs, err := o.revision(ctx, someApp, toRevision)
+			if err != nil {
+				return err
+			}
+
+			// only images rolled back, mounts of spec.volumes and config are added by operator
+			images := map[string]string{}
+			for _, c := range rs.Spec.Template.Spec.Containers {
+				images[c.Name] = c.Image
+			}
+			changed := false
+			if err := o.patchSomeapp(ctx, someApp, func(someApp *opsv1.Someapp) error {
+				for i, c := range someApp.Spec.Containers {
+					if image, ok := images[c.Name]; ok && image != c.Image {
+						someApp.Spec.Containers[i].Image = image
+						changed = true
+					}
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+
+			if !changed {
+				fmt.Printf("someapp/%s images already same as revision %s\n", someApp.Name, rs.Annotations[revisionAnnotation])
+				return nil
+			}
+			fmt.Printf("someapp/%s rolled back to images of revision %s\n", someApp.Name, rs.Annotations[revisionAnnotation])
+			return nil
+		},
+	}
+}
+
+func pauseCommand(pause bool) *command {
+	return &command{
+		run: func(ctx context.Context, o *options, name string, _ []string) error {
+			someApp, err := o.getSomeapp(ctx, name)
+			if err != nil {
+				return err
+			}
+			if !pause && someApp.Spec.Paused {
+				return fmt.Errorf("someapp/%s paused by spec.paused, set it false instead", someApp.Name)
+			}
+
+			if err := o.patchSomeapp(ctx, someApp, func(someApp *opsv1.Someapp) error {
+				if pause {
+					meta_v1.SetMetaDataAnnotation(&someApp.ObjectMeta, opsv1.PausedAnnotation, "true")
+				} else {
+					delete(someApp.Annotations, opsv1.PausedAnnotation)
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+
+			if pause {
+				fmt.Printf("someapp/%s paused\n", someApp.Name)
+			} else {
+				fmt.Printf("someapp/%s resumed\n", someApp.Name)
+			}
+			return nil
+		},
+	}
+}
+
+func setWeightCommand() *command {
+	return &command{
+		args: 1,
+		run: func(ctx context.Context, o *options, name string, args []string) error {
+			weight, err := strconv.ParseInt(args[0], 10, 32)
+			if err != nil || weight < 0 || weight > 100 {
+				return fmt.Errorf("weight must be 0-100, got %s", args[0])
+			}
+			canary, err := o.getCanary(ctx, name)
+			if err != nil {
+				return err
+			}
+			if err := o.setWeight(ctx, canary, int32(weight)); err != nil {
+				return err
+			}
+			fmt.Printf("someapp/%s weight %d\n", canary.Name, weight)
+			return nil
+		},
+	}
+}
+
+func restartCommand() *command {
+	return &command{
+		run: func(ctx context.Context, o *options, name string, _ []string) error {
+			someApp, err := o.getSomeapp(ctx, name)
+			if err != nil {
+				return err
+			}
+			if err := o.patchSomeapp(ctx, someApp, func(someApp *opsv1.Someapp) error {
+				meta_v1.SetMetaDataAnnotation(&someApp.ObjectMeta, opsv1.RestartedAtAnnotation, time.Now().Format(time.RFC3339))
+				return nil
+			}); err != nil {
+				return err
+			}
+			fmt.Printf("someapp/%s restarted\n", someApp.Name)
+			return nil
+		},
+	}
+}
+
+// getCanary get canary someapp with istio enabled
+func (o *options) getCanary(ctx context.Context, name string) (*opsv1.Someapp, error) {
+	someApp, err := o.getSomeapp(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if someApp.Spec.AppVersion == opsv1.StableStage {
+		return nil, fmt.Errorf("someapp/%s is stable, not canary", name)
+	}
+	if !someApp.Spec.EnableIstio || someApp.Spec.AppType != opsv1.AppTypeApi {
+		return nil, fmt.Errorf("someapp/%s not enable istio, no traffic to move", name)
+	}
+	return someApp, nil
+}
+
+// getStable get stable someapp of app
+func (o *options) getStable(ctx context.Context, appName string) (*opsv1.Someapp, error) {
+	someAppList := &opsv1.SomeappList{}
+	if err := o.client.List(ctx, someAppList, client.InNamespace(o.namespace)); err != nil {
+		return nil, err
+	}
+	for i := range someAppList.Items {
+		someApp := &someAppList.Items[i]
+		if someApp.Spec.AppName == appName && someApp.Spec.AppVersion == opsv1.StableStage {
+			return someApp, nil
+		}
+	}
+	return nil, fmt.Errorf("stable someapp of app %s not found", appName)
+}
+
+// setWeight set spec.istio.weight of canary someapp
+func (o *options) setWeight(ctx context.Context, canary *opsv1.Someapp, weight int32) error {
+	return o.patchSomeapp(ctx, canary, func(canary *opsv1.Someapp) error {
+		if canary.Spec.Istio == nil {
+			canary.Spec.Istio = &opsv1.SomeIstioConfig{}
+		}
+		canary.Spec.Istio.Weight = &weight
+		return nil
+	})
+}
+
+// revision get replicaset of deployment revision, 0 means the one before current
+func (o *options) revision(ctx context.Context, someApp *opsv1.Someapp, revision int64) (*apps_v1.ReplicaSet, error) {
+	deploy := &apps_v1.Deployment{}
+	if err := o.client.Get(ctx, client.ObjectKey{Namespace: someApp.Namespace, Name: deployment.Name(someApp)}, deploy); err != nil {
+		return nil, err
+	}
+	current, _ := strconv.ParseInt(deploy.Annotations[revisionAnnotation], 10, 64)
+
+	rsList := &apps_v1.ReplicaSetList{}
+	if err := o.client.List(ctx, rsList, client.InNamespace(someApp.Namespace)); err != nil {
+		return nil, err
+	}
+
+	var found *apps_v1.ReplicaSet
+	var foundRevision int64
+	for i := range rsList.Items {
+		rs := &rsList.Items[i]
+		if ref := meta_v1.GetControllerOf(rs); ref == nil || ref.UID != deploy.UID {
+			continue
+		}
+		rsRevision, err := strconv.ParseInt(rs.Annotations[revisionAnnotation], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch {
+		case revision > 0 && rsRevision == revision:
+			return rs, nil
+		// previous one, the latest before current
+		case revision == 0 && rsRevision < current && rsRevision > foundRevision:
+			found, foundRevision = rs, rsRevision
+		}
+	}
+
+	if found == nil {
+		if revision > 0 {
+			return nil, fmt.Errorf("revision %d of deployment %s not found", revision, deploy.Name)
+		}
+		return nil, fmt.Errorf("no revision before %d of deployment %s", current, deploy.Name)
+	}
+	return found, nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	istio_network_v1beta1 "istio.io/client-go/pkg/apis/networking/v1beta1"
+	apps_v1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	core_v1 "k8s.io/api/core/v1"
+	networking_v1 "k8s.io/api/networking/v1"
+	policy_v1 "k8s.io/api/policy/v1"
+	rbac_v1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+
+	opsv1 "github.com/changqings/some-app-operator/api/v1"
+	"github.com/changqings/some-app-operator/pkg/owner"
+)
+
+// child lists of someapp shown by status, in order
+func childLists() []client.ObjectList {
+	return []client.ObjectList{
+		&apps_v1.DeploymentList{},
+		&core_v1.ServiceList{},
+		&autoscalingv2.HorizontalPodAutoscalerList{},
+		&policy_v1.PodDisruptionBudgetList{},
+		&networking_v1.NetworkPolicyList{},
+		&networking_v1.IngressList{},
+		&core_v1.ServiceAccountList{},
+		&rbac_v1.RoleList{},
+		&rbac_v1.RoleBindingList{},
+		&core_v1.ConfigMapList{},
+		&istio_network_v1beta1.VirtualServiceList{},
+		&istio_network_v1beta1.DestinationRuleList{},
+	}
+}
+
+func statusCommand() *command {
+	return &command{
+		run: func(ctx context.Context, o *options, name string, _ []string) error {
+			someApp, err := o.getSomeapp(ctx, name)
+			if err != nil {
+				return err
+			}
+			return printStatus(ctx, os.Stdout, o.client, someApp)
+		},
+	}
+}
+
+// printStatus print someapp, tree of children, traffic of istio route and conditions
+func printStatus(ctx context.Context, out io.Writer, c client.Client, someApp *opsv1.Someapp) error {
+	fmt.Fprintf(out, "Someapp %s/%s  app=%s version=%s phase=%s generation=%d/%d\n",
+		someApp.Namespace, someApp.Name, someApp.Spec.AppName, someApp.Spec.AppVersion,
+		someApp.Status.Status.Phase, someApp.Status.ObservedGeneration, someApp.Generation)
+	if someApp.IsPaused() {
+		fmt.Fprintln(out, "  paused, child resources not reconciled")
+	}
+
+	var children []string
+	for _, list := range childLists() {
+		if err := c.List(ctx, list, client.InNamespace(someApp.Namespace)); err != nil {
+			// istio not installed
+			if meta.IsNoMatchError(err) || apierrors.IsNotFound(err) {
+				continue
+			}
+			return err
+		}
+		items, err := meta.ExtractList(list)
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			child, ok := item.(client.Object)
+			if !ok || !owner.IsOwnedBy(child, someApp) {
+				continue
+			}
+			gvk, err := apiutil.GVKForObject(child, c.Scheme())
+			if err != nil {
+				return err
+			}
+			line := gvk.Kind + "/" + child.GetName()
+			if detail := childDetail(child); len(detail) > 0 {
+				line += "  " + detail
+			}
+			children = append(children, line)
+		}
+	}
+	for i, line := range children {
+		prefix := "├── "
+		if i == len(children)-1 {
+			prefix = "└── "
+		}
+		fmt.Fprintln(out, prefix+line)
+	}
+
+	if someApp.Spec.EnableIstio && someApp.Spec.AppType == opsv1.AppTypeApi {
+		if err := printTraffic(ctx, out, c, someApp); err != nil {
+			return err
+		}
+	}
+
+	if len(someApp.Status.Conditions) > 0 {
+		fmt.Fprintln(out, "Conditions:")
+		w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "  TYPE\tSTATUS\tREASON\tMESSAGE")
+		for _, cond := range someApp.Status.Conditions {
+			fmt.Fprintf(w, "  %s\t%s\t%s\t%s\n", cond.Type, cond.Status, cond.Reason, cond.Message)
+		}
+		w.Flush()
+	}
+	if len(someApp.Status.Plan) > 0 {
+		fmt.Fprintln(out, "Plan:")
+		for _, change := range someApp.Status.Plan {
+			fmt.Fprintln(out, "  "+change)
+		}
+	}
+	return nil
+}
+
+// childDetail short status of child
+func childDetail(obj client.Object) string {
+	switch o := obj.(type) {
+	case *apps_v1.Deployment:
+		replicas := int32(1)
+		if o.Spec.Replicas != nil {
+			replicas = *o.Spec.Replicas
+		}
+		return fmt.Sprintf("ready %d/%d, updated %d, revision %s",
+			o.Status.ReadyReplicas, replicas, o.Status.UpdatedReplicas, o.Annotations[revisionAnnotation])
+	case *core_v1.Service:
+		return fmt.Sprintf("%s %s", o.Spec.Type, o.Spec.ClusterIP)
+	case *autoscalingv2.HorizontalPodAutoscaler:
+		minReplicas := int32(1)
+		if o.Spec.MinReplicas != nil {
+			minReplicas = *o.Spec.MinReplicas
+		}
+		return fmt.Sprintf("%d->%d, current %d", minReplicas, o.Spec.MaxReplicas, o.Status.CurrentReplicas)
+	case *istio_network_v1beta1.DestinationRule:
+		subsets := make([]string, 0, len(o.Spec.Subsets))
+		for _, subset := range o.Spec.Subsets {
+			subsets = append(subsets, subset.Name)
+		}
+		return "subsets: " + strings.Join(subsets, ", ")
+	}
+	return ""
+}
+
+// printTraffic print weights of http routes in vs of app, stable vs is not owned by canary
+func printTraffic(ctx context.Context, out io.Writer, c client.Client, someApp *opsv1.Someapp) error {
+	vs := &istio_network_v1beta1.VirtualService{}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: someApp.Namespace, Name: someApp.Spec.AppName}, vs); err != nil {
+		if apierrors.IsNotFound(err) || meta.IsNoMatchError(err) {
+			fmt.Fprintln(out, "Traffic: vs not found")
+			return nil
+		}
+		return err
+	}
+
+	fmt.Fprintf(out, "Traffic of VirtualService/%s:\n", vs.Name)
+	for _, route := range vs.Spec.Http {
+		weights := make([]string, 0, len(route.Route))
+		for _, dest := range route.Route {
+			if dest.Destination == nil {
+				continue
+			}
+			weights = append(weights, fmt.Sprintf("%s=%d", dest.Destination.Subset, dest.Weight))
+		}
+		fmt.Fprintf(out, "  %s  %s\n", route.Name, strings.Join(weights, " "))
+	}
+	return nil
+}
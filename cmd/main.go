@@ -17,6 +17,7 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
 	"os"
 
@@ -25,17 +26,25 @@ import (
 	"go.uber.org/zap/zapcore"
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	istio_network_v1 "istio.io/client-go/pkg/apis/networking/v1"
 	istio_network_v1beta1 "istio.io/client-go/pkg/apis/networking/v1beta1"
+	istio_security_v1beta1 "istio.io/client-go/pkg/apis/security/v1beta1"
+	core_v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 
 	opsv1 "github.com/changqings/some-app-operator/api/v1"
 	"github.com/changqings/some-app-operator/internal/controller"
+	"github.com/changqings/some-app-operator/pkg/apply"
+	"github.com/changqings/some-app-operator/pkg/istio"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -49,6 +58,8 @@ var (
 func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 	utilruntime.Must(istio_network_v1beta1.AddToScheme(scheme))
+	utilruntime.Must(istio_network_v1.AddToScheme(scheme))
+	utilruntime.Must(istio_security_v1beta1.AddToScheme(scheme))
 
 	utilruntime.Must(opsv1.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
@@ -58,11 +69,17 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var dryRun bool
+	var istioTrustDomain string
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.BoolVar(&dryRun, "dry-run", false,
+		"Dry-run all someapps, changes of child resources are only planned in status and events, nothing changed.")
+	flag.StringVar(&istioTrustDomain, "istio-trust-domain", istio.DefaultTrustDomain,
+		"Trust domain of istio mesh, meshConfig.trustDomain, for principals of spec.istio.security.apps.")
 	opts := zap.Options{
 		Development: true,
 		TimeEncoder: zapcore.TimeEncoderOfLayout(logTimeLayout),
@@ -72,7 +89,12 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
+	// field manager of writes not server-side apply, like CreateOrUpdate, instead of binary name,
+	// so they are upgraded to apply field manager, not fields of other controllers named manager
+	cfg := ctrl.GetConfigOrDie()
+	cfg.UserAgent = apply.FieldManager
+
+	mgr, err := ctrl.NewManager(cfg, ctrl.Options{
 		// Cache not include kube-system or other namespace
 		// Cache: cache.Options{
 		// 	DefaultFieldSelector: fields.ParseSelectorOrDie("metadata.namespace!=kube-system,metadata.namespace!=kube-node-lease"),
@@ -81,7 +103,14 @@ func main() {
 		// 		"some-ns": {},
 		// 	},
 		// },
-		Scheme:                 scheme,
+		Scheme: scheme,
+		// all configmaps and secrets of cluster are not cached, only referenced ones read,
+		// they are watched by metadata only
+		Client: client.Options{
+			Cache: &client.CacheOptions{
+				DisableFor: []client.Object{&core_v1.ConfigMap{}, &core_v1.Secret{}},
+			},
+		},
 		Metrics:                metricsserver.Options{BindAddress: metricsAddr},
 		HealthProbeBindAddress: probeAddr,
 		LeaderElection:         enableLeaderElection,
@@ -103,16 +132,43 @@ func main() {
 		os.Exit(1)
 	}
 
+	// use networking.istio.io/v1 when control plane serve it, or v1beta1 for old istio,
+	// objects not migrated in dry-run
+	istioAPIVersion := istio.DetectAPIVersion(mgr.GetRESTMapper())
+	setupLog.Info("istio networking api detected", "version", istioAPIVersion)
+	if istioAPIVersion == istio.APIVersionV1 && !dryRun {
+		// run after cache started, and only on leader
+		if err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+			// migrate failed should not stop the manager, objects still work with v1beta1
+			if err := istio.MigrateOwned(ctx, mgr.GetClient(), ctrl.Log.WithName("istio-migrate")); err != nil {
+				setupLog.Error(err, "unable to migrate istio objects to v1")
+			}
+			return nil
+		})); err != nil {
+			setupLog.Error(err, "unable to add istio migrate runnable")
+			os.Exit(1)
+		}
+	}
+
 	if err = (&controller.SomeappReconciler{
-		Client:        mgr.GetClient(),
-		Scheme:        mgr.GetScheme(),
-		EventRecorder: mgr.GetEventRecorderFor("Someapp-controller"),
+		Client:           mgr.GetClient(),
+		Scheme:           mgr.GetScheme(),
+		EventRecorder:    mgr.GetEventRecorderFor("Someapp-controller"),
+		IstioAPIVersion:  istioAPIVersion,
+		IstioTrustDomain: istioTrustDomain,
+		DryRun:           dryRun,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Someapp")
 		os.Exit(1)
 	}
 	//+kubebuilder:scaffold:builder
 
+	// someapps and canary weights are read from cache when scraped
+	if err := crmetrics.Registry.Register(controller.NewSomeappCollector(mgr.GetClient(), istioAPIVersion)); err != nil {
+		setupLog.Error(err, "unable to register someapp metrics")
+		os.Exit(1)
+	}
+
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up health check")
 		os.Exit(1)
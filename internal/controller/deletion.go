@@ -0,0 +1,99 @@
+package controller
+
+import (
+	"context"
+
+	istio_security_v1beta1 "istio.io/client-go/pkg/apis/security/v1beta1"
+	apps_v1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	core_v1 "k8s.io/api/core/v1"
+	networking_v1 "k8s.io/api/networking/v1"
+	policy_v1 "k8s.io/api/policy/v1"
+	rbac_v1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+
+	opsv1 "github.com/changqings/some-app-operator/api/v1"
+	"github.com/changqings/some-app-operator/pkg/istio"
+	"github.com/changqings/some-app-operator/pkg/owner"
+)
+
+// deletionPolicyFinalizerName keep someapp until children retained by spec.deletionPolicy orphaned
+const deletionPolicyFinalizerName = "ops.some.cn/deletion-policy"
+
+// childTypes of all kinds owned by someapps
+func (r *SomeappReconciler) childTypes() []client.Object {
+	return append([]client.Object{
+		&apps_v1.Deployment{},
+		&core_v1.Service{},
+		&autoscalingv2.HorizontalPodAutoscaler{},
+		&policy_v1.PodDisruptionBudget{},
+		&networking_v1.NetworkPolicy{},
+		&networking_v1.Ingress{},
+		&core_v1.ServiceAccount{},
+		&rbac_v1.Role{},
+		&rbac_v1.RoleBinding{},
+		&core_v1.ConfigMap{},
+		&istio_security_v1beta1.PeerAuthentication{},
+		&istio_security_v1beta1.AuthorizationPolicy{},
+	}, istio.OwnedTypes(r.IstioAPIVersion)...)
+}
+
+// orphanChildren remove ownerReference to someApp from children of kinds retained by spec.deletionPolicy,
+// so garbage collector keeps them after someapp deleted, return Kind/Name orphaned.
+// Foreground deletion deletes children before finalizers, orphan only works with background deletion.
+// Children with label app removed by hand are not found, and deleted with someapp.
+func (r *SomeappReconciler) orphanChildren(ctx context.Context, someApp *opsv1.Someapp) ([]string, error) {
+	var orphaned []string
+	for _, obj := range r.childTypes() {
+		gvk, err := apiutil.GVKForObject(obj, r.Scheme)
+		if err != nil {
+			return orphaned, err
+		}
+		if !someApp.Spec.DeletionPolicy.Retains(gvk.Kind) {
+			continue
+		}
+
+		newList, err := r.Scheme.New(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+		if err != nil {
+			return orphaned, err
+		}
+		list := newList.(client.ObjectList)
+		// children are labeled app, like pods selected by them, so not all of the namespace listed,
+		// configmaps not cached are filtered by api server
+		if err := r.List(ctx, list, client.InNamespace(someApp.Namespace), client.MatchingLabels{"app": someApp.Spec.AppName}); err != nil {
+			// istio not installed
+			if meta.IsNoMatchError(err) {
+				continue
+			}
+			return orphaned, err
+		}
+		items, err := meta.ExtractList(list)
+		if err != nil {
+			return orphaned, err
+		}
+
+		for _, item := range items {
+			child, ok := item.(client.Object)
+			if !ok || !owner.IsOwnedBy(child, someApp) {
+				continue
+			}
+			// shared ones like canary dr are changed by others, re-read on conflict
+			err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+				if err := r.Get(ctx, client.ObjectKeyFromObject(child), child); err != nil {
+					return err
+				}
+				patch := client.MergeFromWithOptions(child.DeepCopyObject().(client.Object), client.MergeFromWithOptimisticLock{})
+				owner.RemoveOwnerReference(child, someApp)
+				return r.Patch(ctx, child, patch)
+			})
+			if client.IgnoreNotFound(err) != nil {
+				return orphaned, err
+			}
+			orphaned = append(orphaned, gvk.Kind+"/"+child.GetName())
+		}
+	}
+	return orphaned, nil
+}
@@ -0,0 +1,122 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/go-logr/logr"
+	core_v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	opsv1 "github.com/changqings/some-app-operator/api/v1"
+	"github.com/changqings/some-app-operator/pkg/istio"
+	istio_network_v1beta1 "istio.io/client-go/pkg/apis/networking/v1beta1"
+)
+
+var _ = Describe("Istio canaries", func() {
+	const (
+		appName    = "concurrent"
+		namespace  = "default"
+		canaryNums = 10
+	)
+
+	newSomeApp := func(name, version string) *opsv1.Someapp {
+		return &opsv1.Someapp{
+			ObjectMeta: meta_v1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec: opsv1.SomeappSpec{
+				AppName:     appName,
+				AppType:     opsv1.AppTypeApi,
+				AppVersion:  version,
+				EnableIstio: true,
+				Containers:  []core_v1.Container{{Name: "app", Image: "nginx:alpine"}},
+			},
+		}
+	}
+
+	// reconcile all in parallel, return first error
+	reconcileParallel := func(fns []func() error) error {
+		var (
+			wg   sync.WaitGroup
+			errs = make(chan error, len(fns))
+		)
+		for _, fn := range fns {
+			wg.Add(1)
+			go func(fn func() error) {
+				defer GinkgoRecover()
+				defer wg.Done()
+				errs <- fn()
+			}(fn)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	It("should not clobber each other when many canaries reconcile in parallel", func() {
+		ctx := context.Background()
+
+		stable := newSomeApp(appName+"-stable", opsv1.StableStage)
+		Expect(k8sClient.Create(ctx, stable)).To(Succeed())
+		stableIstio := &istio.SomeIstio{Stage: opsv1.StableStage, APIVersion: istio.APIVersionV1beta1}
+		Expect(stableIstio.Reconcile(ctx, stable, k8sClient, scheme.Scheme, logr.Discard())).To(Succeed())
+
+		var canaries []*opsv1.Someapp
+		for i := 0; i < canaryNums; i++ {
+			canary := newSomeApp(fmt.Sprintf("%s-canary-v%d", appName, i), fmt.Sprintf("canary-v0.0.%d", i))
+			Expect(k8sClient.Create(ctx, canary)).To(Succeed())
+			canaries = append(canaries, canary)
+		}
+
+		By("adding canaries, with stable reconciled at the same time")
+		fns := []func() error{func() error {
+			si := &istio.SomeIstio{Stage: opsv1.StableStage, APIVersion: istio.APIVersionV1beta1}
+			return si.Reconcile(ctx, stable, k8sClient, scheme.Scheme, logr.Discard())
+		}}
+		for _, canary := range canaries {
+			canary := canary
+			fns = append(fns, func() error {
+				si := &istio.SomeIstio{Stage: opsv1.CanaryStage, APIVersion: istio.APIVersionV1beta1}
+				return si.Reconcile(ctx, canary, k8sClient, scheme.Scheme, logr.Discard())
+			})
+		}
+		Expect(reconcileParallel(fns)).To(Succeed())
+
+		vs := &istio_network_v1beta1.VirtualService{}
+		Expect(k8sClient.Get(ctx, client.ObjectKey{Name: appName, Namespace: namespace}, vs)).To(Succeed())
+		Expect(vs.Spec.Http).To(HaveLen(canaryNums + 1))
+		Expect(vs.Spec.Http[canaryNums].Name).To(Equal(appName + "-stable"))
+
+		dr := &istio_network_v1beta1.DestinationRule{}
+		Expect(k8sClient.Get(ctx, client.ObjectKey{Name: appName + "-canary", Namespace: namespace}, dr)).To(Succeed())
+		Expect(dr.Spec.Subsets).To(HaveLen(canaryNums))
+
+		By("deleting canaries")
+		fns = nil
+		for _, canary := range canaries {
+			canary := canary
+			fns = append(fns, func() error {
+				si := &istio.SomeIstio{Stage: opsv1.CanaryStage, DeleteAction: true, APIVersion: istio.APIVersionV1beta1}
+				return si.Reconcile(ctx, canary, k8sClient, scheme.Scheme, logr.Discard())
+			})
+		}
+		Expect(reconcileParallel(fns)).To(Succeed())
+
+		Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(vs), vs)).To(Succeed())
+		Expect(vs.Spec.Http).To(HaveLen(1))
+		// last canary delete canary dr
+		err := k8sClient.Get(ctx, client.ObjectKeyFromObject(dr), dr)
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+	})
+})
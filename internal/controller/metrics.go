@@ -0,0 +1,92 @@
+package controller
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	opsv1 "github.com/changqings/some-app-operator/api/v1"
+	"github.com/changqings/some-app-operator/pkg/children"
+	"github.com/changqings/some-app-operator/pkg/deployment"
+	"github.com/changqings/some-app-operator/pkg/istio"
+	"github.com/changqings/some-app-operator/pkg/metrics"
+)
+
+// reconcileChild run sub reconciler of name, duration observed
+func (r *SomeappReconciler) reconcileChild(ctx context.Context, name string, sub children.Reconciler, someApp *opsv1.Someapp, c client.Client, log logr.Logger) error {
+	start := time.Now()
+	defer func() {
+		metrics.ReconcileDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+	}()
+	return sub.Reconcile(ctx, someApp, c, r.Scheme, log)
+}
+
+var (
+	someappsDesc = prometheus.NewDesc("someapp_someapps",
+		"Number of someapps, by phase, type and stage",
+		[]string{"phase", "type", "stage"}, nil)
+	canaryWeightDesc = prometheus.NewDesc("someapp_canary_weight",
+		"Percent of traffic to active canary someapps, on canary route of stable vs",
+		[]string{"namespace", "someapp", "app"}, nil)
+)
+
+// someappCollector count someapps and weights of canaries from cache when scraped,
+// so someapps deleted are gone without cleanup
+type someappCollector struct {
+	client          client.Reader
+	istioAPIVersion string
+}
+
+// NewSomeappCollector return collector of someapps read from c, cache of manager,
+// registered once by main, as SetupWithManager may run more than once, like managers of tests
+func NewSomeappCollector(c client.Reader, istioAPIVersion string) prometheus.Collector {
+	return &someappCollector{client: c, istioAPIVersion: istioAPIVersion}
+}
+
+func (sc *someappCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- someappsDesc
+	ch <- canaryWeightDesc
+}
+
+func (sc *someappCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	log := ctrl.Log.WithName("metrics")
+
+	someAppList := &opsv1.SomeappList{}
+	if err := sc.client.List(ctx, someAppList); err != nil {
+		log.Error(err, "list someapps")
+		return
+	}
+
+	type key struct{ phase, appType, stage string }
+	counts := map[key]int{}
+	for i := range someAppList.Items {
+		someApp := &someAppList.Items[i]
+		stage := deployment.Stage(someApp)
+		counts[key{someApp.Status.Status.Phase, someApp.Spec.AppType, stage}]++
+
+		// active canaries are routed by istio, not deleted
+		if stage != opsv1.CanaryStage || !someApp.Spec.EnableIstio || someApp.Spec.AppType != opsv1.AppTypeApi ||
+			!someApp.DeletionTimestamp.IsZero() {
+			continue
+		}
+		weight, found, err := istio.CanaryWeight(ctx, sc.client, sc.istioAPIVersion, someApp)
+		if err != nil {
+			log.Error(err, "get canary weight", "someapp", client.ObjectKeyFromObject(someApp))
+			continue
+		}
+		if found {
+			ch <- prometheus.MustNewConstMetric(canaryWeightDesc, prometheus.GaugeValue, float64(weight),
+				someApp.Namespace, someApp.Name, someApp.Spec.AppName)
+		}
+	}
+
+	for k, count := range counts {
+		ch <- prometheus.MustNewConstMetric(someappsDesc, prometheus.GaugeValue, float64(count), k.phase, k.appType, k.stage)
+	}
+}
@@ -0,0 +1,49 @@
+package controller
+
+import (
+	"reflect"
+
+	apps_v1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// specChanged pass update events when spec changed, status only updates are ignored,
+// for services and hpas, their generation not changed with spec
+var specChanged = predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		if e.ObjectOld == nil || e.ObjectNew == nil {
+			return false
+		}
+		oldSpec := reflect.Indirect(reflect.ValueOf(e.ObjectOld)).FieldByName("Spec")
+		newSpec := reflect.Indirect(reflect.ValueOf(e.ObjectNew)).FieldByName("Spec")
+		if !oldSpec.IsValid() || !newSpec.IsValid() {
+			return true
+		}
+		return !equality.Semantic.DeepEqual(oldSpec.Interface(), newSpec.Interface())
+	},
+}
+
+// deploymentStatusChanged pass update events when deployment status changed, for someapp health
+var deploymentStatusChanged = predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		oldDeployment, ok := e.ObjectOld.(*apps_v1.Deployment)
+		if !ok {
+			return false
+		}
+		newDeployment, ok := e.ObjectNew.(*apps_v1.Deployment)
+		if !ok {
+			return false
+		}
+		return !equality.Semantic.DeepEqual(oldDeployment.Status, newDeployment.Status)
+	},
+}
+
+// owned resources, deleted or spec and labels changed out of band will be reconciled at once
+var (
+	ownedGenerationChanged = builder.WithPredicates(predicate.Or(predicate.GenerationChangedPredicate{}, predicate.LabelChangedPredicate{}))
+	ownedSpecChanged       = builder.WithPredicates(predicate.Or(specChanged, predicate.LabelChangedPredicate{}))
+	ownedDeploymentChanged = builder.WithPredicates(predicate.Or(predicate.GenerationChangedPredicate{}, predicate.LabelChangedPredicate{}, deploymentStatusChanged))
+)
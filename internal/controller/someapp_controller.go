@@ -18,30 +18,43 @@ package controller
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"strings"
 	"time"
 
 	"golang.org/x/time/rate"
-	istio_network_v1beta1 "istio.io/client-go/pkg/apis/networking/v1beta1"
+	istio_security_v1beta1 "istio.io/client-go/pkg/apis/security/v1beta1"
 	apps_v1 "k8s.io/api/apps/v1"
 	autoscalingv2 "k8s.io/api/autoscaling/v2"
 	core_v1 "k8s.io/api/core/v1"
+	networking_v1 "k8s.io/api/networking/v1"
+	policy_v1 "k8s.io/api/policy/v1"
+	rbac_v1 "k8s.io/api/rbac/v1"
 	k8s_errors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	opsv1 "github.com/changqings/some-app-operator/api/v1"
+	"github.com/changqings/some-app-operator/pkg/apply"
+	"github.com/changqings/some-app-operator/pkg/children"
 	"github.com/changqings/some-app-operator/pkg/deployment"
-	"github.com/changqings/some-app-operator/pkg/hpa"
+	"github.com/changqings/some-app-operator/pkg/dryrun"
 	"github.com/changqings/some-app-operator/pkg/istio"
-	"github.com/changqings/some-app-operator/pkg/service"
+	"github.com/changqings/some-app-operator/pkg/metrics"
+	"github.com/changqings/some-app-operator/pkg/owner"
 )
 
 const (
@@ -51,6 +64,12 @@ const (
 	STATUS_CREATE      = "Creating"
 	STATUS_ERROR       = "Error"
 	requeueAfter       = time.Second * 5
+
+	// field index of someapp
+	configMapRefIndex = ".spec.configMapRefs"
+	secretRefIndex    = ".spec.secretRefs"
+	appNameIndex      = ".spec.name"
+	callersIndex      = ".spec.istio.security.apps"
 )
 
 // SomeappReconciler reconciles a Someapp object
@@ -60,6 +79,15 @@ type SomeappReconciler struct {
 
 	//
 	EventRecorder record.EventRecorder
+
+	// networking.istio.io version of vs and dr, v1 or v1beta1, detected at startup
+	IstioAPIVersion string
+
+	// trust domain of mesh, for principals of spec.istio.security.apps
+	IstioTrustDomain string
+
+	// dry-run all someapps, only plan changes of child resources
+	DryRun bool
 }
 
 //+kubebuilder:rbac:groups=ops.some.cn,resources=someapps,verbs=get;list;watch;create;update;patch;delete
@@ -67,10 +95,22 @@ type SomeappReconciler struct {
 //+kubebuilder:rbac:groups=ops.some.cn,resources=someapps/finalizers,verbs=update
 //+kubebuilder:rbac:groups=apps,resources=deployments,verbs=*
 //+kubebuilder:rbac:groups=core,resources=services,verbs=*
+//+kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch
 //+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
 //+kubebuilder:rbac:groups=autoscaling,resources=horizontalpodautoscalers,verbs=*
+//+kubebuilder:rbac:groups=policy,resources=poddisruptionbudgets,verbs=*
+//+kubebuilder:rbac:groups=networking.k8s.io,resources=networkpolicies,verbs=*
+//+kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses,verbs=*
+//+kubebuilder:rbac:groups=core,resources=serviceaccounts,verbs=*
+//+kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=roles;rolebindings,verbs=get;list;watch;create;update;patch;delete
+// rules granted by spec.serviceAccount.rules, operator must hold them to create roles
+//+kubebuilder:rbac:groups=core,resources=pods;endpoints,verbs=get;list;watch
+//+kubebuilder:rbac:groups=discovery.k8s.io,resources=endpointslices,verbs=get;list;watch
+//+kubebuilder:rbac:groups=coordination.k8s.io,resources=leases,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=networking.istio.io,resources=virtualservices,verbs=*
 //+kubebuilder:rbac:groups=networking.istio.io,resources=destinationrules,verbs=*
+//+kubebuilder:rbac:groups=security.istio.io,resources=peerauthentications;authorizationpolicies,verbs=*
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -99,84 +139,199 @@ func (r *SomeappReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 	}
 
 	// initial var
-	nameValue := someApp.Spec.AppName
-	stage := opsv1.StableStage
-
-	if someApp.Spec.AppVersion != "stable" {
-		stage = opsv1.CanaryStage
-		nameValue = someApp.Spec.AppName + "-" + strings.ReplaceAll(someApp.Spec.AppVersion, ".", "-")
-	}
-
-	if someApp.Spec.AppType == opsv1.AppTypeScript {
-		nameValue = someApp.Spec.AppName + "-" + someApp.Name
-	}
-
-	standardLabels := map[string]string{
-		"name":    nameValue,
-		"app":     someApp.Spec.AppName,
-		"type":    someApp.Spec.AppType,
-		"version": someApp.Spec.AppVersion,
-		"stage":   stage,
-	}
+	nameValue := deployment.Name(someApp)
+	stage := deployment.Stage(someApp)
 
 	// someApp add finalizer, when stage=canary, and enable istio
 	canaryFinalizerName := "ops.some.cn/finalizer"
+	istioEnabled := someApp.Spec.EnableIstio && someApp.Spec.AppType == opsv1.AppTypeApi
+
+	// dry-run by annotation or operator flag, writes of child resources are server-side dry-run,
+	// planned changes are written to status and events
+	dryRun := r.DryRun || someApp.GetAnnotations()[opsv1.DryRunAnnotation] == "true"
+	planClient := dryrun.New(r.Client)
+	var childClient client.Client = r.Client
+	if dryRun {
+		childClient = planClient
+	}
 
 	// if not deleted (when delete, DeleteionTimestamp is not zero), add finalizer
 	if someApp.DeletionTimestamp.IsZero() {
+		// keep someapp when deleted until children retained by spec.deletionPolicy orphaned,
+		// removed when policy set back to Delete, also when paused or dry-run for safe
+		if retains := someApp.Spec.DeletionPolicy.RetainsAny(); retains != controllerutil.ContainsFinalizer(someApp, deletionPolicyFinalizerName) {
+			if retains {
+				controllerutil.AddFinalizer(someApp, deletionPolicyFinalizerName)
+			} else {
+				controllerutil.RemoveFinalizer(someApp, deletionPolicyFinalizerName)
+			}
+			if err := r.Update(ctx, someApp); err != nil {
+				return resultWithRequeue, err
+			}
+		}
+
+		// paused, child resources not changed, only report status,
+		// deletion still cleans up canary routes with finalizer below
+		if someApp.IsPaused() {
+			if !meta.IsStatusConditionTrue(someApp.Status.Conditions, opsv1.ConditionPaused) {
+				log.Info("someapp paused")
+				eventRecord.Eventf(someApp, core_v1.EventTypeNormal, "Paused", "Someapp %s.%s paused, child resources will not be changed", someApp.Name, someApp.Namespace)
+			}
+			meta.SetStatusCondition(&someApp.Status.Conditions, meta_v1.Condition{
+				Type:               opsv1.ConditionPaused,
+				Status:             meta_v1.ConditionTrue,
+				Reason:             "Paused",
+				Message:            "paused by spec.paused or annotation " + opsv1.PausedAnnotation,
+				ObservedGeneration: someApp.GetGeneration(),
+			})
+			if err := r.setAvailable(ctx, someApp, nameValue); err != nil {
+				return resultWithRequeue, err
+			}
+			return result, r.Status().Update(ctx, someApp)
+		}
+
 		// if stage=canary, and enable istio, and apiType, then add finalizer
-		if stage == opsv1.CanaryStage &&
-			someApp.Spec.EnableIstio &&
-			someApp.Spec.AppType == opsv1.AppTypeApi {
+		if stage == opsv1.CanaryStage && istioEnabled && !dryRun {
 			if !controllerutil.ContainsFinalizer(someApp, canaryFinalizerName) {
 				// try add Finalizer
+				// go on reconcile, finalizer updates are filtered by generation
 				if controllerutil.AddFinalizer(someApp, canaryFinalizerName) {
 					err := r.Update(ctx, someApp)
 					if err != nil {
 						return result, err
 					}
-					return result, nil
 				}
 			}
 
 		}
-	} else {
-		// if get deleted reconcile, handle with resources and delete finalizer
-		if controllerutil.ContainsFinalizer(someApp, canaryFinalizerName) {
-			// delete logical
-			si := istio.SomeIstio{Stage: stage, DeleteAction: true}
-			err = si.Reconcile(ctx, someApp, r.Client, r.Scheme, log)
+
+		// enableIstio turned off, remove canary route and subset like deleted, then remove finalizer,
+		// go on reconcile, finalizer updates are filtered by generation, other children follow the spec change
+		if stage == opsv1.CanaryStage && !istioEnabled &&
+			controllerutil.ContainsFinalizer(someApp, canaryFinalizerName) {
+			si := istio.SomeIstio{Stage: stage, DeleteAction: true, APIVersion: r.IstioAPIVersion}
+			err = r.reconcileChild(ctx, "istio", &si, someApp, childClient, log)
 			if err != nil {
+				if !dryRun {
+					metrics.FinalizerCleanupFailureTotal.WithLabelValues(someApp.Namespace, canaryFinalizerName).Inc()
+				}
 				return resultWithRequeue, err
 			}
 
-			// remove finalizer
-			if controllerutil.RemoveFinalizer(someApp, canaryFinalizerName) {
-				err := r.Update(ctx, someApp)
+			// removal only planned in dry-run, finalizer kept
+			if !dryRun {
+				if controllerutil.RemoveFinalizer(someApp, canaryFinalizerName) {
+					err := r.Update(ctx, someApp)
+					if err != nil {
+						return resultWithRequeue, err
+					}
+				}
+				eventRecord.Eventf(someApp, core_v1.EventTypeNormal, "IstioDisabled", "Someapp %s.%s, canary route and subset removed", someApp.Name, someApp.Namespace)
+			}
+		}
+	} else {
+		// if get deleted reconcile, handle with resources and delete finalizers
+		finalizers := len(someApp.GetFinalizers())
+
+		// remove canary route and subset, unless vs or dr retained by spec.deletionPolicy
+		if controllerutil.ContainsFinalizer(someApp, canaryFinalizerName) {
+			policy := someApp.Spec.DeletionPolicy
+			if !policy.Retains("VirtualService") && !policy.Retains("DestinationRule") {
+				si := istio.SomeIstio{Stage: stage, DeleteAction: true, APIVersion: r.IstioAPIVersion}
+				err = r.reconcileChild(ctx, "istio", &si, someApp, r.Client, log)
 				if err != nil {
+					metrics.FinalizerCleanupFailureTotal.WithLabelValues(someApp.Namespace, canaryFinalizerName).Inc()
 					return resultWithRequeue, err
 				}
 			}
+			controllerutil.RemoveFinalizer(someApp, canaryFinalizerName)
+		}
+
+		// children retained by spec.deletionPolicy are orphaned, others deleted by garbage collector
+		if controllerutil.ContainsFinalizer(someApp, deletionPolicyFinalizerName) {
+			orphaned, err := r.orphanChildren(ctx, someApp)
+			if err != nil {
+				metrics.FinalizerCleanupFailureTotal.WithLabelValues(someApp.Namespace, deletionPolicyFinalizerName).Inc()
+				return resultWithRequeue, err
+			}
+			if len(orphaned) > 0 {
+				log.Info("children orphaned", "orphaned", orphaned)
+				eventRecord.Eventf(someApp, core_v1.EventTypeNormal, "Orphaned", "Someapp %s.%s deleted, kept %s", someApp.Name, someApp.Namespace, strings.Join(orphaned, ", "))
+			}
+			controllerutil.RemoveFinalizer(someApp, deletionPolicyFinalizerName)
+		}
+
+		if len(someApp.GetFinalizers()) != finalizers {
+			if err := r.Update(ctx, someApp); err != nil {
+				return resultWithRequeue, err
+			}
 			return result, nil
 		}
 	}
 
-	// deployment reconcile
-	sd := deployment.SomeDeployment{StandardLabels: standardLabels}
-	err = sd.Reconcile(ctx, someApp, r.Client, r.Scheme, log)
+	// fields changed out of band, found by server-side apply of child resources
+	drifts := &apply.Drifts{}
+	resumed := meta.IsStatusConditionTrue(someApp.Status.Conditions, opsv1.ConditionPaused)
+	// existing resources of the same name not owned by any someapp, adopted only by annotation
+	adoption := &owner.Adoption{Enabled: someApp.GetAnnotations()[opsv1.AdoptAnnotation] == "true"}
+
+	// deprecated someVolume still mounted, warned once for each spec change instead of every reconcile
+	if len(someApp.Spec.SomeVolume) > 0 && someApp.Status.ObservedGeneration != someApp.GetGeneration() {
+		eventRecord.Eventf(someApp, core_v1.EventTypeWarning, "Deprecated", "Someapp %s.%s, spec.someVolume %s is deprecated and mapped to spec.volumes, use spec.volumes instead", someApp.Name, someApp.Namespace, someApp.Spec.SomeVolume)
+	}
+
+	// child reconciles in order, the same ones run by kubectl someapp render
+	ch := children.Children{
+		IstioAPIVersion:  r.IstioAPIVersion,
+		IstioTrustDomain: r.IstioTrustDomain,
+		Drifts:           drifts,
+		Adoption:         adoption,
+	}
+	steps, err := ch.Steps(ctx, r.Client, someApp)
 	if err != nil {
-		someApp.Status.Status.Phase = STATUS_ERROR
-		err := r.Status().Update(ctx, someApp)
-		if err != nil {
-			return resultWithRequeue, err
-		}
-		return result, nil
+		return resultWithRequeue, err
 	}
+	waitingForCallers := false
+	for _, step := range steps {
+		// invalid spec will not be fixed by retry, so not requeue
+		if step.Validate != nil {
+			if err := step.Validate(someApp); err != nil {
+				eventRecord.Eventf(someApp, core_v1.EventTypeWarning, "Invalid", "Invalid someapp %s.%s, %s", someApp.Name, someApp.Namespace, err.Error())
+				someApp.Status.Status.Phase = STATUS_ERROR
+				return result, r.Status().Update(ctx, someApp)
+			}
+		}
 
-	// hpa
-	if len(someApp.Spec.SetHpa) > 0 {
-		sh := hpa.SomeHpa{StandardLabels: standardLabels}
-		err = sh.Reconcile(ctx, someApp, r.Client, r.Scheme, log)
+		err = r.reconcileChild(ctx, step.Name, step.Reconciler, someApp, childClient, log)
+		r.warnNotOwned(someApp, err)
+		if errors.Is(err, istio.ErrWaitingForStable) {
+			// stable vs/dr are watched, canary will be enqueued when they changed
+			log.Info("canary waiting for stable", "reason", err.Error())
+			eventRecord.Eventf(someApp, core_v1.EventTypeNormal, "WaitingForStable", "Someapp %s.%s, %s", someApp.Name, someApp.Namespace, err.Error())
+			meta.SetStatusCondition(&someApp.Status.Conditions, meta_v1.Condition{
+				Type:               opsv1.ConditionWaitingForStable,
+				Status:             meta_v1.ConditionTrue,
+				Reason:             "StableNotFound",
+				Message:            err.Error(),
+				ObservedGeneration: someApp.GetGeneration(),
+			})
+			someApp.Status.Status.Phase = STATUS_CREATE
+			return result, r.Status().Update(ctx, someApp)
+		}
+		if errors.Is(err, istio.ErrNoCallers) {
+			// callers are watched by index, someapp will be enqueued when they created
+			waitingForCallers = true
+			log.Info("authorizationpolicy waiting for callers", "reason", err.Error())
+			eventRecord.Eventf(someApp, core_v1.EventTypeWarning, "WaitingForCallers", "Someapp %s.%s, %s", someApp.Name, someApp.Namespace, err.Error())
+			meta.SetStatusCondition(&someApp.Status.Conditions, meta_v1.Condition{
+				Type:               opsv1.ConditionWaitingForCallers,
+				Status:             meta_v1.ConditionTrue,
+				Reason:             "CallersNotFound",
+				Message:            err.Error(),
+				ObservedGeneration: someApp.GetGeneration(),
+			})
+			continue
+		}
 		if err != nil {
 			someApp.Status.Status.Phase = STATUS_ERROR
 			err := r.Status().Update(ctx, someApp)
@@ -187,35 +342,96 @@ func (r *SomeappReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 		}
 	}
 
-	// svc
-	if someApp.Spec.AppType == opsv1.AppTypeApi {
-		sv := service.SomeService{Stage: stage}
-		err = sv.Reconcile(ctx, someApp, r.Client, r.Scheme, log)
-		if err != nil {
-			someApp.Status.Status.Phase = STATUS_ERROR
-			err := r.Status().Update(ctx, someApp)
-			if err != nil {
-				return resultWithRequeue, err
-			}
-			return resultWithRequeue, nil
+	if istioEnabled {
+		if stage == opsv1.CanaryStage {
+			meta.SetStatusCondition(&someApp.Status.Conditions, meta_v1.Condition{
+				Type:               opsv1.ConditionWaitingForStable,
+				Status:             meta_v1.ConditionFalse,
+				Reason:             "StableFound",
+				Message:            "canary routes added to stable vs/dr",
+				ObservedGeneration: someApp.GetGeneration(),
+			})
+		}
+		if !waitingForCallers {
+			meta.RemoveStatusCondition(&someApp.Status.Conditions, opsv1.ConditionWaitingForCallers)
+		}
+	} else {
+		meta.RemoveStatusCondition(&someApp.Status.Conditions, opsv1.ConditionWaitingForStable)
+		meta.RemoveStatusCondition(&someApp.Status.Conditions, opsv1.ConditionWaitingForCallers)
+	}
+
+	// nothing changed in dry-run, drifts and spec not applied
+	if dryRun {
+		if err := r.setAvailable(ctx, someApp, nameValue); err != nil {
+			return resultWithRequeue, err
 		}
+		return result, r.writePlan(ctx, someApp, planClient.Plan)
+	}
+	someApp.Status.Plan = nil
+	meta.RemoveStatusCondition(&someApp.Status.Conditions, opsv1.ConditionDryRun)
 
+	// adopted in this reconcile, owned by someapp later
+	if len(adoption.Adopted) > 0 {
+		adopted := strings.Join(adoption.Adopted, ", ")
+		log.Info("adopted existing resources", "adopted", adopted)
+		eventRecord.Eventf(someApp, core_v1.EventTypeNormal, "Adopted", "Someapp %s.%s adopted %s", someApp.Name, someApp.Namespace, adopted)
+		meta.SetStatusCondition(&someApp.Status.Conditions, meta_v1.Condition{
+			Type:               opsv1.ConditionAdopted,
+			Status:             meta_v1.ConditionTrue,
+			Reason:             "Adopted",
+			Message:            adopted,
+			ObservedGeneration: someApp.GetGeneration(),
+		})
 	}
-	// istio
-	if someApp.Spec.EnableIstio && someApp.Spec.AppType == opsv1.AppTypeApi {
-		si := istio.SomeIstio{Stage: stage}
-		err = si.Reconcile(ctx, someApp, r.Client, r.Scheme, log)
-		if err != nil {
-			someApp.Status.Status.Phase = STATUS_ERROR
-			err := r.Status().Update(ctx, someApp)
-			if err != nil {
-				return resultWithRequeue, err
-			}
-			return resultWithRequeue, nil
+
+	// drifts reverted by apply in this reconcile
+	if len(*drifts) > 0 {
+		for _, drift := range *drifts {
+			metrics.DriftTotal.WithLabelValues(someApp.Namespace, drift.Kind).Inc()
 		}
+		log.Info("drift reverted", "drifts", drifts.String())
+		eventRecord.Eventf(someApp, core_v1.EventTypeWarning, "Drifted", "Someapp %s.%s, reverted fields changed out of band, %s", someApp.Name, someApp.Namespace, drifts.String())
+		meta.SetStatusCondition(&someApp.Status.Conditions, meta_v1.Condition{
+			Type:               opsv1.ConditionDrifted,
+			Status:             meta_v1.ConditionTrue,
+			Reason:             "DriftReverted",
+			Message:            drifts.String(),
+			ObservedGeneration: someApp.GetGeneration(),
+		})
+	} else {
+		meta.SetStatusCondition(&someApp.Status.Conditions, meta_v1.Condition{
+			Type:               opsv1.ConditionDrifted,
+			Status:             meta_v1.ConditionFalse,
+			Reason:             "NoDrift",
+			Message:            "child resources match someapp",
+			ObservedGeneration: someApp.GetGeneration(),
+		})
+	}
+
+	// hand edits while paused are reverted as drifts, spec changes applied
+	if resumed {
+		changes := drifts.String()
+		if len(changes) == 0 {
+			changes = "no child resources changed"
+		}
+		if someApp.Status.ObservedGeneration != someApp.GetGeneration() {
+			changes = fmt.Sprintf("spec generation %d -> %d applied, %s", someApp.Status.ObservedGeneration, someApp.GetGeneration(), changes)
+		}
+		log.Info("someapp resumed", "changes", changes)
+		eventRecord.Eventf(someApp, core_v1.EventTypeNormal, "Resumed", "Someapp %s.%s resumed, %s", someApp.Name, someApp.Namespace, changes)
+	}
+	meta.SetStatusCondition(&someApp.Status.Conditions, meta_v1.Condition{
+		Type:               opsv1.ConditionPaused,
+		Status:             meta_v1.ConditionFalse,
+		Reason:             "Reconciling",
+		Message:            "child resources reconciled",
+		ObservedGeneration: someApp.GetGeneration(),
+	})
+
+	if err := r.setAvailable(ctx, someApp, nameValue); err != nil {
+		return resultWithRequeue, err
 	}
 
-	someApp.Status.Status.Phase = STATUS_RUNNING
 	someApp.Status.ObservedGeneration = someApp.GetGeneration()
 	err = r.Status().Update(ctx, someApp)
 	if err != nil {
@@ -225,24 +441,215 @@ func (r *SomeappReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 	return result, nil
 }
 
+// warnNotOwned warn existing resources not owned by someapp, fixed by adopt annotation not by retry
+func (r *SomeappReconciler) warnNotOwned(someApp *opsv1.Someapp, err error) {
+	if errors.Is(err, owner.ErrNotOwned) {
+		r.EventRecorder.Eventf(someApp, core_v1.EventTypeWarning, "NotOwned", "Someapp %s.%s, %s", someApp.Name, someApp.Namespace, err.Error())
+	}
+}
+
+// writePlan write changes planned in dry-run to status and events
+func (r *SomeappReconciler) writePlan(ctx context.Context, someApp *opsv1.Someapp, plan dryrun.Plan) error {
+	reason, message := "NoChanges", "child resources up to date"
+	if len(plan) > 0 {
+		reason, message = "Planned", plan.String()
+		r.EventRecorder.Eventf(someApp, core_v1.EventTypeNormal, "Planned", "Someapp %s.%s dry-run, %s", someApp.Name, someApp.Namespace, message)
+	}
+	log.FromContext(ctx).Info("dry-run planned", "changes", len(plan), "plan", message)
+
+	someApp.Status.Plan = plan.Strings()
+	meta.SetStatusCondition(&someApp.Status.Conditions, meta_v1.Condition{
+		Type:               opsv1.ConditionDryRun,
+		Status:             meta_v1.ConditionTrue,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: someApp.GetGeneration(),
+	})
+	return r.Status().Update(ctx, someApp)
+}
+
+// setAvailable set condition Available and phase from deployment status,
+// reconciled again when deployment status changed
+func (r *SomeappReconciler) setAvailable(ctx context.Context, someApp *opsv1.Someapp, name string) error {
+	available, reason, message := false, "NotFound", "deployment not found"
+	deploy := &apps_v1.Deployment{}
+	err := r.Get(ctx, client.ObjectKey{Namespace: someApp.Namespace, Name: name}, deploy)
+	switch {
+	case err == nil:
+		available, reason, message = deployment.Available(deploy)
+	case !k8s_errors.IsNotFound(err):
+		return err
+	}
+
+	availableStatus := meta_v1.ConditionTrue
+	someApp.Status.Status.Phase = STATUS_RUNNING
+	if !available {
+		availableStatus = meta_v1.ConditionFalse
+		someApp.Status.Status.Phase = STATUS_UPDATING
+	}
+	meta.SetStatusCondition(&someApp.Status.Conditions, meta_v1.Condition{
+		Type:               opsv1.ConditionAvailable,
+		Status:             availableStatus,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: someApp.GetGeneration(),
+	})
+	return nil
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *SomeappReconciler) SetupWithManager(mgr ctrl.Manager) error {
 
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&opsv1.Someapp{}).
-		Owns(&apps_v1.Deployment{}).
-		Owns(&autoscalingv2.HorizontalPodAutoscaler{}).
-		Owns(&core_v1.Service{}).
-		Owns(&istio_network_v1beta1.DestinationRule{}).
-		Owns(&istio_network_v1beta1.VirtualService{}).
+	// index someapps by referenced configmaps and secrets, for restart deployment when they changed
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &opsv1.Someapp{}, configMapRefIndex, func(obj client.Object) []string {
+		someApp := obj.(*opsv1.Someapp)
+		if someApp.Spec.DisableConfigRestart {
+			return nil
+		}
+		return deployment.ConfigMapRefs(someApp)
+	}); err != nil {
+		return err
+	}
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &opsv1.Someapp{}, secretRefIndex, func(obj client.Object) []string {
+		someApp := obj.(*opsv1.Someapp)
+		if someApp.Spec.DisableConfigRestart {
+			return nil
+		}
+		return deployment.SecretRefs(someApp)
+	}); err != nil {
+		return err
+	}
+
+	// index someapps by spec.name, for enqueue canaries when stable changed
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &opsv1.Someapp{}, appNameIndex, func(obj client.Object) []string {
+		return []string{obj.(*opsv1.Someapp).Spec.AppName}
+	}); err != nil {
+		return err
+	}
+
+	// index someapps by namespace/name of callers, for update authorizationpolicy when callers changed
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &opsv1.Someapp{}, callersIndex, func(obj client.Object) []string {
+		return istio.Callers(obj.(*opsv1.Someapp))
+	}); err != nil {
+		return err
+	}
+
+	// someapp status only updates are ignored, annotations changed for pause
+	generationChanged := builder.WithPredicates(predicate.GenerationChangedPredicate{})
+	specOrAnnotationChanged := builder.WithPredicates(predicate.Or(predicate.GenerationChangedPredicate{}, predicate.AnnotationChangedPredicate{}))
+
+	// owned ones deleted or changed out of band are reconciled at once,
+	// ownerReferences are not controller, so match every owner,
+	// deployment status changes update someapp health,
+	// serviceaccount, rbac, configmap and secret have no generation, so not filtered,
+	// configmaps and secrets only watched by metadata, not cached, read from api server
+	b := ctrl.NewControllerManagedBy(mgr).
+		For(&opsv1.Someapp{}, specOrAnnotationChanged).
+		Owns(&apps_v1.Deployment{}, builder.MatchEveryOwner, ownedDeploymentChanged).
+		Owns(&autoscalingv2.HorizontalPodAutoscaler{}, builder.MatchEveryOwner, ownedSpecChanged).
+		Owns(&core_v1.Service{}, builder.MatchEveryOwner, ownedSpecChanged).
+		Owns(&policy_v1.PodDisruptionBudget{}, builder.MatchEveryOwner, ownedGenerationChanged).
+		Owns(&networking_v1.NetworkPolicy{}, builder.MatchEveryOwner, ownedGenerationChanged).
+		Owns(&networking_v1.Ingress{}, builder.MatchEveryOwner, ownedGenerationChanged).
+		Owns(&core_v1.ServiceAccount{}, builder.MatchEveryOwner).
+		Owns(&rbac_v1.Role{}, builder.MatchEveryOwner).
+		Owns(&rbac_v1.RoleBinding{}, builder.MatchEveryOwner).
+		Owns(&istio_security_v1beta1.PeerAuthentication{}, builder.MatchEveryOwner, ownedGenerationChanged).
+		Owns(&istio_security_v1beta1.AuthorizationPolicy{}, builder.MatchEveryOwner, ownedGenerationChanged).
+		Watches(&core_v1.ConfigMap{}, handler.EnqueueRequestsFromMapFunc(r.someappsReferencing(configMapRefIndex)), builder.OnlyMetadata).
+		Watches(&core_v1.Secret{}, handler.EnqueueRequestsFromMapFunc(r.someappsReferencing(secretRefIndex)), builder.OnlyMetadata).
+		Watches(&opsv1.Someapp{}, handler.EnqueueRequestsFromMapFunc(r.canariesOfStable), generationChanged).
+		Watches(&opsv1.Someapp{}, handler.EnqueueRequestsFromMapFunc(r.targetsOfCaller), generationChanged)
+
+	// vs and dr of detected networking.istio.io version,
+	// stable ones also enqueue canaries waiting for them
+	for _, obj := range istio.OwnedTypes(r.IstioAPIVersion) {
+		b = b.Owns(obj, builder.MatchEveryOwner, ownedGenerationChanged).
+			Watches(obj, handler.EnqueueRequestsFromMapFunc(r.canariesOfStable), generationChanged)
+	}
+
+	return b.
 		WithOptions(controller.Options{
 			MaxConcurrentReconciles: 1,
 			RateLimiter:             someAppRateLimter(),
 		}).
-		WithEventFilter(predicate.GenerationChangedPredicate{}).
 		Complete(r)
 }
 
+// someappsReferencing map a configmap or secret to someapps referencing it by index
+func (r *SomeappReconciler) someappsReferencing(index string) handler.MapFunc {
+	return func(ctx context.Context, obj client.Object) []reconcile.Request {
+		someAppList := &opsv1.SomeappList{}
+		if err := r.List(ctx, someAppList,
+			client.InNamespace(obj.GetNamespace()),
+			client.MatchingFields{index: obj.GetName()}); err != nil {
+			log.FromContext(ctx).Error(err, "list someapps by index", "index", index, "name", obj.GetName())
+			return nil
+		}
+
+		requests := make([]reconcile.Request, 0, len(someAppList.Items))
+		for _, someApp := range someAppList.Items {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: client.ObjectKeyFromObject(&someApp),
+			})
+		}
+		return requests
+	}
+}
+
+// canariesOfStable map stable someapp, vs or dr to canary someapps of the same app
+func (r *SomeappReconciler) canariesOfStable(ctx context.Context, obj client.Object) []reconcile.Request {
+	appName, stage := obj.GetLabels()["app"], obj.GetLabels()["stage"]
+	if someApp, ok := obj.(*opsv1.Someapp); ok {
+		appName, stage = someApp.Spec.AppName, opsv1.CanaryStage
+		if someApp.Spec.AppVersion == opsv1.StableStage {
+			stage = opsv1.StableStage
+		}
+	}
+	if stage != opsv1.StableStage || len(appName) == 0 {
+		return nil
+	}
+
+	someAppList := &opsv1.SomeappList{}
+	if err := r.List(ctx, someAppList,
+		client.InNamespace(obj.GetNamespace()),
+		client.MatchingFields{appNameIndex: appName}); err != nil {
+		log.FromContext(ctx).Error(err, "list someapps by index", "index", appNameIndex, "name", appName)
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, someApp := range someAppList.Items {
+		if someApp.Spec.AppVersion == opsv1.StableStage {
+			continue
+		}
+		requests = append(requests, reconcile.Request{
+			NamespacedName: client.ObjectKeyFromObject(&someApp),
+		})
+	}
+	return requests
+}
+
+// targetsOfCaller map a someapp to someapps allowing it as caller by index,
+// its serviceaccount is in principals of their authorizationpolicy
+func (r *SomeappReconciler) targetsOfCaller(ctx context.Context, obj client.Object) []reconcile.Request {
+	caller := obj.GetNamespace() + "/" + obj.(*opsv1.Someapp).Spec.AppName
+
+	someAppList := &opsv1.SomeappList{}
+	if err := r.List(ctx, someAppList, client.MatchingFields{callersIndex: caller}); err != nil {
+		log.FromContext(ctx).Error(err, "list someapps by index", "index", callersIndex, "name", caller)
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0, len(someAppList.Items))
+	for _, someApp := range someAppList.Items {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: client.ObjectKeyFromObject(&someApp),
+		})
+	}
+	return requests
+}
+
 // soma app reteLimiter
 func someAppRateLimter() workqueue.RateLimiter {
 	return workqueue.NewMaxOfRateLimiter(
@@ -0,0 +1,227 @@
+package apply
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/util/csaupgrade"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// FieldManager of server-side apply, owns fields set by someapps
+const FieldManager = "some-app-operator"
+
+// CanaryFieldManager owns canary routes applied by canary someapps to vs of stable someapp,
+// other fields of the vs stay with FieldManager
+const CanaryFieldManager = FieldManager + "-canary"
+
+// managers of the operator itself, conflicts between them are not drift
+var operatorFieldManagers = sets.New(FieldManager, CanaryFieldManager)
+
+// field managers of CreateOrUpdate before, the operator writes with user agent FieldManager,
+// their fields are moved to FieldManager, so fields not set any more are removed by apply.
+// Fields written by old versions as binary name manager are not moved, shared by other controllers
+var legacyFieldManagers = sets.New(FieldManager)
+
+// Drift fields of a child resource changed out of band, owned by other field managers
+type Drift struct {
+	Kind   string
+	Name   string
+	Fields []string
+}
+
+// Drifts collect drifts found by Apply in one reconcile
+type Drifts []Drift
+
+func (d *Drifts) add(kind, name string, fields []string) {
+	if d == nil {
+		return
+	}
+	*d = append(*d, Drift{Kind: kind, Name: name, Fields: fields})
+}
+
+func (d Drifts) String() string {
+	msgs := make([]string, 0, len(d))
+	for _, drift := range d {
+		msgs = append(msgs, fmt.Sprintf("%s/%s %s", drift.Kind, drift.Name, strings.Join(drift.Fields, ", ")))
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Apply server-side apply obj with FieldManager, only fields set in obj are owned,
+// fields set by others like hpa replicas are kept.
+// Fields of obj changed by other managers are drift, recorded to drifts if not nil, then taken back by force.
+// resourceVersion of obj is kept, as precondition of apply, for read-modify-apply of shared ones.
+func Apply(ctx context.Context, c client.Client, obj client.Object, drifts *Drifts) (controllerutil.OperationResult, error) {
+	return ApplyAs(ctx, c, obj, FieldManager, drifts)
+}
+
+// ApplyAs server-side apply obj like Apply, with field manager of manager,
+// fields not in obj are kept by their managers, for partial objects like canary routes of vs
+func ApplyAs(ctx context.Context, c client.Client, obj client.Object, manager string, drifts *Drifts) (controllerutil.OperationResult, error) {
+	gvk, err := apiutil.GVKForObject(obj, c.Scheme())
+	if err != nil {
+		return controllerutil.OperationResultNone, err
+	}
+
+	// existing one, for upgrade managed fields and operation result
+	newObj, err := c.Scheme().New(gvk)
+	if err != nil {
+		return controllerutil.OperationResultNone, err
+	}
+	existing, ok := newObj.(client.Object)
+	if !ok {
+		return controllerutil.OperationResultNone, fmt.Errorf("%s is not a client.Object", gvk)
+	}
+	exists := true
+	if err := c.Get(ctx, client.ObjectKeyFromObject(obj), existing); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return controllerutil.OperationResultNone, err
+		}
+		exists = false
+	}
+
+	if exists {
+		patch, err := csaupgrade.UpgradeManagedFieldsPatch(existing, legacyFieldManagers, manager)
+		if err != nil {
+			return controllerutil.OperationResultNone, err
+		}
+		if patch != nil {
+			if err := c.Patch(ctx, existing, client.RawPatch(types.JSONPatchType, patch)); err != nil {
+				return controllerutil.OperationResultNone, err
+			}
+		}
+	}
+
+	applyObj, err := toApplyObject(obj, gvk)
+	if err != nil {
+		return controllerutil.OperationResultNone, err
+	}
+	err = c.Patch(ctx, applyObj, client.Apply, client.FieldOwner(manager))
+	conflicted, fields := conflictFields(err)
+	if conflicted {
+		if applyObj, err = toApplyObject(obj, gvk); err != nil {
+			return controllerutil.OperationResultNone, err
+		}
+		err = c.Patch(ctx, applyObj, client.Apply, client.FieldOwner(manager), client.ForceOwnership)
+	}
+	if err != nil {
+		return controllerutil.OperationResultNone, err
+	}
+
+	// fields taken by force are drift only when values changed, not when the same ones applied,
+	// like weights of atomic vs routes edited by kubectl and copied through by the next apply
+	if len(fields) > 0 {
+		changed, err := contentChanged(existing, applyObj)
+		if err != nil {
+			return controllerutil.OperationResultNone, err
+		}
+		if changed {
+			drifts.add(gvk.Kind, obj.GetName(), fields)
+		}
+	}
+
+	switch {
+	case !exists:
+		return controllerutil.OperationResultCreated, nil
+	case applyObj.GetResourceVersion() != existing.GetResourceVersion():
+		return controllerutil.OperationResultUpdated, nil
+	}
+	return controllerutil.OperationResultNone, nil
+}
+
+// toApplyObject convert obj to apply patch, without status and fields set by api server
+func toApplyObject(obj client.Object, gvk schema.GroupVersionKind) (*unstructured.Unstructured, error) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	u := &unstructured.Unstructured{}
+	if err := json.Unmarshal(data, &u.Object); err != nil {
+		return nil, err
+	}
+
+	u.SetGroupVersionKind(gvk)
+	delete(u.Object, "status")
+	for _, field := range []string{"creationTimestamp", "deletionTimestamp", "generation", "managedFields", "uid", "selfLink"} {
+		unstructured.RemoveNestedField(u.Object, "metadata", field)
+	}
+	return u, nil
+}
+
+// contentChanged compare before and after of apply, without status and metadata fields set by api server
+func contentChanged(before, after client.Object) (bool, error) {
+	contents := make([]map[string]interface{}, 0, 2)
+	for _, obj := range []client.Object{before, after} {
+		// both through json, so numbers are the same type
+		data, err := json.Marshal(obj)
+		if err != nil {
+			return false, err
+		}
+		content := map[string]interface{}{}
+		if err := json.Unmarshal(data, &content); err != nil {
+			return false, err
+		}
+		for _, field := range []string{"apiVersion", "kind", "status"} {
+			delete(content, field)
+		}
+		for _, field := range []string{"creationTimestamp", "generation", "managedFields", "resourceVersion", "uid", "selfLink"} {
+			unstructured.RemoveNestedField(content, "metadata", field)
+		}
+		contents = append(contents, content)
+	}
+	return !reflect.DeepEqual(contents[0], contents[1]), nil
+}
+
+// conflictFields return true if err is apply conflict with other field managers,
+// and fields conflicted with managers not of the operator, like kubectl edit
+func conflictFields(err error) (bool, []string) {
+	var status apierrors.APIStatus
+	if err == nil || !errors.As(err, &status) {
+		return false, nil
+	}
+	details := status.Status().Details
+	if status.Status().Reason != meta_v1.StatusReasonConflict || details == nil {
+		return false, nil
+	}
+
+	conflicted := false
+	var fields []string
+	for _, cause := range details.Causes {
+		if cause.Type != meta_v1.CauseTypeFieldManagerConflict {
+			continue
+		}
+		conflicted = true
+		if operatorFieldManagers.Has(conflictManager(cause.Message)) {
+			continue
+		}
+		fields = append(fields, cause.Field+" ("+cause.Message+")")
+	}
+	return conflicted, fields
+}
+
+// conflictManager return manager name of conflict message, like conflict with "kubectl-edit" using apps/v1
+func conflictManager(message string) string {
+	quoted, err := strconv.QuotedPrefix(strings.TrimPrefix(message, "conflict with "))
+	if err != nil {
+		return ""
+	}
+	manager, err := strconv.Unquote(quoted)
+	if err != nil {
+		return ""
+	}
+	return manager
+}
@@ -0,0 +1,153 @@
+package apply
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	core_v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+func TestApplyConflict(t *testing.T) {
+	conflict := func(manager string) meta_v1.StatusCause {
+		return meta_v1.StatusCause{Type: meta_v1.CauseTypeFieldManagerConflict, Field: ".data.a",
+			Message: `conflict with "` + manager + `" using v1`}
+	}
+
+	tests := []struct {
+		name     string
+		existing bool
+		// existing has the value applied
+		same        bool
+		conflicts   []meta_v1.StatusCause
+		applyErr    error
+		wantResult  controllerutil.OperationResult
+		wantApplies int
+		wantDrifts  int
+		wantErr     bool
+	}{
+		{name: "created", wantResult: controllerutil.OperationResultCreated, wantApplies: 1},
+		{name: "updated", existing: true, wantResult: controllerutil.OperationResultUpdated, wantApplies: 1},
+		{name: "drift by kubectl edit", existing: true, conflicts: []meta_v1.StatusCause{conflict("kubectl-edit")},
+			wantResult: controllerutil.OperationResultUpdated, wantApplies: 2, wantDrifts: 1},
+		{name: "kubectl edit of the same value not drift", existing: true, same: true, conflicts: []meta_v1.StatusCause{conflict("kubectl-edit")},
+			wantResult: controllerutil.OperationResultUpdated, wantApplies: 2},
+		{name: "conflict with canary manager not drift", existing: true, conflicts: []meta_v1.StatusCause{conflict(CanaryFieldManager)},
+			wantResult: controllerutil.OperationResultUpdated, wantApplies: 2},
+		{name: "other error", existing: true, applyErr: apierrors.NewForbidden(core_v1.Resource("configmaps"), "app-a", errors.New("denied")),
+			wantResult: controllerutil.OperationResultNone, wantApplies: 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := fake.NewClientBuilder()
+			if tt.existing {
+				value := "0"
+				if tt.same {
+					value = "1"
+				}
+				b = b.WithObjects(&core_v1.ConfigMap{ObjectMeta: meta_v1.ObjectMeta{Name: "app-a", Namespace: "default"}, Data: map[string]string{"a": value}})
+			}
+
+			// fake client has no server-side apply, done as create or merge patch
+			applies := 0
+			c := b.WithInterceptorFuncs(interceptor.Funcs{
+				Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+					if patch.Type() != types.ApplyPatchType {
+						return c.Patch(ctx, obj, patch, opts...)
+					}
+					applies++
+					po := &client.PatchOptions{}
+					po.ApplyOptions(opts)
+					if tt.applyErr != nil {
+						return tt.applyErr
+					}
+					if len(tt.conflicts) > 0 && (po.Force == nil || !*po.Force) {
+						return apierrors.NewApplyConflict(tt.conflicts, "apply conflicts")
+					}
+					if !tt.existing {
+						return c.Create(ctx, obj)
+					}
+					data, err := json.Marshal(obj)
+					if err != nil {
+						return err
+					}
+					return c.Patch(ctx, obj, client.RawPatch(types.MergePatchType, data))
+				},
+			}).Build()
+
+			var drifts Drifts
+			cm := &core_v1.ConfigMap{ObjectMeta: meta_v1.ObjectMeta{Name: "app-a", Namespace: "default"}, Data: map[string]string{"a": "1"}}
+			result, err := Apply(context.Background(), c, cm, &drifts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Apply() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if result != tt.wantResult {
+				t.Errorf("Apply() = %v, want %v", result, tt.wantResult)
+			}
+			if applies != tt.wantApplies {
+				t.Errorf("applies = %d, want %d", applies, tt.wantApplies)
+			}
+			if len(drifts) != tt.wantDrifts {
+				t.Errorf("drifts = %v, want %d", drifts, tt.wantDrifts)
+			}
+		})
+	}
+}
+
+func TestConflictManager(t *testing.T) {
+	tests := []struct {
+		message string
+		want    string
+	}{
+		{message: `conflict with "kubectl-edit" using apps/v1`, want: "kubectl-edit"},
+		{message: `conflict with "some-app-operator-canary": .spec.http`, want: CanaryFieldManager},
+		{message: `conflict with "a \"quoted\" manager" using v1`, want: `a "quoted" manager`},
+		{message: "conflict with kubectl", want: ""},
+		{message: "", want: ""},
+	}
+	for _, tt := range tests {
+		if got := conflictManager(tt.message); got != tt.want {
+			t.Errorf("conflictManager(%q) = %q, want %q", tt.message, got, tt.want)
+		}
+	}
+}
+
+func TestApplyUpgradeLegacyFields(t *testing.T) {
+	existing := &core_v1.ConfigMap{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "app-a", Namespace: "default", ManagedFields: []meta_v1.ManagedFieldsEntry{{
+			Manager: FieldManager, Operation: meta_v1.ManagedFieldsOperationUpdate, APIVersion: "v1",
+			FieldsType: "FieldsV1", FieldsV1: &meta_v1.FieldsV1{Raw: []byte(`{"f:data":{"f:a":{},"f:b":{}}}`)},
+		}}},
+		Data: map[string]string{"a": "0", "b": "0"},
+	}
+	c := fake.NewClientBuilder().WithObjects(existing).WithInterceptorFuncs(interceptor.Funcs{
+		Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+			if patch.Type() == types.ApplyPatchType {
+				return nil
+			}
+			return c.Patch(ctx, obj, patch, opts...)
+		},
+	}).Build()
+
+	cm := &core_v1.ConfigMap{ObjectMeta: meta_v1.ObjectMeta{Name: "app-a", Namespace: "default"}, Data: map[string]string{"a": "1"}}
+	if _, err := Apply(context.Background(), c, cm, nil); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+
+	got := &core_v1.ConfigMap{}
+	if err := c.Get(context.Background(), client.ObjectKeyFromObject(cm), got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.ManagedFields) != 1 || got.ManagedFields[0].Operation != meta_v1.ManagedFieldsOperationApply {
+		t.Errorf("managedFields = %v, want update of %s upgraded to apply", got.ManagedFields, FieldManager)
+	}
+}
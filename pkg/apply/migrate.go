@@ -0,0 +1,96 @@
+package apply
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/structured-merge-diff/v4/fieldpath"
+)
+
+// MigrateAPIVersion re-store obj through the version of its type, and move managed fields of the operator's
+// managers written as other versions to it, like istio vs/dr applied as v1beta1 before v1 detected.
+// Field paths are not converted, only for versions of the same schema
+func MigrateAPIVersion(ctx context.Context, c client.Client, obj client.Object) error {
+	gvk, err := apiutil.GVKForObject(obj, c.Scheme())
+	if err != nil {
+		return err
+	}
+	fields, err := moveManagedFields(obj.GetManagedFields(), gvk.GroupVersion().String())
+	if err != nil {
+		return err
+	}
+
+	// written even if managed fields not changed, so re-stored with current storage version,
+	// replace resourceVersion like TakeOver, conflict if changed since read
+	patch, err := json.Marshal([]map[string]interface{}{
+		{"op": "replace", "path": "/metadata/managedFields", "value": fields},
+		{"op": "replace", "path": "/metadata/resourceVersion", "value": obj.GetResourceVersion()},
+	})
+	if err != nil {
+		return err
+	}
+	return c.Patch(ctx, obj, client.RawPatch(types.JSONPatchType, patch))
+}
+
+// moveManagedFields set apiVersion of entries of operatorFieldManagers to apiVersion,
+// entries of the same manager, operation and subresource are merged, others kept as they are
+func moveManagedFields(entries []meta_v1.ManagedFieldsEntry, apiVersion string) ([]meta_v1.ManagedFieldsEntry, error) {
+	type entryKey struct {
+		manager     string
+		operation   meta_v1.ManagedFieldsOperationType
+		subresource string
+	}
+
+	fields := make([]meta_v1.ManagedFieldsEntry, 0, len(entries))
+	moved := map[entryKey]int{}
+	for _, entry := range entries {
+		if !operatorFieldManagers.Has(entry.Manager) {
+			fields = append(fields, entry)
+			continue
+		}
+
+		entry := *entry.DeepCopy()
+		entry.APIVersion = apiVersion
+		key := entryKey{manager: entry.Manager, operation: entry.Operation, subresource: entry.Subresource}
+		i, found := moved[key]
+		if !found {
+			moved[key] = len(fields)
+			fields = append(fields, entry)
+			continue
+		}
+
+		merged, err := unionFields(fields[i].FieldsV1, entry.FieldsV1)
+		if err != nil {
+			return nil, err
+		}
+		fields[i].FieldsV1 = merged
+		if entry.Time != nil && (fields[i].Time == nil || fields[i].Time.Before(entry.Time)) {
+			fields[i].Time = entry.Time
+		}
+	}
+	return fields, nil
+}
+
+func unionFields(a, b *meta_v1.FieldsV1) (*meta_v1.FieldsV1, error) {
+	set := &fieldpath.Set{}
+	for _, fields := range []*meta_v1.FieldsV1{a, b} {
+		if fields == nil {
+			continue
+		}
+		s := &fieldpath.Set{}
+		if err := s.FromJSON(bytes.NewReader(fields.Raw)); err != nil {
+			return nil, err
+		}
+		set = set.Union(s)
+	}
+	raw, err := set.ToJSON()
+	if err != nil {
+		return nil, err
+	}
+	return &meta_v1.FieldsV1{Raw: raw}, nil
+}
@@ -0,0 +1,96 @@
+package apply
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/util/csaupgrade"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/structured-merge-diff/v4/fieldpath"
+)
+
+// keptFields stay with their managers on take over, someapp does not set them,
+// like deployment replicas scaled by hand or hpa
+var keptFields = map[schema.GroupKind]*fieldpath.Set{
+	{Group: "apps", Kind: "Deployment"}: fieldpath.NewSet(fieldpath.MakePathOrDie("spec", "replicas")),
+}
+
+// TakeOver move fields written by update of other managers (kubectl create/edit, other controllers) to FieldManager,
+// for adopted objects, so fields not set by someapp are removed by next apply.
+// Fields stay behind: keptFields, fields of status and scale subresources, and fields applied
+// by other managers with server-side apply, they are not ours to remove
+func TakeOver(ctx context.Context, c client.Client, obj client.Object) error {
+	managers := sets.New[string]()
+	for _, entry := range obj.GetManagedFields() {
+		if entry.Operation == meta_v1.ManagedFieldsOperationUpdate && entry.Subresource == "" && !operatorFieldManagers.Has(entry.Manager) {
+			managers.Insert(entry.Manager)
+		}
+	}
+	if managers.Len() == 0 {
+		return nil
+	}
+
+	gvk, err := apiutil.GVKForObject(obj, c.Scheme())
+	if err != nil {
+		return err
+	}
+	fields, kept, err := splitKeptFields(obj.GetManagedFields(), managers, keptFields[gvk.GroupKind()])
+	if err != nil {
+		return err
+	}
+	obj.SetManagedFields(fields)
+	if err := csaupgrade.UpgradeManagedFields(obj, managers, FieldManager); err != nil {
+		return err
+	}
+
+	// replace resourceVersion like csaupgrade, conflict if changed since read
+	patch, err := json.Marshal([]map[string]interface{}{
+		{"op": "replace", "path": "/metadata/managedFields", "value": append(obj.GetManagedFields(), kept...)},
+		{"op": "replace", "path": "/metadata/resourceVersion", "value": obj.GetResourceVersion()},
+	})
+	if err != nil {
+		return err
+	}
+	return c.Patch(ctx, obj, client.RawPatch(types.JSONPatchType, patch))
+}
+
+// splitKeptFields remove keep from update entries of managers, return them and entries of the kept fields
+func splitKeptFields(entries []meta_v1.ManagedFieldsEntry, managers sets.Set[string], keep *fieldpath.Set) (fields, kept []meta_v1.ManagedFieldsEntry, err error) {
+	fields = make([]meta_v1.ManagedFieldsEntry, 0, len(entries))
+	for _, entry := range entries {
+		if keep == nil || entry.FieldsV1 == nil || entry.Operation != meta_v1.ManagedFieldsOperationUpdate ||
+			entry.Subresource != "" || !managers.Has(entry.Manager) {
+			fields = append(fields, entry)
+			continue
+		}
+
+		set := &fieldpath.Set{}
+		if err := set.FromJSON(bytes.NewReader(entry.FieldsV1.Raw)); err != nil {
+			return nil, nil, err
+		}
+		keptSet := set.Intersection(keep)
+		if keptSet.Empty() {
+			fields = append(fields, entry)
+			continue
+		}
+
+		entry, keptEntry := *entry.DeepCopy(), *entry.DeepCopy()
+		if keptEntry.FieldsV1.Raw, err = keptSet.ToJSON(); err != nil {
+			return nil, nil, err
+		}
+		kept = append(kept, keptEntry)
+		if rest := set.Difference(keep); !rest.Empty() {
+			if entry.FieldsV1.Raw, err = rest.ToJSON(); err != nil {
+				return nil, nil, err
+			}
+			fields = append(fields, entry)
+		}
+	}
+	return fields, kept, nil
+}
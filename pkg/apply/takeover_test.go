@@ -0,0 +1,91 @@
+package apply
+
+import (
+	"context"
+	"testing"
+
+	apps_v1 "k8s.io/api/apps/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestTakeOver(t *testing.T) {
+	update := func(manager, subresource, fields string) meta_v1.ManagedFieldsEntry {
+		return meta_v1.ManagedFieldsEntry{Manager: manager, Operation: meta_v1.ManagedFieldsOperationUpdate, APIVersion: "apps/v1",
+			FieldsType: "FieldsV1", FieldsV1: &meta_v1.FieldsV1{Raw: []byte(fields)}, Subresource: subresource}
+	}
+	applied := func(manager, fields string) meta_v1.ManagedFieldsEntry {
+		entry := update(manager, "", fields)
+		entry.Operation = meta_v1.ManagedFieldsOperationApply
+		return entry
+	}
+
+	tests := []struct {
+		name   string
+		fields []meta_v1.ManagedFieldsEntry
+		want   map[string]string // manager/operation to fields
+	}{
+		{
+			name:   "created by kubectl, replicas kept",
+			fields: []meta_v1.ManagedFieldsEntry{update("kubectl-create", "", `{"f:spec":{"f:replicas":{},"f:strategy":{}}}`)},
+			want: map[string]string{
+				FieldManager + "/Apply": `{"f:spec":{"f:strategy":{}}}`,
+				"kubectl-create/Update": `{"f:spec":{"f:replicas":{}}}`,
+			},
+		},
+		{
+			name: "edits merged, others applied and scale kept",
+			fields: []meta_v1.ManagedFieldsEntry{
+				update("kubectl-edit", "", `{"f:metadata":{"f:annotations":{"f:a":{}}}}`),
+				update("kube-controller-manager", "", `{"f:metadata":{"f:annotations":{"f:b":{}}}}`),
+				applied("argocd", `{"f:metadata":{"f:labels":{"f:c":{}}}}`),
+				update("hpa", "scale", `{"f:spec":{"f:replicas":{}}}`),
+			},
+			want: map[string]string{
+				FieldManager + "/Apply": `{"f:metadata":{"f:annotations":{"f:a":{},"f:b":{}}}}`,
+				"argocd/Apply":          `{"f:metadata":{"f:labels":{"f:c":{}}}}`,
+				"hpa/Update":            `{"f:spec":{"f:replicas":{}}}`,
+			},
+		},
+		{
+			name:   "only replicas by others",
+			fields: []meta_v1.ManagedFieldsEntry{update("kubectl-scale", "", `{"f:spec":{"f:replicas":{}}}`)},
+			want:   map[string]string{"kubectl-scale/Update": `{"f:spec":{"f:replicas":{}}}`},
+		},
+		{
+			name:   "operator only",
+			fields: []meta_v1.ManagedFieldsEntry{applied(FieldManager, `{"f:spec":{"f:template":{}}}`)},
+			want:   map[string]string{FieldManager + "/Apply": `{"f:spec":{"f:template":{}}}`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			existing := &apps_v1.Deployment{ObjectMeta: meta_v1.ObjectMeta{Name: "app-a", Namespace: "default", ManagedFields: tt.fields}}
+			c := fake.NewClientBuilder().WithObjects(existing).Build()
+
+			obj := &apps_v1.Deployment{}
+			if err := c.Get(context.Background(), client.ObjectKeyFromObject(existing), obj); err != nil {
+				t.Fatal(err)
+			}
+			if err := TakeOver(context.Background(), c, obj); err != nil {
+				t.Fatalf("TakeOver() error = %v", err)
+			}
+
+			got := &apps_v1.Deployment{}
+			if err := c.Get(context.Background(), client.ObjectKeyFromObject(existing), got); err != nil {
+				t.Fatal(err)
+			}
+			if len(got.ManagedFields) != len(tt.want) {
+				t.Fatalf("managedFields = %v, want %v", got.ManagedFields, tt.want)
+			}
+			for _, entry := range got.ManagedFields {
+				key := entry.Manager + "/" + string(entry.Operation)
+				if string(entry.FieldsV1.Raw) != tt.want[key] {
+					t.Errorf("fields of %s = %s, want %s", key, entry.FieldsV1.Raw, tt.want[key])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package children
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	opsv1 "github.com/changqings/some-app-operator/api/v1"
+	"github.com/changqings/some-app-operator/pkg/apply"
+	"github.com/changqings/some-app-operator/pkg/configmap"
+	"github.com/changqings/some-app-operator/pkg/deployment"
+	"github.com/changqings/some-app-operator/pkg/hpa"
+	"github.com/changqings/some-app-operator/pkg/ingress"
+	"github.com/changqings/some-app-operator/pkg/istio"
+	"github.com/changqings/some-app-operator/pkg/networkpolicy"
+	"github.com/changqings/some-app-operator/pkg/owner"
+	"github.com/changqings/some-app-operator/pkg/pdb"
+	"github.com/changqings/some-app-operator/pkg/service"
+	"github.com/changqings/some-app-operator/pkg/serviceaccount"
+)
+
+// Reconciler reconcile child resources of someapp, like deployment, hpa, istio
+type Reconciler interface {
+	Reconcile(ctx context.Context, someApp *opsv1.Someapp, client client.Client, scheme *runtime.Scheme, log logr.Logger) error
+}
+
+// Step of child reconciles, Validate checked before Reconciler run
+type Step struct {
+	// name of sub reconciler, like deployment, hpa, istio
+	Name string
+	// invalid spec will not be fixed by retry, nil if nothing to check
+	Validate   func(someApp *opsv1.Someapp) error
+	Reconciler Reconciler
+}
+
+// Children of someapps, run by the controller, and offline by render,
+// so manifests rendered are the ones the operator creates
+type Children struct {
+	IstioAPIVersion string
+	// trust domain of mesh, for principals of spec.istio.security.apps
+	IstioTrustDomain string
+	// drifts found when apply, nil to ignore
+	Drifts *apply.Drifts
+	// existing resources not owned by someapp, adopted or not, nil to not check
+	Adoption *owner.Adoption
+}
+
+// Steps of children of someApp in order, configmap and serviceaccount before deployment,
+// service before ingress and istio. Istio children created before enableIstio turned off
+// are found by c, and deleted by the last steps
+func (ch *Children) Steps(ctx context.Context, c client.Reader, someApp *opsv1.Someapp) ([]Step, error) {
+	nameValue := deployment.Name(someApp)
+	stage := deployment.Stage(someApp)
+	standardLabels := deployment.StandardLabels(someApp)
+	istioEnabled := someApp.Spec.EnableIstio && someApp.Spec.AppType == opsv1.AppTypeApi
+
+	steps := []Step{
+		// configmap of spec.config, must before deployment
+		{Name: "configmap", Reconciler: &configmap.SomeConfigMap{StandardLabels: standardLabels}},
+		// serviceaccount and rbac, must before deployment
+		{
+			Name:       "serviceaccount",
+			Validate:   serviceaccount.Validate,
+			Reconciler: &serviceaccount.SomeServiceAccount{StandardLabels: standardLabels},
+		},
+		{
+			Name: "deployment",
+			Reconciler: &deployment.SomeDeployment{
+				StandardLabels:     standardLabels,
+				ConfigMapName:      configmap.Name(nameValue, someApp.Spec.Config),
+				ServiceAccountName: serviceaccount.Name(nameValue, someApp.Spec.ServiceAccount),
+				Drifts:             ch.Drifts,
+				Adoption:           ch.Adoption,
+			},
+		},
+	}
+	if len(someApp.Spec.SetHpa) > 0 {
+		steps = append(steps, Step{Name: "hpa", Reconciler: &hpa.SomeHpa{StandardLabels: standardLabels, Drifts: ch.Drifts, Adoption: ch.Adoption}})
+	}
+	steps = append(steps,
+		Step{Name: "pdb", Reconciler: &pdb.SomePdb{StandardLabels: standardLabels}},
+		Step{Name: "networkpolicy", Reconciler: &networkpolicy.SomeNetworkPolicy{StandardLabels: standardLabels}},
+	)
+
+	if someApp.Spec.AppType != opsv1.AppTypeApi {
+		return steps, nil
+	}
+	steps = append(steps,
+		Step{Name: "service", Reconciler: &service.SomeService{Stage: stage, Drifts: ch.Drifts, Adoption: ch.Adoption}},
+		// ingress of spec.expose, when not exposed by istio gateway
+		Step{Name: "ingress", Validate: ingress.Validate, Reconciler: &ingress.SomeIngress{Stage: stage}},
+	)
+
+	if istioEnabled {
+		return append(steps,
+			Step{
+				Name:       "istio",
+				Validate:   istio.Validate,
+				Reconciler: &istio.SomeIstio{Stage: stage, APIVersion: ch.IstioAPIVersion, Drifts: ch.Drifts, Adoption: ch.Adoption},
+			},
+			Step{Name: "security", Reconciler: &istio.SomeSecurity{Stage: stage, Drifts: ch.Drifts, TrustDomain: ch.IstioTrustDomain}},
+		), nil
+	}
+
+	// enableIstio turned off, delete stable vs, dr and security created before,
+	// canary ones removed with finalizer by the controller
+	if stage != opsv1.StableStage {
+		return steps, nil
+	}
+	istioDisabled, err := istio.HasOwned(ctx, c, ch.IstioAPIVersion, someApp)
+	if err != nil || !istioDisabled {
+		return steps, err
+	}
+	return append(steps,
+		Step{Name: "istio", Reconciler: &istio.SomeIstio{Stage: stage, DeleteAction: true, APIVersion: ch.IstioAPIVersion}},
+		Step{Name: "security", Reconciler: &istio.SomeSecurity{Stage: stage, Drifts: ch.Drifts, TrustDomain: ch.IstioTrustDomain}},
+	), nil
+}
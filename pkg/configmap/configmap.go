@@ -0,0 +1,153 @@
+package configmap
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8s_utils_pointer "k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	opsv1 "github.com/changqings/some-app-operator/api/v1"
+	"github.com/changqings/some-app-operator/pkg/dryrun"
+	"github.com/changqings/some-app-operator/pkg/metrics"
+	"github.com/changqings/some-app-operator/pkg/owner"
+	"github.com/go-logr/logr"
+)
+
+const (
+	// configmap key prefix of spec.config.env
+	EnvKeyPrefix = "env."
+	// label on generated configmaps, value is the deployment name
+	ConfigOfLabel = "ops.some.cn/config-of"
+
+	defaultRevisionHistoryLimit = 5
+)
+
+// SomeConfigMap create an immutable configmap from spec.config,
+// name with content hash, so every content change is a new revision,
+// old revisions over spec.config.revisionHistoryLimit will be deleted
+type SomeConfigMap struct {
+	StandardLabels map[string]string
+}
+
+// Data return configmap data of config, env keys with EnvKeyPrefix
+func Data(config *opsv1.SomeConfig) map[string]string {
+	data := make(map[string]string, len(config.Files)+len(config.Env))
+	for k, v := range config.Files {
+		data[k] = v
+	}
+	for k, v := range config.Env {
+		data[EnvKeyPrefix+k] = v
+	}
+	return data
+}
+
+// Name return configmap name of this config revision, empty if no config
+func Name(baseName string, config *opsv1.SomeConfig) string {
+	if config == nil {
+		return ""
+	}
+
+	data := Data(config)
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	h := sha256.New()
+	for _, k := range keys {
+		h.Write([]byte(k))
+		h.Write([]byte{0})
+		h.Write([]byte(data[k]))
+		h.Write([]byte{0})
+	}
+	return baseName + "-config-" + hex.EncodeToString(h.Sum(nil))[:10]
+}
+
+func (sc *SomeConfigMap) Reconcile(ctx context.Context, someApp *opsv1.Someapp, client client.Client, scheme *runtime.Scheme, log logr.Logger) error {
+
+	var (
+		config   = someApp.Spec.Config
+		baseName = sc.StandardLabels["name"]
+		cmName   = Name(baseName, config)
+	)
+
+	if config != nil {
+		configMap := &core_v1.ConfigMap{ObjectMeta: meta_v1.ObjectMeta{
+			Name:      cmName,
+			Namespace: someApp.Namespace,
+		}}
+
+		op, err := controllerutil.CreateOrUpdate(ctx, client, configMap, func() error {
+			// immutable configmap, only set when create
+			if configMap.ObjectMeta.CreationTimestamp.IsZero() {
+				configMap.ObjectMeta.Labels = map[string]string{
+					"app":         someApp.Spec.AppName,
+					"version":     someApp.Spec.AppVersion,
+					ConfigOfLabel: baseName,
+				}
+				configMap.Data = Data(config)
+				configMap.Immutable = k8s_utils_pointer.Bool(true)
+			}
+
+			if err := controllerutil.SetOwnerReference(someApp, configMap, scheme); err != nil {
+				return err
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		metrics.ObserveOperation(dryrun.Enabled(client), "ConfigMap", op)
+		log.Info("configmap reconcile success", "operation_result", op, "configmap", cmName)
+	}
+
+	return sc.cleanup(ctx, someApp, client, cmName, log)
+}
+
+// cleanup delete old config revisions, keep the current one and newest revisionHistoryLimit
+func (sc *SomeConfigMap) cleanup(ctx context.Context, someApp *opsv1.Someapp, c client.Client, current string, log logr.Logger) error {
+
+	limit := defaultRevisionHistoryLimit
+	if someApp.Spec.Config != nil && someApp.Spec.Config.RevisionHistoryLimit != nil {
+		limit = int(*someApp.Spec.Config.RevisionHistoryLimit)
+	}
+
+	cmList := &core_v1.ConfigMapList{}
+	if err := c.List(ctx, cmList,
+		client.InNamespace(someApp.Namespace),
+		client.MatchingLabels{ConfigOfLabel: sc.StandardLabels["name"]}); err != nil {
+		return err
+	}
+
+	old := make([]core_v1.ConfigMap, 0, len(cmList.Items))
+	for _, cm := range cmList.Items {
+		if cm.Name != current && owner.IsOwnedBy(&cm, someApp) {
+			old = append(old, cm)
+		}
+	}
+	if len(old) <= limit {
+		return nil
+	}
+
+	// newest first
+	sort.Slice(old, func(i, j int) bool {
+		return old[j].CreationTimestamp.Before(&old[i].CreationTimestamp)
+	})
+
+	for i := limit; i < len(old); i++ {
+		if err := c.Delete(ctx, &old[i]); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+		log.Info("old configmap deleted", "configmap", old[i].Name)
+	}
+	return nil
+}
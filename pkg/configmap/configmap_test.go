@@ -0,0 +1,107 @@
+package configmap
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	opsv1 "github.com/changqings/some-app-operator/api/v1"
+)
+
+func TestCleanup(t *testing.T) {
+	scheme := runtime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(opsv1.AddToScheme(scheme))
+
+	someApp := &opsv1.Someapp{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "app-a", Namespace: "default", UID: types.UID("uid-a")},
+		Spec:       opsv1.SomeappSpec{AppName: "app-a", AppVersion: opsv1.StableStage},
+	}
+	ownerRef := meta_v1.OwnerReference{APIVersion: opsv1.GroupVersion.String(), Kind: "Someapp", Name: "app-a", UID: someApp.UID}
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	// revision i created i hours after base
+	revision := func(name string, i int, owned bool) client.Object {
+		cm := &core_v1.ConfigMap{ObjectMeta: meta_v1.ObjectMeta{
+			Name: name, Namespace: "default",
+			Labels:            map[string]string{ConfigOfLabel: "app-a"},
+			CreationTimestamp: meta_v1.NewTime(base.Add(time.Duration(i) * time.Hour)),
+		}}
+		if owned {
+			cm.OwnerReferences = []meta_v1.OwnerReference{ownerRef}
+		}
+		return cm
+	}
+
+	tests := []struct {
+		name     string
+		config   *opsv1.SomeConfig
+		existing []client.Object
+		want     []string
+	}{
+		{
+			name:     "under limit kept",
+			config:   &opsv1.SomeConfig{Env: map[string]string{"A": "1"}, RevisionHistoryLimit: pointer.Int32(2)},
+			existing: []client.Object{revision("old-1", 1, true), revision("old-2", 2, true)},
+			want:     []string{"old-1", "old-2"},
+		},
+		{
+			name:     "oldest deleted over limit",
+			config:   &opsv1.SomeConfig{Env: map[string]string{"A": "1"}, RevisionHistoryLimit: pointer.Int32(1)},
+			existing: []client.Object{revision("old-2", 2, true), revision("old-1", 1, true), revision("old-3", 3, true)},
+			want:     []string{"old-3"},
+		},
+		{
+			name:     "not owned kept",
+			config:   &opsv1.SomeConfig{Env: map[string]string{"A": "1"}, RevisionHistoryLimit: pointer.Int32(0)},
+			existing: []client.Object{revision("old-1", 1, true), revision("other", 0, false)},
+			want:     []string{"other"},
+		},
+		{
+			name:     "config removed keep default limit",
+			existing: []client.Object{revision("old-1", 1, true), revision("old-2", 2, true), revision("old-3", 3, true), revision("old-4", 4, true), revision("old-5", 5, true), revision("old-6", 6, true)},
+			want:     []string{"old-2", "old-3", "old-4", "old-5", "old-6"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := someApp.DeepCopy()
+			app.Spec.Config = tt.config
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(tt.existing...).Build()
+
+			sc := &SomeConfigMap{StandardLabels: map[string]string{"name": "app-a"}}
+			if err := sc.Reconcile(context.Background(), app, c, scheme, logr.Discard()); err != nil {
+				t.Fatalf("Reconcile() error = %v", err)
+			}
+
+			want := sets.New(tt.want...)
+			if current := Name("app-a", tt.config); len(current) > 0 {
+				want.Insert(current)
+			}
+			cmList := &core_v1.ConfigMapList{}
+			if err := c.List(context.Background(), cmList); err != nil {
+				t.Fatal(err)
+			}
+			got := sets.New[string]()
+			for _, cm := range cmList.Items {
+				got.Insert(cm.Name)
+			}
+			if !reflect.DeepEqual(sets.List(got), sets.List(want)) {
+				t.Errorf("configmaps = %v, want %v", sets.List(got), sets.List(want))
+			}
+		})
+	}
+}
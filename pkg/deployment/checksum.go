@@ -0,0 +1,153 @@
+package deployment
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+
+	core_v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	opsv1 "github.com/changqings/some-app-operator/api/v1"
+)
+
+// pod template annotation, changed when referenced configmaps or secrets changed
+const ConfigChecksumAnnotation = "ops.some.cn/config-checksum"
+
+// ConfigMapRefs return names of configmaps referenced by someApp volumes, deprecated someVolume included, and containers env
+func ConfigMapRefs(someApp *opsv1.Someapp) []string {
+	names := map[string]struct{}{}
+
+	for _, v := range specVolumes(someApp) {
+		if v.ConfigMap != nil {
+			names[v.ConfigMap.Name] = struct{}{}
+		}
+		if v.Projected != nil {
+			for _, p := range v.Projected.Sources {
+				if p.ConfigMap != nil {
+					names[p.ConfigMap.Name] = struct{}{}
+				}
+			}
+		}
+	}
+
+	for _, c := range someApp.Spec.Containers {
+		for _, e := range c.EnvFrom {
+			if e.ConfigMapRef != nil {
+				names[e.ConfigMapRef.Name] = struct{}{}
+			}
+		}
+		for _, e := range c.Env {
+			if e.ValueFrom != nil && e.ValueFrom.ConfigMapKeyRef != nil {
+				names[e.ValueFrom.ConfigMapKeyRef.Name] = struct{}{}
+			}
+		}
+	}
+
+	return sortedKeys(names)
+}
+
+// SecretRefs return names of secrets referenced by someApp volumes, deprecated someVolume included, and containers env
+func SecretRefs(someApp *opsv1.Someapp) []string {
+	names := map[string]struct{}{}
+
+	for _, v := range specVolumes(someApp) {
+		if v.Secret != nil {
+			names[v.Secret.SecretName] = struct{}{}
+		}
+		if v.Projected != nil {
+			for _, p := range v.Projected.Sources {
+				if p.Secret != nil {
+					names[p.Secret.Name] = struct{}{}
+				}
+			}
+		}
+	}
+
+	for _, c := range someApp.Spec.Containers {
+		for _, e := range c.EnvFrom {
+			if e.SecretRef != nil {
+				names[e.SecretRef.Name] = struct{}{}
+			}
+		}
+		for _, e := range c.Env {
+			if e.ValueFrom != nil && e.ValueFrom.SecretKeyRef != nil {
+				names[e.ValueFrom.SecretKeyRef.Name] = struct{}{}
+			}
+		}
+	}
+
+	return sortedKeys(names)
+}
+
+// configChecksum hash data of all referenced configmaps and secrets,
+// not found ones are skipped, return empty if nothing referenced.
+// Manager client not cache configmaps and secrets, only referenced ones are read from api server
+func configChecksum(ctx context.Context, c client.Client, someApp *opsv1.Someapp) (string, error) {
+
+	cmNames := ConfigMapRefs(someApp)
+	secretNames := SecretRefs(someApp)
+	if len(cmNames) == 0 && len(secretNames) == 0 {
+		return "", nil
+	}
+
+	h := sha256.New()
+	write := func(kind, name string, data map[string][]byte) {
+		h.Write([]byte(kind + "/" + name))
+		h.Write([]byte{0})
+		keys := make([]string, 0, len(data))
+		for k := range data {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			h.Write([]byte(k))
+			h.Write([]byte{0})
+			h.Write(data[k])
+			h.Write([]byte{0})
+		}
+	}
+
+	for _, name := range cmNames {
+		cm := &core_v1.ConfigMap{}
+		if err := c.Get(ctx, types.NamespacedName{Namespace: someApp.Namespace, Name: name}, cm); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return "", err
+		}
+		data := make(map[string][]byte, len(cm.Data)+len(cm.BinaryData))
+		for k, v := range cm.Data {
+			data[k] = []byte(v)
+		}
+		for k, v := range cm.BinaryData {
+			data[k] = v
+		}
+		write("configmap", name, data)
+	}
+
+	for _, name := range secretNames {
+		secret := &core_v1.Secret{}
+		if err := c.Get(ctx, types.NamespacedName{Namespace: someApp.Namespace, Name: name}, secret); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return "", err
+		}
+		write("secret", name, secret.Data)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func sortedKeys(m map[string]struct{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -2,147 +2,203 @@ package deployment
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"strings"
 
 	apps_v1 "k8s.io/api/apps/v1"
 	core_v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 
 	opsv1 "github.com/changqings/some-app-operator/api/v1"
+	"github.com/changqings/some-app-operator/pkg/apply"
+	"github.com/changqings/some-app-operator/pkg/dryrun"
+	"github.com/changqings/some-app-operator/pkg/metrics"
+	"github.com/changqings/some-app-operator/pkg/owner"
 	"github.com/go-logr/logr"
 )
 
 type SomeDeployment struct {
 	StandardLabels map[string]string
+	// generated configmap of spec.config, empty if not set
+	ConfigMapName string
+	// pod serviceaccount of spec.serviceAccount, empty means default
+	ServiceAccountName string
+	// drifts found when apply, nil to ignore
+	Drifts *apply.Drifts
+	// existing deployment not owned by someapp, adopted or not, nil to not check
+	Adoption *owner.Adoption
 }
 
-func (sd *SomeDeployment) Reconcile(ctx context.Context, someApp *opsv1.Someapp, client client.Client, scheme *runtime.Scheme, log logr.Logger) error {
+// Name return deployment name of someApp, also the name label of pods
+// stable use spec.name, canary with version suffix, script with cr name suffix
+func Name(someApp *opsv1.Someapp) string {
+	nameValue := someApp.Spec.AppName
 
-	var (
-		volumeType          string
-		volumeName          string
-		appContainerIndex   int
-		someVolume          = someApp.Spec.SomeVolume
-		volumeTypeConfigMap = "configmap"
-		volumeTypeSecret    = "secret"
-		volumeTypeUnknown   = "unknown"
-		// this is the file name of configMap
-		volumeMountFileName = "some_config.yaml"
-	)
-
-	// reconcile deployment
-	deployment := &apps_v1.Deployment{ObjectMeta: meta_v1.ObjectMeta{
-		Name:      sd.StandardLabels["name"],
-		Namespace: someApp.Namespace,
-	}}
-
-	op, err := controllerutil.CreateOrUpdate(ctx, client, deployment, func() error {
-
-		// check deployment existed
-		// spec.selector is immutable, so set it when create
-		if deployment.ObjectMeta.CreationTimestamp.IsZero() {
-			deployment.ObjectMeta.Labels = sd.StandardLabels
-			deployment.Spec.Selector = &meta_v1.LabelSelector{
-				MatchLabels: sd.StandardLabels,
-			}
+	if someApp.Spec.AppVersion != "stable" {
+		nameValue = someApp.Spec.AppName + "-" + strings.ReplaceAll(someApp.Spec.AppVersion, ".", "-")
+	}
 
-		}
+	if someApp.Spec.AppType == opsv1.AppTypeScript {
+		nameValue = someApp.Spec.AppName + "-" + someApp.Name
+	}
 
-		if n := strings.TrimPrefix(someVolume, "configmap-"); len(n) > 0 {
-			volumeType = volumeTypeConfigMap
-			volumeName = n
-		} else if n := strings.TrimPrefix(someVolume, "secret-"); len(n) > 0 {
-			volumeType = volumeTypeSecret
-			volumeName = n
-		} else {
-			volumeType = volumeTypeUnknown
-		}
+	return nameValue
+}
 
-		for i, c := range someApp.Spec.Containers {
-			if c.Name == "app" {
-				appContainerIndex = i
-				break
-			}
-		}
+// Stage return stable or canary of someApp
+func Stage(someApp *opsv1.Someapp) string {
+	if someApp.Spec.AppVersion != opsv1.StableStage {
+		return opsv1.CanaryStage
+	}
+	return opsv1.StableStage
+}
 
-		// create or update deployment with template
-		deployment.Spec.Template = core_v1.PodTemplateSpec{
-			ObjectMeta: meta_v1.ObjectMeta{
-				Labels: sd.StandardLabels,
-			},
-			Spec: core_v1.PodSpec{
-				Containers: someApp.Spec.Containers,
-			},
+// StandardLabels return labels of children and pods of someApp
+func StandardLabels(someApp *opsv1.Someapp) map[string]string {
+	return map[string]string{
+		"name":    Name(someApp),
+		"app":     someApp.Spec.AppName,
+		"type":    someApp.Spec.AppType,
+		"version": someApp.Spec.AppVersion,
+		"stage":   Stage(someApp),
+	}
+}
+
+func (sd *SomeDeployment) Reconcile(ctx context.Context, someApp *opsv1.Someapp, client client.Client, scheme *runtime.Scheme, log logr.Logger) error {
+
+	var podAnnotations map[string]string
+	if !someApp.Spec.DisableConfigRestart {
+		checksum, err := configChecksum(ctx, client, someApp)
+		if err != nil {
+			return err
 		}
+		if len(checksum) > 0 {
+			podAnnotations = map[string]string{ConfigChecksumAnnotation: checksum}
+		}
+	}
 
-		if len(someApp.Spec.ImagePullSecret) > 0 {
-			deployment.Spec.Template.Spec.ImagePullSecrets = []core_v1.LocalObjectReference{
-				{
-					Name: someApp.Spec.ImagePullSecret,
-				},
-			}
+	// rolling restart by annotation of someapp
+	if restartedAt := someApp.GetAnnotations()[opsv1.RestartedAtAnnotation]; len(restartedAt) > 0 {
+		if podAnnotations == nil {
+			podAnnotations = map[string]string{}
 		}
+		podAnnotations[opsv1.RestartedAtAnnotation] = restartedAt
+	}
 
-		switch volumeType {
-		case volumeTypeConfigMap:
-			deployment.Spec.Template.Spec.Volumes = []core_v1.Volume{
-				{
-					Name: volumeName,
-					VolumeSource: core_v1.VolumeSource{
-						ConfigMap: &core_v1.ConfigMapVolumeSource{
-							LocalObjectReference: core_v1.LocalObjectReference{
-								Name: volumeName,
-							},
-						},
-					},
-				},
-			}
-			deployment.Spec.Template.Spec.Containers[appContainerIndex].VolumeMounts = []core_v1.VolumeMount{
-				{
-					Name:      volumeName,
-					ReadOnly:  true,
-					MountPath: "/app/" + volumeMountFileName,
-					SubPath:   volumeMountFileName,
-				},
-			}
-		case volumeTypeSecret:
-			deployment.Spec.Template.Spec.Volumes = []core_v1.Volume{
-				{
-					Name: volumeName,
-					VolumeSource: core_v1.VolumeSource{
-						Secret: &core_v1.SecretVolumeSource{
-							SecretName: volumeName,
-						},
-					},
+	// deprecated someVolume still mounted for one release, warned by controller
+	volumes := specVolumes(someApp)
+
+	// reconcile deployment, apply owns only fields set here,
+	// replicas managed by hpa and fields set by others are kept
+	deployment := &apps_v1.Deployment{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Name:      sd.StandardLabels["name"],
+			Namespace: someApp.Namespace,
+			Labels:    sd.StandardLabels,
+		},
+		Spec: apps_v1.DeploymentSpec{
+			Selector: &meta_v1.LabelSelector{
+				MatchLabels: sd.StandardLabels,
+			},
+			Template: core_v1.PodTemplateSpec{
+				ObjectMeta: meta_v1.ObjectMeta{
+					Labels:      sd.StandardLabels,
+					Annotations: podAnnotations,
 				},
-			}
-			deployment.Spec.Template.Spec.Containers[appContainerIndex].VolumeMounts = []core_v1.VolumeMount{
-				{
-					Name:      volumeName,
-					ReadOnly:  true,
-					MountPath: "/app/" + volumeMountFileName,
-					SubPath:   volumeMountFileName,
+				Spec: core_v1.PodSpec{
+					Containers: containersWithVolumeMounts(someApp.Spec.Containers, volumes),
+					Volumes:    podVolumes(volumes),
 				},
-			}
-		case volumeTypeUnknown:
-			log.Info("volume type unknown", "only start with configmap- or secret- will work, someVolume", someVolume)
+			},
+		},
+	}
+
+	if len(sd.ServiceAccountName) > 0 {
+		deployment.Spec.Template.Spec.ServiceAccountName = sd.ServiceAccountName
+	}
+	if sa := someApp.Spec.ServiceAccount; sa != nil && sa.Create {
+		deployment.Spec.Template.Spec.AutomountServiceAccountToken = sa.AutomountToken
+	}
+
+	if len(sd.ConfigMapName) > 0 {
+		addConfigMap(&deployment.Spec.Template.Spec, someApp.Spec.Config, sd.ConfigMapName)
+	}
+
+	if len(someApp.Spec.ImagePullSecret) > 0 {
+		deployment.Spec.Template.Spec.ImagePullSecrets = []core_v1.LocalObjectReference{
+			{
+				Name: someApp.Spec.ImagePullSecret,
+			},
 		}
+	}
 
-		// add reference
-		if err := controllerutil.SetOwnerReference(someApp, deployment, scheme); err != nil {
-			return err
+	existing := &apps_v1.Deployment{}
+	found, adopted, err := sd.Adoption.Get(ctx, client, types.NamespacedName{Namespace: deployment.Namespace, Name: deployment.Name}, existing, sd.adoptable)
+	if err != nil {
+		return err
+	}
+	// selector is immutable, keep the one of adopted deployment, and pods still match it
+	if found && existing.Spec.Selector != nil && !equality.Semantic.DeepEqual(existing.Spec.Selector, deployment.Spec.Selector) {
+		podLabels, err := selectedLabels(sd.StandardLabels, existing.Spec.Selector)
+		if err != nil {
+			return fmt.Errorf("deployment %s: %w", deployment.Name, err)
 		}
+		deployment.Spec.Selector = existing.Spec.Selector
+		deployment.Spec.Template.ObjectMeta.Labels = podLabels
+	}
 
-		return nil
-	})
+	// add reference
+	if err := controllerutil.SetOwnerReference(someApp, deployment, scheme); err != nil {
+		return err
+	}
+
+	// fields of adopted one set by others are taken over, not drift
+	drifts := sd.Drifts
+	if adopted {
+		drifts = nil
+	}
+	op, err := apply.Apply(ctx, client, deployment, drifts)
 	if err != nil {
 		return err
 	}
 
+	metrics.ObserveOperation(dryrun.Enabled(client), "Deployment", op)
 	log.Info("deployment reconcile success", "operation_result", op)
 	return nil
 
 }
+
+// adoptable check selector of existing deployment obj can be kept by selectedLabels
+func (sd *SomeDeployment) adoptable(obj client.Object) error {
+	_, err := selectedLabels(sd.StandardLabels, obj.(*apps_v1.Deployment).Spec.Selector)
+	return err
+}
+
+// selectedLabels return pod labels with labels of selector, so pods are still selected.
+// Selector with matchExpressions or other values of standard labels is refused, pods
+// would not be selected by it, or not by selectors of service, pdb and networkpolicy
+func selectedLabels(labels map[string]string, selector *meta_v1.LabelSelector) (map[string]string, error) {
+	if selector == nil {
+		return labels, nil
+	}
+	if len(selector.MatchExpressions) > 0 {
+		return nil, errors.New("selector with matchExpressions not supported")
+	}
+	podLabels := make(map[string]string, len(labels)+len(selector.MatchLabels))
+	for k, v := range labels {
+		podLabels[k] = v
+	}
+	for k, v := range selector.MatchLabels {
+		if standard, ok := labels[k]; ok && standard != v {
+			return nil, fmt.Errorf("selector label %s=%s conflicts with %s=%s", k, v, k, standard)
+		}
+		podLabels[k] = v
+	}
+	return podLabels, nil
+}
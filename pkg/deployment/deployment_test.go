@@ -0,0 +1,49 @@
+package deployment
+
+import (
+	"reflect"
+	"testing"
+
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSelectedLabels(t *testing.T) {
+	labels := map[string]string{"name": "app-a", "app": "app-a", "version": "v1"}
+
+	tests := []struct {
+		name     string
+		selector *meta_v1.LabelSelector
+		want     map[string]string
+		wantErr  bool
+	}{
+		{
+			name:     "extra label added",
+			selector: &meta_v1.LabelSelector{MatchLabels: map[string]string{"app": "app-a", "tier": "web"}},
+			want:     map[string]string{"name": "app-a", "app": "app-a", "version": "v1", "tier": "web"},
+		},
+		{
+			name:     "standard label conflicts",
+			selector: &meta_v1.LabelSelector{MatchLabels: map[string]string{"app": "legacy"}},
+			wantErr:  true,
+		},
+		{
+			name: "match expressions",
+			selector: &meta_v1.LabelSelector{MatchExpressions: []meta_v1.LabelSelectorRequirement{
+				{Key: "tier", Operator: meta_v1.LabelSelectorOpIn, Values: []string{"web"}},
+			}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := selectedLabels(labels, tt.selector)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("selectedLabels() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("selectedLabels() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package deployment
+
+import (
+	"fmt"
+
+	apps_v1 "k8s.io/api/apps/v1"
+	core_v1 "k8s.io/api/core/v1"
+)
+
+// Available check deployment rolled out, all replicas updated and available,
+// return reason and message of condition
+func Available(deployment *apps_v1.Deployment) (bool, string, string) {
+	if deployment.Generation > deployment.Status.ObservedGeneration {
+		return false, "Progressing", "deployment spec not observed yet"
+	}
+
+	for _, c := range deployment.Status.Conditions {
+		if c.Type == apps_v1.DeploymentProgressing && c.Status == core_v1.ConditionFalse {
+			return false, c.Reason, c.Message
+		}
+	}
+
+	replicas := int32(1)
+	if deployment.Spec.Replicas != nil {
+		replicas = *deployment.Spec.Replicas
+	}
+	status := deployment.Status
+
+	switch {
+	case status.UpdatedReplicas < replicas:
+		return false, "Progressing", fmt.Sprintf("%d of %d replicas updated", status.UpdatedReplicas, replicas)
+	case status.Replicas > status.UpdatedReplicas:
+		return false, "Progressing", fmt.Sprintf("%d old replicas pending termination", status.Replicas-status.UpdatedReplicas)
+	case status.AvailableReplicas < replicas:
+		return false, "Unavailable", fmt.Sprintf("%d of %d replicas available", status.AvailableReplicas, replicas)
+	}
+	return true, "Available", fmt.Sprintf("%d replicas available", status.AvailableReplicas)
+}
@@ -0,0 +1,197 @@
+package deployment
+
+import (
+	"sort"
+	"strings"
+
+	core_v1 "k8s.io/api/core/v1"
+
+	opsv1 "github.com/changqings/some-app-operator/api/v1"
+	"github.com/changqings/some-app-operator/pkg/configmap"
+)
+
+// default container to mount volumes, when SomeVolumeMount.Container is empty
+const defaultMountContainer = "app"
+
+// deprecated spec.someVolume mount one file of configmap or secret
+const (
+	someVolumePrefixConfigMap = "configmap-"
+	someVolumePrefixSecret    = "secret-"
+	someVolumeFileName        = "some_config.yaml"
+)
+
+// specVolumes return spec.volumes, with deprecated spec.someVolume mapped to one of them,
+// unless volumes has one of the same name.
+// Names without prefix are configmaps, like before prefixes were added
+func specVolumes(someApp *opsv1.Someapp) []opsv1.SomeVolume {
+	someVolume := someApp.Spec.SomeVolume
+	var v opsv1.SomeVolume
+	switch {
+	case strings.HasPrefix(someVolume, someVolumePrefixSecret):
+		v.Name = strings.TrimPrefix(someVolume, someVolumePrefixSecret)
+		v.Secret = &core_v1.SecretVolumeSource{SecretName: v.Name}
+	default:
+		v.Name = strings.TrimPrefix(someVolume, someVolumePrefixConfigMap)
+		v.ConfigMap = &core_v1.ConfigMapVolumeSource{LocalObjectReference: core_v1.LocalObjectReference{Name: v.Name}}
+	}
+	if len(v.Name) == 0 {
+		return someApp.Spec.Volumes
+	}
+	for _, existing := range someApp.Spec.Volumes {
+		if existing.Name == v.Name {
+			return someApp.Spec.Volumes
+		}
+	}
+
+	v.Mounts = []opsv1.SomeVolumeMount{{
+		Container: defaultMountContainer,
+		MountPath: "/app/" + someVolumeFileName,
+		SubPath:   someVolumeFileName,
+		ReadOnly:  true,
+	}}
+	volumes := make([]opsv1.SomeVolume, 0, len(someApp.Spec.Volumes)+1)
+	return append(append(volumes, someApp.Spec.Volumes...), v)
+}
+
+// podVolumes convert someApp volumes to pod volumes
+func podVolumes(someVolumes []opsv1.SomeVolume) []core_v1.Volume {
+	if len(someVolumes) == 0 {
+		return nil
+	}
+
+	volumes := make([]core_v1.Volume, 0, len(someVolumes))
+	for _, v := range someVolumes {
+		volumes = append(volumes, core_v1.Volume{
+			Name: v.Name,
+			VolumeSource: core_v1.VolumeSource{
+				ConfigMap:             v.ConfigMap,
+				Secret:                v.Secret,
+				EmptyDir:              v.EmptyDir,
+				PersistentVolumeClaim: v.PersistentVolumeClaim,
+				Projected:             v.Projected,
+				DownwardAPI:           v.DownwardAPI,
+			},
+		})
+	}
+	return volumes
+}
+
+// containersWithVolumeMounts return a copy of containers, with someVolume mounts merged
+// into each container volumeMounts, the user declared mount wins on same mountPath
+func containersWithVolumeMounts(containers []core_v1.Container, someVolumes []opsv1.SomeVolume) []core_v1.Container {
+	result := make([]core_v1.Container, 0, len(containers))
+	for _, c := range containers {
+		container := *c.DeepCopy()
+
+		for _, v := range someVolumes {
+			for _, m := range v.Mounts {
+				mountContainer := m.Container
+				if len(mountContainer) == 0 {
+					mountContainer = defaultMountContainer
+				}
+				if mountContainer != container.Name || hasMountPath(container.VolumeMounts, m.MountPath) {
+					continue
+				}
+
+				container.VolumeMounts = append(container.VolumeMounts, core_v1.VolumeMount{
+					Name:      v.Name,
+					ReadOnly:  m.ReadOnly,
+					MountPath: m.MountPath,
+					SubPath:   m.SubPath,
+				})
+			}
+		}
+
+		result = append(result, container)
+	}
+	return result
+}
+
+func hasMountPath(mounts []core_v1.VolumeMount, mountPath string) bool {
+	for _, m := range mounts {
+		if m.MountPath == mountPath {
+			return true
+		}
+	}
+	return false
+}
+
+// addConfigMap mount files of spec.config to container app, and add env to container app
+func addConfigMap(podSpec *core_v1.PodSpec, config *opsv1.SomeConfig, configMapName string) {
+	const (
+		volumeName       = "someapp-config"
+		defaultMountPath = "/app/config"
+	)
+
+	var appContainer *core_v1.Container
+	for i := range podSpec.Containers {
+		if podSpec.Containers[i].Name == defaultMountContainer {
+			appContainer = &podSpec.Containers[i]
+			break
+		}
+	}
+	if appContainer == nil {
+		return
+	}
+
+	if len(config.Files) > 0 {
+		items := make([]core_v1.KeyToPath, 0, len(config.Files))
+		for k := range config.Files {
+			items = append(items, core_v1.KeyToPath{Key: k, Path: k})
+		}
+		sort.Slice(items, func(i, j int) bool { return items[i].Key < items[j].Key })
+
+		podSpec.Volumes = append(podSpec.Volumes, core_v1.Volume{
+			Name: volumeName,
+			VolumeSource: core_v1.VolumeSource{
+				ConfigMap: &core_v1.ConfigMapVolumeSource{
+					LocalObjectReference: core_v1.LocalObjectReference{Name: configMapName},
+					Items:                items,
+				},
+			},
+		})
+
+		mountPath := config.MountPath
+		if len(mountPath) == 0 {
+			mountPath = defaultMountPath
+		}
+		if !hasMountPath(appContainer.VolumeMounts, mountPath) {
+			appContainer.VolumeMounts = append(appContainer.VolumeMounts, core_v1.VolumeMount{
+				Name:      volumeName,
+				ReadOnly:  true,
+				MountPath: mountPath,
+			})
+		}
+	}
+
+	envNames := make([]string, 0, len(config.Env))
+	for k := range config.Env {
+		envNames = append(envNames, k)
+	}
+	sort.Strings(envNames)
+
+	// user declared env first
+	for _, name := range envNames {
+		if hasEnv(appContainer.Env, name) {
+			continue
+		}
+		appContainer.Env = append(appContainer.Env, core_v1.EnvVar{
+			Name: name,
+			ValueFrom: &core_v1.EnvVarSource{
+				ConfigMapKeyRef: &core_v1.ConfigMapKeySelector{
+					LocalObjectReference: core_v1.LocalObjectReference{Name: configMapName},
+					Key:                  configmap.EnvKeyPrefix + name,
+				},
+			},
+		})
+	}
+}
+
+func hasEnv(envs []core_v1.EnvVar, name string) bool {
+	for _, e := range envs {
+		if e.Name == name {
+			return true
+		}
+	}
+	return false
+}
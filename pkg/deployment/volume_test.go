@@ -0,0 +1,60 @@
+package deployment
+
+import (
+	"reflect"
+	"testing"
+
+	core_v1 "k8s.io/api/core/v1"
+
+	opsv1 "github.com/changqings/some-app-operator/api/v1"
+)
+
+func TestSpecVolumes(t *testing.T) {
+	legacyMount := []opsv1.SomeVolumeMount{{Container: "app", MountPath: "/app/some_config.yaml", SubPath: "some_config.yaml", ReadOnly: true}}
+	volume := opsv1.SomeVolume{Name: "data", EmptyDir: &core_v1.EmptyDirVolumeSource{}}
+
+	tests := []struct {
+		name       string
+		someVolume string
+		volumes    []opsv1.SomeVolume
+		want       []opsv1.SomeVolume
+	}{
+		{name: "not set", volumes: []opsv1.SomeVolume{volume}, want: []opsv1.SomeVolume{volume}},
+		{
+			name:       "configmap",
+			someVolume: "configmap-a",
+			volumes:    []opsv1.SomeVolume{volume},
+			want: []opsv1.SomeVolume{volume, {
+				Name:      "a",
+				ConfigMap: &core_v1.ConfigMapVolumeSource{LocalObjectReference: core_v1.LocalObjectReference{Name: "a"}},
+				Mounts:    legacyMount,
+			}},
+		},
+		{
+			name:       "secret",
+			someVolume: "secret-b",
+			want:       []opsv1.SomeVolume{{Name: "b", Secret: &core_v1.SecretVolumeSource{SecretName: "b"}, Mounts: legacyMount}},
+		},
+		{
+			name:       "no prefix as configmap",
+			someVolume: "my-config",
+			want: []opsv1.SomeVolume{{
+				Name:      "my-config",
+				ConfigMap: &core_v1.ConfigMapVolumeSource{LocalObjectReference: core_v1.LocalObjectReference{Name: "my-config"}},
+				Mounts:    legacyMount,
+			}},
+		},
+		{name: "empty name", someVolume: "configmap-"},
+		{name: "empty secret name", someVolume: "secret-"},
+		{name: "same name in volumes", someVolume: "secret-data", volumes: []opsv1.SomeVolume{volume}, want: []opsv1.SomeVolume{volume}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			someApp := &opsv1.Someapp{Spec: opsv1.SomeappSpec{SomeVolume: tt.someVolume, Volumes: tt.volumes}}
+			if got := specVolumes(someApp); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("specVolumes() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
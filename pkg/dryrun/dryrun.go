@@ -0,0 +1,230 @@
+package dryrun
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+// depth of fields in plan, like spec.template.spec.containers
+const diffDepth = 4
+
+// Change planned of a child resource, create, update or delete
+type Change struct {
+	Action string
+	Kind   string
+	Name   string
+	Fields []string
+}
+
+func (c Change) String() string {
+	if len(c.Fields) == 0 {
+		return fmt.Sprintf("%s %s/%s", c.Action, c.Kind, c.Name)
+	}
+	return fmt.Sprintf("%s %s/%s: %s", c.Action, c.Kind, c.Name, strings.Join(c.Fields, ", "))
+}
+
+// Plan changes of child resources in one reconcile
+type Plan []Change
+
+// add change, merged with former change of the same resource
+func (p *Plan) add(action, kind, name string, fields []string) {
+	for i := range *p {
+		c := &(*p)[i]
+		if c.Kind != kind || c.Name != name {
+			continue
+		}
+		// created or deleted one keeps its action
+		if c.Action == "update" {
+			c.Action = action
+		}
+		c.Fields = sets.List(sets.New(c.Fields...).Insert(fields...))
+		return
+	}
+	*p = append(*p, Change{Action: action, Kind: kind, Name: name, Fields: fields})
+}
+
+// Strings of changes, for status
+func (p Plan) Strings() []string {
+	changes := make([]string, 0, len(p))
+	for _, c := range p {
+		changes = append(changes, c.String())
+	}
+	return changes
+}
+
+func (p Plan) String() string {
+	return strings.Join(p.Strings(), "; ")
+}
+
+// Client server-side dry-run writes of child resources, nothing changed,
+// changes compared with live objects are recorded to Plan.
+// Reads and status writes are passed to the wrapped client.
+type Client struct {
+	client.Client
+	Plan Plan
+}
+
+// New wrap c for dry-run
+func New(c client.Client) *Client {
+	return &Client{Client: c}
+}
+
+// Enabled check c is a dry-run client, writes by it change nothing
+func Enabled(c client.Client) bool {
+	_, dryRun := c.(*Client)
+	return dryRun
+}
+
+func (c *Client) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	if err := c.Client.Create(ctx, obj, append(opts, client.DryRunAll)...); err != nil {
+		return err
+	}
+	c.Plan.add("create", c.kind(obj), obj.GetName(), nil)
+	return nil
+}
+
+func (c *Client) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	live, err := c.live(ctx, obj)
+	if err != nil {
+		return err
+	}
+	if err := c.Client.Update(ctx, obj, append(opts, client.DryRunAll)...); err != nil {
+		return err
+	}
+	return c.diff(live, obj)
+}
+
+func (c *Client) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	live, err := c.live(ctx, obj)
+	if err != nil {
+		return err
+	}
+	if err := c.Client.Patch(ctx, obj, patch, append(opts, client.DryRunAll)...); err != nil {
+		return err
+	}
+	return c.diff(live, obj)
+}
+
+func (c *Client) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	if err := c.Client.Delete(ctx, obj, append(opts, client.DryRunAll)...); err != nil {
+		return err
+	}
+	c.Plan.add("delete", c.kind(obj), obj.GetName(), nil)
+	return nil
+}
+
+func (c *Client) DeleteAllOf(ctx context.Context, obj client.Object, opts ...client.DeleteAllOfOption) error {
+	if err := c.Client.DeleteAllOf(ctx, obj, append(opts, client.DryRunAll)...); err != nil {
+		return err
+	}
+	c.Plan.add("delete", c.kind(obj), "*", nil)
+	return nil
+}
+
+func (c *Client) kind(obj client.Object) string {
+	gvk, err := apiutil.GVKForObject(obj, c.Scheme())
+	if err != nil {
+		return fmt.Sprintf("%T", obj)
+	}
+	return gvk.Kind
+}
+
+// live get a copy of obj from cluster, nil if not found
+func (c *Client) live(ctx context.Context, obj client.Object) (client.Object, error) {
+	gvk, err := apiutil.GVKForObject(obj, c.Scheme())
+	if err != nil {
+		return nil, err
+	}
+
+	var live client.Object
+	if _, ok := obj.(runtime.Unstructured); ok {
+		u := &unstructured.Unstructured{}
+		u.SetGroupVersionKind(gvk)
+		live = u
+	} else {
+		newObj, err := c.Scheme().New(gvk)
+		if err != nil {
+			return nil, err
+		}
+		if live, ok = newObj.(client.Object); !ok {
+			return nil, fmt.Errorf("%s is not a client.Object", gvk)
+		}
+	}
+
+	if err := c.Get(ctx, client.ObjectKeyFromObject(obj), live); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return live, nil
+}
+
+// diff record fields changed from live to obj returned by dry-run
+func (c *Client) diff(live, obj client.Object) error {
+	if live == nil {
+		c.Plan.add("create", c.kind(obj), obj.GetName(), nil)
+		return nil
+	}
+
+	liveFields, err := comparableFields(live)
+	if err != nil {
+		return err
+	}
+	objFields, err := comparableFields(obj)
+	if err != nil {
+		return err
+	}
+	if fields := diffFields("", liveFields, objFields, diffDepth); len(fields) > 0 {
+		c.Plan.add("update", c.kind(obj), obj.GetName(), fields)
+	}
+	return nil
+}
+
+// comparableFields fields of obj, without status and metadata set by api server,
+// type meta not compared, set or not by client for typed and unstructured objects
+func comparableFields(obj client.Object) (map[string]interface{}, error) {
+	u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+	for _, field := range []string{"apiVersion", "kind", "status"} {
+		delete(u, field)
+	}
+	for _, field := range []string{"creationTimestamp", "generation", "managedFields", "resourceVersion", "uid", "selfLink"} {
+		unstructured.RemoveNestedField(u, "metadata", field)
+	}
+	return u, nil
+}
+
+// diffFields return paths of changed fields, nested maps compared until depth
+func diffFields(prefix string, live, obj map[string]interface{}, depth int) []string {
+	var fields []string
+	for _, key := range sets.List(sets.KeySet(live).Union(sets.KeySet(obj))) {
+		path := key
+		if len(prefix) > 0 {
+			path = prefix + "." + key
+		}
+		if equality.Semantic.DeepEqual(live[key], obj[key]) {
+			continue
+		}
+
+		liveMap, liveOk := live[key].(map[string]interface{})
+		objMap, objOk := obj[key].(map[string]interface{})
+		if liveOk && objOk && depth > 1 {
+			fields = append(fields, diffFields(path, liveMap, objMap, depth-1)...)
+			continue
+		}
+		fields = append(fields, path)
+	}
+	return fields
+}
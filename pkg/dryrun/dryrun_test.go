@@ -0,0 +1,92 @@
+package dryrun
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestPlan(t *testing.T) {
+	configMap := func(name string, data map[string]string) *core_v1.ConfigMap {
+		return &core_v1.ConfigMap{ObjectMeta: meta_v1.ObjectMeta{Name: name, Namespace: "default"}, Data: data}
+	}
+
+	tests := []struct {
+		name  string
+		write func(ctx context.Context, c client.Client) error
+		want  []string
+	}{
+		{
+			name:  "create",
+			write: func(ctx context.Context, c client.Client) error { return c.Create(ctx, configMap("b", nil)) },
+			want:  []string{"create ConfigMap/b"},
+		},
+		{
+			name: "update data",
+			write: func(ctx context.Context, c client.Client) error {
+				return c.Update(ctx, configMap("a", map[string]string{"k": "2"}))
+			},
+			want: []string{"update ConfigMap/a: data.k"},
+		},
+		{
+			name: "patch labels",
+			write: func(ctx context.Context, c client.Client) error {
+				cm := configMap("a", map[string]string{"k": "1"})
+				patch := client.MergeFrom(cm.DeepCopy())
+				cm.Labels = map[string]string{"app": "a"}
+				return c.Patch(ctx, cm, patch)
+			},
+			want: []string{"update ConfigMap/a: metadata.labels"},
+		},
+		{
+			name: "patch not changed",
+			write: func(ctx context.Context, c client.Client) error {
+				cm := configMap("a", map[string]string{"k": "1"})
+				return c.Patch(ctx, cm, client.MergeFrom(cm.DeepCopy()))
+			},
+			want: []string{},
+		},
+		{
+			name:  "delete",
+			write: func(ctx context.Context, c client.Client) error { return c.Delete(ctx, configMap("a", nil)) },
+			want:  []string{"delete ConfigMap/a"},
+		},
+		{
+			name: "writes of one resource merged",
+			write: func(ctx context.Context, c client.Client) error {
+				if err := c.Update(ctx, configMap("a", map[string]string{"k": "2"})); err != nil {
+					return err
+				}
+				return c.Update(ctx, configMap("a", map[string]string{"k": "1", "l": "1"}))
+			},
+			want: []string{"update ConfigMap/a: data.k, data.l"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			live := configMap("a", map[string]string{"k": "1"})
+			c := New(fake.NewClientBuilder().WithObjects(live).Build())
+			if err := tt.write(context.Background(), c); err != nil {
+				t.Fatalf("write error = %v", err)
+			}
+			if got := c.Plan.Strings(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("plan = %q, want %q", got, tt.want)
+			}
+
+			// nothing changed in cluster
+			got := &core_v1.ConfigMapList{}
+			if err := c.List(context.Background(), got); err != nil {
+				t.Fatal(err)
+			}
+			if len(got.Items) != 1 || !reflect.DeepEqual(got.Items[0].Data, live.Data) {
+				t.Errorf("configmaps = %v, want only %v", got.Items, live)
+			}
+		})
+	}
+}
@@ -9,53 +9,58 @@ import (
 	core_v1 "k8s.io/api/core/v1"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	k8s_utils_pointer "k8s.io/utils/pointer"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 
 	opsv1 "github.com/changqings/some-app-operator/api/v1"
+	"github.com/changqings/some-app-operator/pkg/apply"
+	"github.com/changqings/some-app-operator/pkg/dryrun"
+	"github.com/changqings/some-app-operator/pkg/metrics"
+	"github.com/changqings/some-app-operator/pkg/owner"
 	"github.com/go-logr/logr"
 )
 
 type SomeHpa struct {
 	StandardLabels map[string]string
+	// drifts found when apply, nil to ignore
+	Drifts *apply.Drifts
+	// existing hpa not owned by someapp, adopted or not, nil to not check
+	Adoption *owner.Adoption
+}
+
+// MinMax parse spec.setHpa like 1->3, return min and max replicas
+func MinMax(setHpa string) (int32, int32) {
+	var hpaMin, hpaMax int64
+
+	hpaMinMaxs := strings.Split(setHpa, "->")
+	if len(hpaMinMaxs) == 2 {
+		hpaMin, _ = strconv.ParseInt(hpaMinMaxs[0], 10, 32)
+		hpaMax, _ = strconv.ParseInt(hpaMinMaxs[1], 10, 32)
+		if hpaMin > hpaMax {
+			tmpNum := hpaMax
+			hpaMax = hpaMin
+			hpaMin = tmpNum
+		}
+	}
+	return int32(hpaMin), int32(hpaMax)
 }
 
 func (sh *SomeHpa) Reconcile(ctx context.Context, someApp *opsv1.Someapp, client client.Client, scheme *runtime.Scheme, log logr.Logger) error {
 
-	var (
-		someHpaNums    = someApp.Spec.SetHpa
-		hpaMin, hpaMax int64
-	)
+	hpaMin, hpaMax := MinMax(someApp.Spec.SetHpa)
 
 	// reconcile hpa
 	hpa := &autoscalingv2.HorizontalPodAutoscaler{
 		ObjectMeta: meta_v1.ObjectMeta{
 			Name:      sh.StandardLabels["name"],
 			Namespace: someApp.Namespace,
+			Labels:    sh.StandardLabels,
 		},
-	}
-
-	op, err := controllerutil.CreateOrUpdate(ctx, client, hpa, func() error {
-		// check  if existed, if not do something
-		if hpa.ObjectMeta.CreationTimestamp.IsZero() {
-			hpa.ObjectMeta.Labels = sh.StandardLabels
-		}
-
-		hpaMinMaxs := strings.Split(someHpaNums, "->")
-		if len(hpaMinMaxs) == 2 {
-			hpaMin, _ = strconv.ParseInt(hpaMinMaxs[0], 10, 64)
-			hpaMax, _ = strconv.ParseInt(hpaMinMaxs[1], 10, 64)
-			if hpaMin > hpaMax {
-				tmpNum := hpaMax
-				hpaMax = hpaMin
-				hpaMin = tmpNum
-			}
-		}
-
-		hpa.Spec = autoscalingv2.HorizontalPodAutoscalerSpec{
-			MinReplicas: k8s_utils_pointer.Int32(int32(hpaMin)),
-			MaxReplicas: int32(hpaMax),
+		Spec: autoscalingv2.HorizontalPodAutoscalerSpec{
+			MinReplicas: k8s_utils_pointer.Int32(hpaMin),
+			MaxReplicas: hpaMax,
 			ScaleTargetRef: autoscalingv2.CrossVersionObjectReference{
 				APIVersion: "apps/v1",
 				Kind:       "Deployment",
@@ -73,19 +78,30 @@ func (sh *SomeHpa) Reconcile(ctx context.Context, someApp *opsv1.Someapp, client
 					},
 				},
 			},
-		}
+		},
+	}
 
-		// add reference
-		if err := controllerutil.SetOwnerReference(someApp, hpa, scheme); err != nil {
-			return err
-		}
+	_, adopted, err := sh.Adoption.Get(ctx, client, types.NamespacedName{Namespace: hpa.Namespace, Name: hpa.Name}, &autoscalingv2.HorizontalPodAutoscaler{})
+	if err != nil {
+		return err
+	}
 
-		return nil
-	})
+	// add reference
+	if err := controllerutil.SetOwnerReference(someApp, hpa, scheme); err != nil {
+		return err
+	}
+
+	// fields of adopted one set by others are taken over, not drift
+	drifts := sh.Drifts
+	if adopted {
+		drifts = nil
+	}
+	op, err := apply.Apply(ctx, client, hpa, drifts)
 	if err != nil {
 		return err
 	}
 
+	metrics.ObserveOperation(dryrun.Enabled(client), "HorizontalPodAutoscaler", op)
 	log.Info("hpa reconcile success", "operation_result", op)
 	return nil
 
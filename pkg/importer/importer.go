@@ -0,0 +1,260 @@
+package importer
+
+import (
+	"fmt"
+	"sort"
+
+	istio_network_v1beta1 "istio.io/client-go/pkg/apis/networking/v1beta1"
+	apps_v1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	opsv1 "github.com/changqings/some-app-operator/api/v1"
+	"github.com/changqings/some-app-operator/pkg/owner"
+)
+
+// Objects of one namespace, listed from cluster or read from files
+type Objects struct {
+	Deployments      []*apps_v1.Deployment
+	Services         []*core_v1.Service
+	Hpas             []*autoscalingv2.HorizontalPodAutoscaler
+	VirtualServices  []*istio_network_v1beta1.VirtualService
+	DestinationRules []*istio_network_v1beta1.DestinationRule
+}
+
+// Workload is a deployment with the service selecting its pods,
+// the hpa scaling it, and vs, dr routing to the service
+type Workload struct {
+	Deployment      *apps_v1.Deployment
+	Service         *core_v1.Service
+	Hpa             *autoscalingv2.HorizontalPodAutoscaler
+	VirtualService  *istio_network_v1beta1.VirtualService
+	DestinationRule *istio_network_v1beta1.DestinationRule
+
+	// objects found but not imported, like a second service of the same pods
+	Notes []string
+}
+
+// Group find workloads of deployments not managed by someapp, sorted by namespace and name.
+// A service is grouped with the deployment of the same name first, then the first deployment it selects.
+func Group(objs Objects) []*Workload {
+	deployments := make([]*apps_v1.Deployment, 0, len(objs.Deployments))
+	for _, d := range objs.Deployments {
+		if !owner.IsManaged(d) {
+			deployments = append(deployments, d)
+		}
+	}
+	sort.Slice(deployments, func(i, j int) bool {
+		return key(deployments[i]) < key(deployments[j])
+	})
+
+	// services reserved by deployments of the same name
+	reserved := map[string]bool{}
+	for _, d := range deployments {
+		for _, s := range objs.Services {
+			if s.Name == d.Name && s.Namespace == d.Namespace && selects(s, d) {
+				reserved[key(s)] = true
+			}
+		}
+	}
+
+	used := map[string]string{}
+	var workloads []*Workload
+	for _, d := range deployments {
+		w := &Workload{Deployment: d}
+
+		var candidates []*core_v1.Service
+		for _, s := range objs.Services {
+			if s.Namespace == d.Namespace && selects(s, d) {
+				candidates = append(candidates, s)
+			}
+		}
+		sort.Slice(candidates, func(i, j int) bool {
+			return candidates[i].Name < candidates[j].Name
+		})
+		for _, s := range candidates {
+			if s.Name == d.Name {
+				w.Service = s
+			}
+		}
+		for _, s := range candidates {
+			if w.Service != nil || reserved[key(s)] || len(used[key(s)]) > 0 {
+				continue
+			}
+			w.Service = s
+		}
+		for _, s := range candidates {
+			switch {
+			case s == w.Service:
+				used[key(s)] = d.Name
+			case len(used[key(s)]) > 0:
+				w.Notes = append(w.Notes, fmt.Sprintf("service %s also selects pods, imported with deployment %s", s.Name, used[key(s)]))
+			default:
+				w.Notes = append(w.Notes, fmt.Sprintf("service %s also selects pods, not imported", s.Name))
+			}
+		}
+
+		for _, h := range objs.Hpas {
+			ref := h.Spec.ScaleTargetRef
+			if h.Namespace != d.Namespace || ref.Kind != "Deployment" || ref.Name != d.Name {
+				continue
+			}
+			if w.Hpa == nil {
+				w.Hpa = h
+			} else {
+				w.Notes = append(w.Notes, fmt.Sprintf("hpa %s also scales deployment, not imported", h.Name))
+			}
+		}
+
+		if w.Service != nil {
+			hosts := serviceHosts(w.Service)
+			w.VirtualService = pickVirtualService(w, objs.VirtualServices, hosts)
+			w.DestinationRule = pickDestinationRule(w, objs.DestinationRules, hosts)
+		}
+
+		workloads = append(workloads, w)
+	}
+	return workloads
+}
+
+// pickVirtualService return vs routing to hosts of service, the one of service name first
+func pickVirtualService(w *Workload, vss []*istio_network_v1beta1.VirtualService, hosts map[string]bool) *istio_network_v1beta1.VirtualService {
+	var picked *istio_network_v1beta1.VirtualService
+	for _, vs := range vss {
+		if vs.Namespace != w.Service.Namespace || !routesTo(vs, hosts) {
+			continue
+		}
+		switch {
+		case picked == nil:
+			picked = vs
+		case vs.Name == w.Service.Name:
+			w.Notes = append(w.Notes, fmt.Sprintf("virtualservice %s also routes to service, not imported", picked.Name))
+			picked = vs
+		default:
+			w.Notes = append(w.Notes, fmt.Sprintf("virtualservice %s also routes to service, not imported", vs.Name))
+		}
+	}
+	return picked
+}
+
+// pickDestinationRule return dr of hosts of service, the one of service name first
+func pickDestinationRule(w *Workload, drs []*istio_network_v1beta1.DestinationRule, hosts map[string]bool) *istio_network_v1beta1.DestinationRule {
+	var picked *istio_network_v1beta1.DestinationRule
+	for _, dr := range drs {
+		if dr.Namespace != w.Service.Namespace || !hosts[dr.Spec.Host] {
+			continue
+		}
+		switch {
+		case picked == nil:
+			picked = dr
+		case dr.Name == w.Service.Name:
+			w.Notes = append(w.Notes, fmt.Sprintf("destinationrule %s also for service, not imported", picked.Name))
+			picked = dr
+		default:
+			w.Notes = append(w.Notes, fmt.Sprintf("destinationrule %s also for service, not imported", dr.Name))
+		}
+	}
+	return picked
+}
+
+// selects check service selector match pod labels of deployment, empty selector select nothing
+func selects(s *core_v1.Service, d *apps_v1.Deployment) bool {
+	if len(s.Spec.Selector) == 0 {
+		return false
+	}
+	return labels.SelectorFromSet(s.Spec.Selector).Matches(labels.Set(d.Spec.Template.Labels))
+}
+
+// serviceHosts return short and full names of service used as istio hosts
+func serviceHosts(s *core_v1.Service) map[string]bool {
+	return map[string]bool{
+		s.Name:                              true,
+		s.Name + "." + s.Namespace:          true,
+		s.Name + "." + s.Namespace + ".svc": true,
+		s.Name + "." + s.Namespace + ".svc.cluster.local": true,
+	}
+}
+
+// routesTo check any destination of vs is one of hosts
+func routesTo(vs *istio_network_v1beta1.VirtualService, hosts map[string]bool) bool {
+	for _, r := range vs.Spec.Http {
+		for _, d := range r.Route {
+			if d.Destination != nil && hosts[d.Destination.Host] {
+				return true
+			}
+		}
+	}
+	for _, r := range vs.Spec.Tcp {
+		for _, d := range r.Route {
+			if d.Destination != nil && hosts[d.Destination.Host] {
+				return true
+			}
+		}
+	}
+	for _, r := range vs.Spec.Tls {
+		for _, d := range r.Route {
+			if d.Destination != nil && hosts[d.Destination.Host] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func key(obj meta_v1.Object) string {
+	return obj.GetNamespace() + "/" + obj.GetName()
+}
+
+// Convert return someapp of workload, with adopt annotation so existing children are taken over,
+// and notes of fields someapp can not represent, which are dropped
+func Convert(w *Workload) (*opsv1.Someapp, []string) {
+	d := w.Deployment
+	someApp := &opsv1.Someapp{
+		TypeMeta: meta_v1.TypeMeta{
+			APIVersion: opsv1.GroupVersion.String(),
+			Kind:       "Someapp",
+		},
+		ObjectMeta: meta_v1.ObjectMeta{
+			Name:        d.Name,
+			Namespace:   d.Namespace,
+			Annotations: map[string]string{opsv1.AdoptAnnotation: "true"},
+		},
+		Spec: opsv1.SomeappSpec{
+			AppName:    d.Name,
+			AppType:    opsv1.AppTypeApi,
+			AppVersion: opsv1.StableStage,
+		},
+	}
+
+	c := &converter{someApp: someApp, notes: append([]string{}, w.Notes...)}
+	c.deployment(d)
+	if w.Service != nil {
+		c.service(w.Service)
+	} else {
+		c.notef("no service selects pods, service %s will be created", d.Name)
+	}
+	if w.Hpa != nil {
+		c.hpa(w.Hpa)
+	}
+	if w.VirtualService != nil || w.DestinationRule != nil {
+		someApp.Spec.EnableIstio = true
+	}
+	if w.VirtualService != nil {
+		c.virtualService(w.VirtualService, w.Service)
+	}
+	if w.DestinationRule != nil {
+		c.destinationRule(w.DestinationRule)
+	}
+	return someApp, c.notes
+}
+
+type converter struct {
+	someApp *opsv1.Someapp
+	notes   []string
+}
+
+func (c *converter) notef(format string, args ...interface{}) {
+	c.notes = append(c.notes, fmt.Sprintf(format, args...))
+}
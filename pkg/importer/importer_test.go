@@ -0,0 +1,212 @@
+package importer
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	istio_api_network_v1beta1 "istio.io/api/networking/v1beta1"
+	istio_network_v1beta1 "istio.io/client-go/pkg/apis/networking/v1beta1"
+	apps_v1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/pointer"
+
+	opsv1 "github.com/changqings/some-app-operator/api/v1"
+	"github.com/changqings/some-app-operator/pkg/istio"
+	"github.com/changqings/some-app-operator/pkg/render"
+)
+
+func testDeployment(name string, podLabels map[string]string) *apps_v1.Deployment {
+	return &apps_v1.Deployment{
+		ObjectMeta: meta_v1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: apps_v1.DeploymentSpec{
+			Selector: &meta_v1.LabelSelector{MatchLabels: podLabels},
+			Template: core_v1.PodTemplateSpec{
+				ObjectMeta: meta_v1.ObjectMeta{Labels: podLabels},
+				Spec: core_v1.PodSpec{Containers: []core_v1.Container{{
+					Name:  "app",
+					Image: "nginx",
+					Ports: []core_v1.ContainerPort{{Name: "http", ContainerPort: 8080}},
+				}}},
+			},
+		},
+	}
+}
+
+func testService(name string, selector map[string]string, ports ...core_v1.ServicePort) *core_v1.Service {
+	return &core_v1.Service{
+		ObjectMeta: meta_v1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec:       core_v1.ServiceSpec{Selector: selector, Ports: ports, Type: core_v1.ServiceTypeClusterIP},
+	}
+}
+
+func TestGroup(t *testing.T) {
+	labelsA := map[string]string{"app": "a"}
+	managed := testDeployment("managed", map[string]string{"app": "managed"})
+	managed.OwnerReferences = []meta_v1.OwnerReference{{APIVersion: opsv1.GroupVersion.String(), Kind: "Someapp", Name: "managed"}}
+	hpa := func(name, target string) *autoscalingv2.HorizontalPodAutoscaler {
+		return &autoscalingv2.HorizontalPodAutoscaler{
+			ObjectMeta: meta_v1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec:       autoscalingv2.HorizontalPodAutoscalerSpec{ScaleTargetRef: autoscalingv2.CrossVersionObjectReference{Kind: "Deployment", Name: target}},
+		}
+	}
+	vs := func(name, host string) *istio_network_v1beta1.VirtualService {
+		return &istio_network_v1beta1.VirtualService{
+			ObjectMeta: meta_v1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec: istio_api_network_v1beta1.VirtualService{Http: []*istio_api_network_v1beta1.HTTPRoute{{
+				Route: []*istio_api_network_v1beta1.HTTPRouteDestination{{Destination: &istio_api_network_v1beta1.Destination{Host: host}}},
+			}}},
+		}
+	}
+
+	tests := []struct {
+		name string
+		objs Objects
+		// deployment/service/hpa/vs of each workload, and its notes
+		want      []string
+		wantNotes []string
+	}{
+		{
+			name: "service of same name first",
+			objs: Objects{
+				Deployments: []*apps_v1.Deployment{testDeployment("a", labelsA)},
+				Services:    []*core_v1.Service{testService("a-alias", labelsA), testService("a", labelsA)},
+			},
+			want:      []string{"a/a//"},
+			wantNotes: []string{"service a-alias also selects pods, not imported"},
+		},
+		{
+			name: "managed deployment skipped",
+			objs: Objects{Deployments: []*apps_v1.Deployment{managed, testDeployment("a", labelsA)}},
+			want: []string{"a///"},
+		},
+		{
+			name: "hpa and vs of workload",
+			objs: Objects{
+				Deployments:     []*apps_v1.Deployment{testDeployment("a", labelsA), testDeployment("b", map[string]string{"app": "b"})},
+				Services:        []*core_v1.Service{testService("a", labelsA)},
+				Hpas:            []*autoscalingv2.HorizontalPodAutoscaler{hpa("b", "b"), hpa("a", "a"), hpa("a-2", "a")},
+				VirtualServices: []*istio_network_v1beta1.VirtualService{vs("a-vs", "a.default.svc.cluster.local"), vs("other", "other")},
+			},
+			want:      []string{"a/a/a/a-vs", "b//b/"},
+			wantNotes: []string{"hpa a-2 also scales deployment, not imported"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got, notes []string
+			for _, w := range Group(tt.objs) {
+				names := []string{w.Deployment.Name, "", "", ""}
+				if w.Service != nil {
+					names[1] = w.Service.Name
+				}
+				if w.Hpa != nil {
+					names[2] = w.Hpa.Name
+				}
+				if w.VirtualService != nil {
+					names[3] = w.VirtualService.Name
+				}
+				got = append(got, strings.Join(names, "/"))
+				notes = append(notes, w.Notes...)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Group() = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(notes, tt.wantNotes) {
+				t.Errorf("notes = %q, want %q", notes, tt.wantNotes)
+			}
+		})
+	}
+}
+
+func TestConvert(t *testing.T) {
+	labelsA := map[string]string{"app": "a"}
+
+	tests := []struct {
+		name      string
+		workload  func() *Workload
+		check     func(t *testing.T, someApp *opsv1.Someapp)
+		wantNotes []string
+	}{
+		{
+			name: "deployment only",
+			workload: func() *Workload {
+				return &Workload{Deployment: testDeployment("a", labelsA)}
+			},
+			check: func(t *testing.T, someApp *opsv1.Someapp) {
+				if someApp.Annotations[opsv1.AdoptAnnotation] != "true" || someApp.Spec.AppName != "a" || len(someApp.Spec.Containers) != 1 {
+					t.Errorf("someapp = %+v", someApp)
+				}
+			},
+			wantNotes: []string{"no service selects pods, service a will be created"},
+		},
+		{
+			name: "unsupported volume dropped with mount",
+			workload: func() *Workload {
+				d := testDeployment("a", labelsA)
+				d.Spec.Template.Spec.Volumes = []core_v1.Volume{
+					{Name: "host", VolumeSource: core_v1.VolumeSource{HostPath: &core_v1.HostPathVolumeSource{Path: "/data"}}},
+					{Name: "tmp", VolumeSource: core_v1.VolumeSource{EmptyDir: &core_v1.EmptyDirVolumeSource{}}},
+				}
+				d.Spec.Template.Spec.Containers[0].VolumeMounts = []core_v1.VolumeMount{{Name: "host", MountPath: "/data"}, {Name: "tmp", MountPath: "/tmp"}}
+				return &Workload{Deployment: d, Service: testService("a", labelsA, core_v1.ServicePort{Name: "http", Port: 80})}
+			},
+			check: func(t *testing.T, someApp *opsv1.Someapp) {
+				if len(someApp.Spec.Volumes) != 1 || someApp.Spec.Volumes[0].Name != "tmp" {
+					t.Errorf("volumes = %+v, want tmp", someApp.Spec.Volumes)
+				}
+				if mounts := someApp.Spec.Containers[0].VolumeMounts; len(mounts) != 1 || mounts[0].Name != "tmp" {
+					t.Errorf("mounts = %+v, want tmp", mounts)
+				}
+			},
+			wantNotes: []string{"volume host: source not supported, dropped with its mounts"},
+		},
+		{
+			name: "service ports and hpa",
+			workload: func() *Workload {
+				return &Workload{
+					Deployment: testDeployment("a", labelsA),
+					Service:    testService("a", labelsA, core_v1.ServicePort{Port: 80, Protocol: core_v1.ProtocolTCP}),
+					Hpa: &autoscalingv2.HorizontalPodAutoscaler{
+						ObjectMeta: meta_v1.ObjectMeta{Name: "a", Namespace: "default"},
+						Spec: autoscalingv2.HorizontalPodAutoscalerSpec{MinReplicas: pointer.Int32(2), MaxReplicas: 5, Metrics: []autoscalingv2.MetricSpec{{
+							Type: autoscalingv2.ResourceMetricSourceType,
+							Resource: &autoscalingv2.ResourceMetricSource{Name: core_v1.ResourceCPU, Target: autoscalingv2.MetricTarget{
+								Type: autoscalingv2.UtilizationMetricType, AverageUtilization: pointer.Int32(60),
+							}},
+						}}},
+					},
+				}
+			},
+			check: func(t *testing.T, someApp *opsv1.Someapp) {
+				want := []opsv1.SomeServicePort{{Name: "port-80", Port: 80}}
+				if someApp.Spec.Service == nil || !reflect.DeepEqual(someApp.Spec.Service.Ports, want) {
+					t.Errorf("service = %+v, want ports %+v", someApp.Spec.Service, want)
+				}
+				if someApp.Spec.SetHpa != "2->5" || someApp.Spec.HpaCpuUsage != 60 {
+					t.Errorf("hpa = %s %d, want 2->5 60", someApp.Spec.SetHpa, someApp.Spec.HpaCpuUsage)
+				}
+			},
+			wantNotes: []string{"service a: unnamed port 80 named port-80"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			someApp, notes := Convert(tt.workload())
+			tt.check(t, someApp)
+			if !reflect.DeepEqual(notes, tt.wantNotes) {
+				t.Errorf("notes = %q, want %q", notes, tt.wantNotes)
+			}
+
+			// imported someapps render, like applied to the operator
+			if _, err := render.Render(context.Background(), []*opsv1.Someapp{someApp}, "v1", istio.DefaultTrustDomain); err != nil {
+				t.Errorf("Render() of imported someapp error = %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,274 @@
+package importer
+
+import (
+	"strings"
+
+	"google.golang.org/protobuf/types/known/durationpb"
+	istio_api_network_v1beta1 "istio.io/api/networking/v1beta1"
+	istio_network_v1beta1 "istio.io/client-go/pkg/apis/networking/v1beta1"
+	core_v1 "k8s.io/api/core/v1"
+
+	opsv1 "github.com/changqings/some-app-operator/api/v1"
+)
+
+// istio gateway of sidecars, not an ingress gateway
+const meshGateway = "mesh"
+
+func (c *converter) istio() *opsv1.SomeIstioConfig {
+	if c.someApp.Spec.Istio == nil {
+		c.someApp.Spec.Istio = &opsv1.SomeIstioConfig{}
+	}
+	return c.someApp.Spec.Istio
+}
+
+// virtualService import policy of the route to service, and gateway hosts as expose
+func (c *converter) virtualService(vs *istio_network_v1beta1.VirtualService, s *core_v1.Service) {
+	spec := &vs.Spec
+	if vs.Name != c.someApp.Spec.AppName {
+		c.notef("virtualservice %s: name differs from app, virtualservice %s will be created for the same hosts, delete %s after migrated", vs.Name, c.someApp.Spec.AppName, vs.Name)
+	}
+	if len(spec.ExportTo) > 0 {
+		c.notef("virtualservice %s: exportTo not supported, dropped", vs.Name)
+	}
+	if len(spec.Tls) > 0 {
+		c.notef("virtualservice %s: tls routes not supported, dropped", vs.Name)
+	}
+
+	hosts := serviceHosts(s)
+	var route *istio_api_network_v1beta1.HTTPRoute
+	for _, r := range spec.Http {
+		if route == nil && httpRoutesTo(r, hosts) {
+			route = r
+			continue
+		}
+		c.notef("virtualservice %s: http route %s not supported, only one route to service, dropped", vs.Name, r.Name)
+	}
+
+	var paths []string
+	if route != nil {
+		if len(route.Route) > 1 {
+			c.notef("virtualservice %s: traffic split of route %s not supported, import canary as a canary someapp by hand", vs.Name, route.Name)
+		}
+		for _, m := range route.Match {
+			prefix, ok := m.GetUri().GetMatchType().(*istio_api_network_v1beta1.StringMatch_Prefix)
+			if ok && len(m.Headers) == 0 && len(m.QueryParams) == 0 && m.Method == nil && m.Authority == nil && m.Port == 0 {
+				paths = append(paths, prefix.Prefix)
+				continue
+			}
+			c.notef("virtualservice %s: match %s of route %s not supported, only uri prefix, dropped", vs.Name, m.Name, route.Name)
+		}
+
+		unsupported := []struct {
+			field string
+			set   bool
+		}{
+			{"rewrite", route.Rewrite != nil},
+			{"redirect", route.Redirect != nil},
+			{"directResponse", route.DirectResponse != nil},
+			{"mirror", route.Mirror != nil || len(route.Mirrors) > 0},
+			{"corsPolicy", route.CorsPolicy != nil},
+			{"headers", route.Headers != nil},
+		}
+		for _, u := range unsupported {
+			if u.set {
+				c.notef("virtualservice %s: %s of route %s not supported, dropped", vs.Name, u.field, route.Name)
+			}
+		}
+
+		http := &opsv1.SomeIstioHttp{Timeout: duration(route.Timeout)}
+		if r := route.Retries; r != nil {
+			http.Retries = &opsv1.SomeIstioRetries{
+				Attempts:      r.Attempts,
+				PerTryTimeout: duration(r.PerTryTimeout),
+				RetryOn:       r.RetryOn,
+			}
+		}
+		if f := route.Fault; f != nil {
+			http.Fault = &opsv1.SomeIstioFault{}
+			if d := f.Delay; d != nil && d.GetFixedDelay() != nil {
+				http.Fault.Delay = &opsv1.SomeIstioFaultDelay{
+					Percent:    int32(d.GetPercentage().GetValue()),
+					FixedDelay: duration(d.GetFixedDelay()),
+				}
+			} else if d != nil {
+				c.notef("virtualservice %s: fault delay of route %s not supported, only fixedDelay, dropped", vs.Name, route.Name)
+			}
+			if a := f.Abort; a != nil && a.GetHttpStatus() != 0 {
+				http.Fault.Abort = &opsv1.SomeIstioFaultAbort{
+					Percent:    int32(a.GetPercentage().GetValue()),
+					HttpStatus: a.GetHttpStatus(),
+				}
+			} else if a != nil {
+				c.notef("virtualservice %s: fault abort of route %s not supported, only httpStatus, dropped", vs.Name, route.Name)
+			}
+		}
+		if len(http.Timeout) > 0 || http.Retries != nil || http.Fault != nil {
+			c.istio().Http = http
+		}
+	}
+
+	var gateways, external []string
+	for _, g := range spec.Gateways {
+		if g != meshGateway {
+			gateways = append(gateways, g)
+		}
+	}
+	for _, h := range spec.Hosts {
+		if !hosts[h] {
+			external = append(external, h)
+		}
+	}
+	switch {
+	case len(gateways) > 0 && len(external) > 0:
+		c.someApp.Spec.Expose = &opsv1.SomeExpose{
+			Hosts:   external,
+			Paths:   paths,
+			Gateway: gateways[0],
+		}
+		if len(gateways) > 1 {
+			c.notef("virtualservice %s: gateways %s not supported, only one gateway, dropped", vs.Name, strings.Join(gateways[1:], ","))
+		}
+	case len(gateways) > 0:
+		c.notef("virtualservice %s: gateways without external hosts not supported, dropped", vs.Name)
+	case len(external) > 0:
+		c.notef("virtualservice %s: hosts %s in mesh not supported, dropped", vs.Name, strings.Join(external, ","))
+	}
+}
+
+func httpRoutesTo(r *istio_api_network_v1beta1.HTTPRoute, hosts map[string]bool) bool {
+	for _, d := range r.Route {
+		if d.Destination != nil && hosts[d.Destination.Host] {
+			return true
+		}
+	}
+	return false
+}
+
+// destinationRule import traffic policy, subsets are created by the operator
+func (c *converter) destinationRule(dr *istio_network_v1beta1.DestinationRule) {
+	spec := &dr.Spec
+	if dr.Name != c.someApp.Spec.AppName {
+		c.notef("destinationrule %s: name differs from app, destinationrule %s will be created for the same host, delete %s after migrated", dr.Name, c.someApp.Spec.AppName, dr.Name)
+	}
+	if len(spec.ExportTo) > 0 {
+		c.notef("destinationrule %s: exportTo not supported, dropped", dr.Name)
+	}
+	if spec.WorkloadSelector != nil {
+		c.notef("destinationrule %s: workloadSelector not supported, dropped", dr.Name)
+	}
+	for _, s := range spec.Subsets {
+		if s.Name != opsv1.StableStage {
+			c.notef("destinationrule %s: subset %s not supported, only stable, dropped", dr.Name, s.Name)
+		} else if s.TrafficPolicy != nil {
+			c.notef("destinationrule %s: traffic policy of subset stable not supported, dropped", dr.Name)
+		}
+	}
+
+	tp := spec.TrafficPolicy
+	if tp == nil {
+		return
+	}
+	policy := &opsv1.SomeIstioTrafficPolicy{}
+
+	if pool := tp.ConnectionPool; pool != nil {
+		policy.ConnectionPool = &opsv1.SomeIstioConnectionPool{}
+		if t := pool.Tcp; t != nil {
+			policy.ConnectionPool.MaxConnections = t.MaxConnections
+			policy.ConnectionPool.ConnectTimeout = duration(t.ConnectTimeout)
+			if t.TcpKeepalive != nil || t.MaxConnectionDuration != nil {
+				c.notef("destinationrule %s: connectionPool.tcp keepalive and maxConnectionDuration not supported, dropped", dr.Name)
+			}
+		}
+		if h := pool.Http; h != nil {
+			policy.ConnectionPool.Http1MaxPendingRequests = h.Http1MaxPendingRequests
+			policy.ConnectionPool.Http2MaxRequests = h.Http2MaxRequests
+			policy.ConnectionPool.MaxRequestsPerConnection = h.MaxRequestsPerConnection
+			policy.ConnectionPool.MaxRetries = h.MaxRetries
+			policy.ConnectionPool.IdleTimeout = duration(h.IdleTimeout)
+			if h.H2UpgradePolicy != 0 || h.UseClientProtocol {
+				c.notef("destinationrule %s: connectionPool.http h2UpgradePolicy and useClientProtocol not supported, dropped", dr.Name)
+			}
+		}
+	}
+
+	if od := tp.OutlierDetection; od != nil {
+		policy.OutlierDetection = &opsv1.SomeIstioOutlierDetection{
+			Interval:           duration(od.Interval),
+			BaseEjectionTime:   duration(od.BaseEjectionTime),
+			MaxEjectionPercent: od.MaxEjectionPercent,
+		}
+		if od.Consecutive_5XxErrors != nil {
+			errors := int32(od.Consecutive_5XxErrors.GetValue())
+			policy.OutlierDetection.Consecutive5xxErrors = &errors
+		}
+		if od.ConsecutiveGatewayErrors != nil || od.ConsecutiveLocalOriginFailures != nil ||
+			od.SplitExternalLocalOriginErrors || od.MinHealthPercent != 0 || od.ConsecutiveErrors != 0 {
+			c.notef("destinationrule %s: outlierDetection only consecutive5xxErrors, interval, baseEjectionTime and maxEjectionPercent supported, others dropped", dr.Name)
+		}
+	}
+
+	if lb := tp.LoadBalancer; lb != nil {
+		policy.LoadBalancer = &opsv1.SomeIstioLoadBalancer{}
+		switch p := lb.LbPolicy.(type) {
+		case *istio_api_network_v1beta1.LoadBalancerSettings_Simple:
+			switch p.Simple {
+			case istio_api_network_v1beta1.LoadBalancerSettings_UNSPECIFIED:
+			// LEAST_CONN is deprecated name of LEAST_REQUEST
+			case istio_api_network_v1beta1.LoadBalancerSettings_LEAST_CONN:
+				policy.LoadBalancer.Simple = istio_api_network_v1beta1.LoadBalancerSettings_LEAST_REQUEST.String()
+			default:
+				policy.LoadBalancer.Simple = p.Simple.String()
+			}
+		case *istio_api_network_v1beta1.LoadBalancerSettings_ConsistentHash:
+			hash := &opsv1.SomeIstioConsistentHash{}
+			switch k := p.ConsistentHash.GetHashKey().(type) {
+			case *istio_api_network_v1beta1.LoadBalancerSettings_ConsistentHashLB_HttpHeaderName:
+				hash.HttpHeaderName = k.HttpHeaderName
+			case *istio_api_network_v1beta1.LoadBalancerSettings_ConsistentHashLB_HttpCookie:
+				hash.HttpCookie = &opsv1.SomeIstioHttpCookie{
+					Name: k.HttpCookie.GetName(),
+					Path: k.HttpCookie.GetPath(),
+					Ttl:  duration(k.HttpCookie.GetTtl()),
+				}
+			case *istio_api_network_v1beta1.LoadBalancerSettings_ConsistentHashLB_UseSourceIp:
+				hash.UseSourceIp = k.UseSourceIp
+			case *istio_api_network_v1beta1.LoadBalancerSettings_ConsistentHashLB_HttpQueryParameterName:
+				hash.HttpQueryParameterName = k.HttpQueryParameterName
+			default:
+				hash = nil
+				c.notef("destinationrule %s: consistentHash key not supported, dropped", dr.Name)
+			}
+			policy.LoadBalancer.ConsistentHash = hash
+		}
+		if lb.LocalityLbSetting != nil || lb.WarmupDurationSecs != nil {
+			c.notef("destinationrule %s: loadBalancer localityLbSetting and warmupDurationSecs not supported, dropped", dr.Name)
+		}
+		if len(policy.LoadBalancer.Simple) == 0 && policy.LoadBalancer.ConsistentHash == nil {
+			policy.LoadBalancer = nil
+		}
+	}
+
+	if tls := tp.Tls; tls != nil {
+		policy.TLSMode = tls.Mode.String()
+		if tls.Mode != istio_api_network_v1beta1.ClientTLSSettings_DISABLE && tls.Mode != istio_api_network_v1beta1.ClientTLSSettings_ISTIO_MUTUAL {
+			c.notef("destinationrule %s: tls settings other than mode not supported, dropped", dr.Name)
+		}
+	}
+
+	if len(tp.PortLevelSettings) > 0 {
+		c.notef("destinationrule %s: portLevelSettings not supported, dropped", dr.Name)
+	}
+	if tp.Tunnel != nil {
+		c.notef("destinationrule %s: tunnel not supported, dropped", dr.Name)
+	}
+
+	c.istio().TrafficPolicy = policy
+}
+
+// duration format like 1s, 500ms, empty when not set
+func duration(d *durationpb.Duration) string {
+	if d == nil {
+		return ""
+	}
+	return d.AsDuration().String()
+}
@@ -0,0 +1,255 @@
+package importer
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	apps_v1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	core_v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	opsv1 "github.com/changqings/some-app-operator/api/v1"
+)
+
+// hpa of autoscaling/v2 without metrics scale on 80% cpu
+const defaultHpaCpuUsage = 80
+
+// annotations set by kubectl and the operator itself, not imported
+var ignoredAnnotations = map[string]bool{
+	"kubectl.kubernetes.io/last-applied-configuration": true,
+	"kubectl.kubernetes.io/restartedAt":                true,
+	opsv1.RestartedAtAnnotation:                        true,
+}
+
+func (c *converter) deployment(d *apps_v1.Deployment) {
+	spec := &c.someApp.Spec
+	podSpec := d.Spec.Template.Spec
+
+	// volumes someapp can not represent are dropped, with their mounts
+	dropped := map[string]bool{}
+	for _, v := range podSpec.Volumes {
+		someVolume, ok := volume(v)
+		if !ok {
+			dropped[v.Name] = true
+			c.notef("volume %s: source not supported, dropped with its mounts", v.Name)
+			continue
+		}
+		spec.Volumes = append(spec.Volumes, someVolume)
+	}
+
+	for _, container := range podSpec.Containers {
+		container = *container.DeepCopy()
+		mounts := container.VolumeMounts[:0]
+		for _, m := range container.VolumeMounts {
+			if !dropped[m.Name] {
+				mounts = append(mounts, m)
+			}
+		}
+		container.VolumeMounts = mounts
+		if len(mounts) == 0 {
+			container.VolumeMounts = nil
+		}
+		// api server defaults, noise in manifests
+		if container.TerminationMessagePath == core_v1.TerminationMessagePathDefault {
+			container.TerminationMessagePath = ""
+		}
+		if container.TerminationMessagePolicy == core_v1.TerminationMessageReadFile {
+			container.TerminationMessagePolicy = ""
+		}
+		spec.Containers = append(spec.Containers, container)
+	}
+
+	for i, s := range podSpec.ImagePullSecrets {
+		if i == 0 {
+			spec.ImagePullSecret = s.Name
+			continue
+		}
+		c.notef("imagePullSecrets: only one supported, %s dropped", s.Name)
+	}
+
+	if sa := podSpec.ServiceAccountName; len(sa) > 0 && sa != "default" {
+		spec.ServiceAccount = &opsv1.SomeServiceAccount{Name: sa}
+	}
+
+	unsupported := []struct {
+		field string
+		set   bool
+	}{
+		{"spec.strategy", d.Spec.Strategy.Type == apps_v1.RecreateDeploymentStrategyType || !defaultRollingUpdate(d.Spec.Strategy.RollingUpdate)},
+		{"spec.minReadySeconds", d.Spec.MinReadySeconds > 0},
+		{"spec.progressDeadlineSeconds", d.Spec.ProgressDeadlineSeconds != nil && *d.Spec.ProgressDeadlineSeconds != 600},
+		{"spec.revisionHistoryLimit", d.Spec.RevisionHistoryLimit != nil && *d.Spec.RevisionHistoryLimit != 10},
+		{"spec.paused", d.Spec.Paused},
+		{"pod initContainers", len(podSpec.InitContainers) > 0},
+		{"pod nodeSelector", len(podSpec.NodeSelector) > 0},
+		{"pod affinity", podSpec.Affinity != nil},
+		{"pod tolerations", len(podSpec.Tolerations) > 0},
+		{"pod topologySpreadConstraints", len(podSpec.TopologySpreadConstraints) > 0},
+		{"pod priorityClassName", len(podSpec.PriorityClassName) > 0},
+		{"pod securityContext", podSpec.SecurityContext != nil && !reflect.DeepEqual(*podSpec.SecurityContext, core_v1.PodSecurityContext{})},
+		{"pod hostNetwork", podSpec.HostNetwork},
+		{"pod hostAliases", len(podSpec.HostAliases) > 0},
+		{"pod dnsPolicy", len(podSpec.DNSPolicy) > 0 && podSpec.DNSPolicy != core_v1.DNSClusterFirst},
+		{"pod dnsConfig", podSpec.DNSConfig != nil},
+		{"pod terminationGracePeriodSeconds", podSpec.TerminationGracePeriodSeconds != nil && *podSpec.TerminationGracePeriodSeconds != core_v1.DefaultTerminationGracePeriodSeconds},
+		{"pod runtimeClassName", podSpec.RuntimeClassName != nil},
+		{"pod schedulerName", len(podSpec.SchedulerName) > 0 && podSpec.SchedulerName != core_v1.DefaultSchedulerName},
+		{"pod automountServiceAccountToken", podSpec.AutomountServiceAccountToken != nil},
+		{"pod shareProcessNamespace", podSpec.ShareProcessNamespace != nil},
+	}
+	for _, u := range unsupported {
+		if u.set {
+			c.notef("deployment %s: not supported, dropped", u.field)
+		}
+	}
+
+	for _, k := range sortedKeys(d.Spec.Template.Annotations) {
+		if !ignoredAnnotations[k] {
+			c.notef("deployment pod annotation %s: not supported, dropped", k)
+		}
+	}
+}
+
+// volume return someapp volume of pod volume, false if source not supported
+func volume(v core_v1.Volume) (opsv1.SomeVolume, bool) {
+	someVolume := opsv1.SomeVolume{
+		Name:                  v.Name,
+		ConfigMap:             v.ConfigMap,
+		Secret:                v.Secret,
+		EmptyDir:              v.EmptyDir,
+		PersistentVolumeClaim: v.PersistentVolumeClaim,
+		Projected:             v.Projected,
+		DownwardAPI:           v.DownwardAPI,
+	}
+	supported := v.ConfigMap != nil || v.Secret != nil || v.EmptyDir != nil ||
+		v.PersistentVolumeClaim != nil || v.Projected != nil || v.DownwardAPI != nil
+	return someVolume, supported
+}
+
+// defaultRollingUpdate check rolling update is default 25% maxSurge and maxUnavailable
+func defaultRollingUpdate(r *apps_v1.RollingUpdateDeployment) bool {
+	if r == nil {
+		return true
+	}
+	isDefault := func(v *intstr.IntOrString) bool {
+		return v == nil || v.String() == "25%"
+	}
+	return isDefault(r.MaxSurge) && isDefault(r.MaxUnavailable)
+}
+
+func (c *converter) service(s *core_v1.Service) {
+	someService := &opsv1.SomeServiceSpec{}
+	switch {
+	case s.Spec.Type == core_v1.ServiceTypeExternalName:
+		c.notef("service %s: type ExternalName not supported, service %s will be created", s.Name, c.someApp.Spec.AppName)
+		return
+	case s.Spec.ClusterIP == core_v1.ClusterIPNone:
+		someService.Type = "Headless"
+	case s.Spec.Type != core_v1.ServiceTypeClusterIP && len(s.Spec.Type) > 0:
+		someService.Type = string(s.Spec.Type)
+	}
+
+	if s.Name != c.someApp.Spec.AppName {
+		c.notef("service %s: name differs from deployment, service %s will be created, delete %s after migrated", s.Name, c.someApp.Spec.AppName, s.Name)
+	}
+
+	nodePorts := s.Spec.Type == core_v1.ServiceTypeNodePort || s.Spec.Type == core_v1.ServiceTypeLoadBalancer
+	for _, p := range s.Spec.Ports {
+		port := opsv1.SomeServicePort{
+			Name:        p.Name,
+			Port:        p.Port,
+			Protocol:    p.Protocol,
+			AppProtocol: p.AppProtocol,
+		}
+		if len(port.Name) == 0 {
+			port.Name = fmt.Sprintf("port-%d", p.Port)
+			c.notef("service %s: unnamed port %d named %s", s.Name, p.Port, port.Name)
+		}
+		if port.Protocol == core_v1.ProtocolTCP {
+			port.Protocol = ""
+		}
+		if p.TargetPort.Type == intstr.String || (p.TargetPort.IntVal != 0 && p.TargetPort.IntVal != p.Port) {
+			targetPort := p.TargetPort
+			port.TargetPort = &targetPort
+		}
+		if nodePorts {
+			port.NodePort = p.NodePort
+		}
+		someService.Ports = append(someService.Ports, port)
+	}
+
+	for k, v := range s.Annotations {
+		if ignoredAnnotations[k] {
+			continue
+		}
+		if someService.Annotations == nil {
+			someService.Annotations = map[string]string{}
+		}
+		someService.Annotations[k] = v
+	}
+	if s.Spec.SessionAffinity == core_v1.ServiceAffinityClientIP {
+		someService.SessionAffinity = s.Spec.SessionAffinity
+	}
+
+	unsupported := []struct {
+		field string
+		set   bool
+	}{
+		{"externalTrafficPolicy", len(s.Spec.ExternalTrafficPolicy) > 0 && s.Spec.ExternalTrafficPolicy != core_v1.ServiceExternalTrafficPolicyTypeCluster},
+		{"internalTrafficPolicy", s.Spec.InternalTrafficPolicy != nil && *s.Spec.InternalTrafficPolicy != core_v1.ServiceInternalTrafficPolicyCluster},
+		{"externalIPs", len(s.Spec.ExternalIPs) > 0},
+		{"loadBalancerIP", len(s.Spec.LoadBalancerIP) > 0},
+		{"loadBalancerSourceRanges", len(s.Spec.LoadBalancerSourceRanges) > 0},
+		{"loadBalancerClass", s.Spec.LoadBalancerClass != nil},
+		{"sessionAffinityConfig", s.Spec.SessionAffinityConfig != nil && s.Spec.SessionAffinity == core_v1.ServiceAffinityClientIP},
+		{"publishNotReadyAddresses", s.Spec.PublishNotReadyAddresses},
+	}
+	for _, u := range unsupported {
+		if u.set {
+			c.notef("service %s: spec.%s not supported, dropped", s.Name, u.field)
+		}
+	}
+	c.someApp.Spec.Service = someService
+}
+
+func (c *converter) hpa(h *autoscalingv2.HorizontalPodAutoscaler) {
+	spec := &c.someApp.Spec
+	if h.Name != spec.AppName {
+		c.notef("hpa %s: name differs from deployment, hpa %s will be created, delete %s after migrated", h.Name, spec.AppName, h.Name)
+	}
+
+	hpaMin := int32(1)
+	if h.Spec.MinReplicas != nil {
+		hpaMin = *h.Spec.MinReplicas
+	}
+	spec.SetHpa = fmt.Sprintf("%d->%d", hpaMin, h.Spec.MaxReplicas)
+	spec.HpaCpuUsage = defaultHpaCpuUsage
+
+	for _, m := range h.Spec.Metrics {
+		if m.Type == autoscalingv2.ResourceMetricSourceType && m.Resource != nil &&
+			m.Resource.Name == core_v1.ResourceCPU && m.Resource.Target.Type == autoscalingv2.UtilizationMetricType &&
+			m.Resource.Target.AverageUtilization != nil {
+			spec.HpaCpuUsage = *m.Resource.Target.AverageUtilization
+			continue
+		}
+		name := string(m.Type)
+		if m.Resource != nil {
+			name += " " + string(m.Resource.Name)
+		}
+		c.notef("hpa %s: metric %s not supported, only cpu utilization, dropped", h.Name, name)
+	}
+	if h.Spec.Behavior != nil {
+		c.notef("hpa %s: spec.behavior not supported, dropped", h.Name)
+	}
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
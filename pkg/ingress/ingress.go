@@ -0,0 +1,157 @@
+package ingress
+
+import (
+	"context"
+	"fmt"
+
+	core_v1 "k8s.io/api/core/v1"
+	networking_v1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	opsv1 "github.com/changqings/some-app-operator/api/v1"
+	"github.com/changqings/some-app-operator/pkg/deployment"
+	"github.com/changqings/some-app-operator/pkg/dryrun"
+	"github.com/changqings/some-app-operator/pkg/istio"
+	"github.com/changqings/some-app-operator/pkg/metrics"
+	"github.com/changqings/some-app-operator/pkg/owner"
+	"github.com/changqings/some-app-operator/pkg/service"
+	"github.com/go-logr/logr"
+)
+
+// defaultPortName of service port as ingress backend, when spec.expose.port not set
+const defaultPortName = "http"
+
+// SomeIngress create ingress of spec.expose to stable service,
+// only when not exposed by istio gateway, or ingress will be deleted
+type SomeIngress struct {
+	Stage string
+}
+
+// Validate check service port of ingress backend exists, invalid spec should not be reconciled
+func Validate(someApp *opsv1.Someapp) error {
+	if someApp.Spec.Expose == nil || deployment.Stage(someApp) != opsv1.StableStage || istio.ExposeByGateway(someApp) {
+		return nil
+	}
+	_, err := backendPort(someApp)
+	return err
+}
+
+// backendPort return service port named spec.expose.port or http
+func backendPort(someApp *opsv1.Someapp) (core_v1.ServicePort, error) {
+	name := someApp.Spec.Expose.Port
+	if len(name) == 0 {
+		name = defaultPortName
+	}
+	for _, p := range service.Ports(someApp) {
+		if p.Name == name {
+			return p, nil
+		}
+	}
+	return core_v1.ServicePort{}, fmt.Errorf("spec.expose: service port %q not found, name a port %s or set spec.expose.port", name, defaultPortName)
+}
+
+func (sg *SomeIngress) Reconcile(ctx context.Context, someApp *opsv1.Someapp, client client.Client, scheme *runtime.Scheme, log logr.Logger) error {
+
+	var (
+		expose      = someApp.Spec.Expose
+		serviceName = service.Name(someApp.Spec.AppName, sg.Stage)
+	)
+
+	ingress := &networking_v1.Ingress{ObjectMeta: meta_v1.ObjectMeta{
+		Name:      someApp.Spec.AppName,
+		Namespace: someApp.Namespace,
+	}}
+
+	if expose == nil || sg.Stage != opsv1.StableStage || istio.ExposeByGateway(someApp) {
+		return sg.delete(ctx, someApp, client, ingress, log)
+	}
+
+	paths := expose.Paths
+	if len(paths) == 0 {
+		paths = []string{"/"}
+	}
+
+	servicePort, err := backendPort(someApp)
+	if err != nil {
+		return err
+	}
+	pathType := networking_v1.PathTypePrefix
+	httpPaths := make([]networking_v1.HTTPIngressPath, 0, len(paths))
+	for _, p := range paths {
+		httpPaths = append(httpPaths, networking_v1.HTTPIngressPath{
+			Path:     p,
+			PathType: &pathType,
+			Backend: networking_v1.IngressBackend{
+				Service: &networking_v1.IngressServiceBackend{
+					Name: serviceName,
+					Port: networking_v1.ServiceBackendPort{Number: servicePort.Port},
+				},
+			},
+		})
+	}
+
+	op, err := controllerutil.CreateOrUpdate(ctx, client, ingress, func() error {
+		if ingress.ObjectMeta.CreationTimestamp.IsZero() {
+			ingress.ObjectMeta.Labels = map[string]string{
+				"app":   someApp.Spec.AppName,
+				"type":  someApp.Spec.AppType,
+				"stage": sg.Stage,
+			}
+		}
+
+		ingress.Spec = networking_v1.IngressSpec{
+			IngressClassName: expose.IngressClassName,
+		}
+		for _, host := range expose.Hosts {
+			ingress.Spec.Rules = append(ingress.Spec.Rules, networking_v1.IngressRule{
+				Host: host,
+				IngressRuleValue: networking_v1.IngressRuleValue{
+					HTTP: &networking_v1.HTTPIngressRuleValue{Paths: httpPaths},
+				},
+			})
+		}
+		if len(expose.TLSSecret) > 0 {
+			ingress.Spec.TLS = []networking_v1.IngressTLS{
+				{Hosts: expose.Hosts, SecretName: expose.TLSSecret},
+			}
+		}
+
+		// add reference
+		if err := controllerutil.SetOwnerReference(someApp, ingress, scheme); err != nil {
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	metrics.ObserveOperation(dryrun.Enabled(client), "Ingress", op)
+	log.Info("ingress reconcile success", "operation_result", op)
+	return nil
+}
+
+// delete ingress created by this someApp before
+func (sg *SomeIngress) delete(ctx context.Context, someApp *opsv1.Someapp, c client.Client, ingress *networking_v1.Ingress, log logr.Logger) error {
+	if err := c.Get(ctx, client.ObjectKeyFromObject(ingress), ingress); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	if !owner.IsOwnedBy(ingress, someApp) {
+		return nil
+	}
+
+	if err := c.Delete(ctx, ingress); client.IgnoreNotFound(err) != nil {
+		return err
+	}
+	log.Info("ingress deleted", "ingress_name", ingress.Name)
+	return nil
+}
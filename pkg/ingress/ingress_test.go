@@ -0,0 +1,85 @@
+package ingress
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	core_v1 "k8s.io/api/core/v1"
+	networking_v1 "k8s.io/api/networking/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	opsv1 "github.com/changqings/some-app-operator/api/v1"
+)
+
+func TestBackendPort(t *testing.T) {
+	ports := []opsv1.SomeServicePort{{Name: "grpc", Port: 9090}, {Name: "http", Port: 8080}, {Name: "admin", Port: 9000}}
+
+	tests := []struct {
+		name     string
+		ports    []opsv1.SomeServicePort
+		port     string
+		gateway  string
+		wantPort int32
+		wantErr  bool
+	}{
+		{name: "default service port", wantPort: 80},
+		{name: "http not first", ports: ports, wantPort: 8080},
+		{name: "named in expose", ports: ports, port: "admin", wantPort: 9000},
+		{name: "named not found", ports: ports, port: "web", wantErr: true},
+		{name: "no http port", ports: ports[:1], wantErr: true},
+		{name: "exposed by gateway not checked", ports: ports[:1], gateway: "istio-system/ingressgateway"},
+	}
+
+	scheme := runtime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(opsv1.AddToScheme(scheme))
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			someApp := &opsv1.Someapp{
+				ObjectMeta: meta_v1.ObjectMeta{Name: "app-a", Namespace: "default"},
+				Spec: opsv1.SomeappSpec{
+					AppName:     "app-a",
+					AppType:     opsv1.AppTypeApi,
+					AppVersion:  opsv1.StableStage,
+					EnableIstio: len(tt.gateway) > 0,
+					Expose:      &opsv1.SomeExpose{Hosts: []string{"a.example.com"}, Port: tt.port, Gateway: tt.gateway},
+					Containers: []core_v1.Container{{
+						Name:  "app",
+						Image: "nginx",
+						Ports: []core_v1.ContainerPort{{Name: "http", ContainerPort: 8080}},
+					}},
+				},
+			}
+			if len(tt.ports) > 0 {
+				someApp.Spec.Service = &opsv1.SomeServiceSpec{Ports: tt.ports}
+			}
+
+			if err := Validate(someApp); (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr || len(tt.gateway) > 0 {
+				return
+			}
+
+			c := fake.NewClientBuilder().WithScheme(scheme).Build()
+			sg := &SomeIngress{Stage: opsv1.StableStage}
+			if err := sg.Reconcile(context.Background(), someApp, c, scheme, logr.Discard()); err != nil {
+				t.Fatalf("Reconcile() error = %v", err)
+			}
+			ingress := &networking_v1.Ingress{}
+			if err := c.Get(context.Background(), client.ObjectKeyFromObject(someApp), ingress); err != nil {
+				t.Fatal(err)
+			}
+			if got := ingress.Spec.Rules[0].HTTP.Paths[0].Backend.Service.Port.Number; got != tt.wantPort {
+				t.Errorf("backend port = %d, want %d", got, tt.wantPort)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package istio
+
+import (
+	"fmt"
+	"time"
+
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	opsv1 "github.com/changqings/some-app-operator/api/v1"
+
+	istio_api_network_v1 "istio.io/api/networking/v1"
+)
+
+// validateHttp check durations of spec.istio.http
+func validateHttp(someApp *opsv1.Someapp) error {
+	spec := httpSpec(someApp)
+	if spec == nil {
+		return nil
+	}
+
+	if _, err := parseDuration(spec.Timeout); err != nil {
+		return fmt.Errorf("spec.istio.http.timeout: %w", err)
+	}
+	if spec.Retries != nil {
+		if _, err := parseDuration(spec.Retries.PerTryTimeout); err != nil {
+			return fmt.Errorf("spec.istio.http.retries.perTryTimeout: %w", err)
+		}
+	}
+	if spec.Fault != nil && spec.Fault.Delay != nil {
+		d, err := parseDuration(spec.Fault.Delay.FixedDelay)
+		if err != nil {
+			return fmt.Errorf("spec.istio.http.fault.delay.fixedDelay: %w", err)
+		}
+		if d == nil {
+			return fmt.Errorf("spec.istio.http.fault.delay.fixedDelay: is required")
+		}
+	}
+	return nil
+}
+
+// setHttpPolicy set timeout, retries and fault of route from spec.istio.http,
+// route policy removed if not set
+func setHttpPolicy(route *istio_api_network_v1.HTTPRoute, someApp *opsv1.Someapp) {
+	route.Timeout = nil
+	route.Retries = nil
+	route.Fault = nil
+
+	spec := httpSpec(someApp)
+	if spec == nil {
+		return
+	}
+
+	// already validated by Validate
+	route.Timeout, _ = parseDuration(spec.Timeout)
+
+	if spec.Retries != nil {
+		perTryTimeout, _ := parseDuration(spec.Retries.PerTryTimeout)
+		route.Retries = &istio_api_network_v1.HTTPRetry{
+			Attempts:      spec.Retries.Attempts,
+			PerTryTimeout: perTryTimeout,
+			RetryOn:       spec.Retries.RetryOn,
+		}
+	}
+
+	if spec.Fault != nil && (spec.Fault.Delay != nil || spec.Fault.Abort != nil) {
+		route.Fault = &istio_api_network_v1.HTTPFaultInjection{}
+		if delay := spec.Fault.Delay; delay != nil {
+			fixedDelay, _ := parseDuration(delay.FixedDelay)
+			route.Fault.Delay = &istio_api_network_v1.HTTPFaultInjection_Delay{
+				Percentage: &istio_api_network_v1.Percent{Value: float64(delay.Percent)},
+				HttpDelayType: &istio_api_network_v1.HTTPFaultInjection_Delay_FixedDelay{
+					FixedDelay: fixedDelay,
+				},
+			}
+		}
+		if abort := spec.Fault.Abort; abort != nil {
+			route.Fault.Abort = &istio_api_network_v1.HTTPFaultInjection_Abort{
+				Percentage: &istio_api_network_v1.Percent{Value: float64(abort.Percent)},
+				ErrorType: &istio_api_network_v1.HTTPFaultInjection_Abort_HttpStatus{
+					HttpStatus: abort.HttpStatus,
+				},
+			}
+		}
+	}
+}
+
+func httpSpec(someApp *opsv1.Someapp) *opsv1.SomeIstioHttp {
+	if someApp.Spec.Istio == nil {
+		return nil
+	}
+	return someApp.Spec.Istio.Http
+}
+
+// parseDuration return nil when empty
+func parseDuration(s string) (*durationpb.Duration, error) {
+	if len(s) == 0 {
+		return nil, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return nil, err
+	}
+	if d <= 0 {
+		return nil, fmt.Errorf("duration %s must be positive", s)
+	}
+	return durationpb.New(d), nil
+}
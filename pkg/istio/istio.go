@@ -2,46 +2,108 @@ package istio
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"strings"
+	"time"
 
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/retry"
 	pkgClient "sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 
 	opsv1 "github.com/changqings/some-app-operator/api/v1"
+	"github.com/changqings/some-app-operator/pkg/apply"
+	"github.com/changqings/some-app-operator/pkg/dryrun"
+	"github.com/changqings/some-app-operator/pkg/metrics"
+	"github.com/changqings/some-app-operator/pkg/owner"
+	"github.com/changqings/some-app-operator/pkg/service"
 	"github.com/go-logr/logr"
 
-	istio_api_network_v1beta1 "istio.io/api/networking/v1beta1"
-	istio_network_v1beta1 "istio.io/client-go/pkg/apis/networking/v1beta1"
+	istio_api_network_v1 "istio.io/api/networking/v1"
+	istio_network_v1 "istio.io/client-go/pkg/apis/networking/v1"
 )
 
-// for safe, service not add ownerReference, plase delete it manually
+// conflictBackoff retry update of vs/dr shared by stable and canary someapps,
+// more steps and jitter than retry.DefaultRetry, for many canaries at the same time
+var conflictBackoff = wait.Backoff{
+	Steps:    10,
+	Duration: 10 * time.Millisecond,
+	Factor:   1.5,
+	Jitter:   1.0,
+}
+
+// ErrWaitingForStable canary can not route traffic before stable vs/dr created,
+// canary will be reconciled again when stable ones changed
+var ErrWaitingForStable = errors.New("waiting for stable")
+
+// owned by someapp, kept when someapp deleted by spec.deletionPolicy
 // only select someApp.Spec.AppType="api"
 // labelSelector  targetPort="http"
 type SomeIstio struct {
 	Stage            string
 	DeleteAction     bool
+	APIVersion       string          // networking.istio.io v1 or v1beta1, default v1beta1
+	Drifts           *apply.Drifts   // drifts found when apply, nil to ignore
+	Adoption         *owner.Adoption // existing stable vs/dr not owned by someapp, nil to not check
 	svcHost          string
 	vsHttpRouterName string
 	drName           string
 	subsetName       string
 }
 
+// Validate check spec.istio, invalid spec should not be reconciled
+func Validate(someApp *opsv1.Someapp) error {
+	if err := validateHttp(someApp); err != nil {
+		return err
+	}
+	if _, err := trafficPolicy(someApp); err != nil {
+		return err
+	}
+	return nil
+}
+
 func (si *SomeIstio) Reconcile(ctx context.Context, someApp *opsv1.Someapp, c pkgClient.Client, scheme *runtime.Scheme, log logr.Logger) error {
 
-	si.svcHost = someApp.Spec.AppName + "." + someApp.Namespace + "." + "svc.cluster.local"
+	si.svcHost = service.Name(someApp.Spec.AppName, si.Stage) + "." + someApp.Namespace + "." + "svc.cluster.local"
 	si.vsHttpRouterName = someApp.Spec.AppName + "-" + someApp.Spec.AppVersion
 	si.drName = someApp.Spec.AppName
 	si.subsetName = strings.ReplaceAll(someApp.Spec.AppVersion, ".", "-")
 
 	if si.Stage == opsv1.CanaryStage {
-		si.svcHost = someApp.Spec.AppName + "-canary." + someApp.Namespace + "." + "svc.cluster.local"
 		si.vsHttpRouterName = someApp.Spec.AppName + "-" + si.subsetName
 		si.drName = someApp.Spec.AppName + "-canary"
 	}
 
+	// stable vs and dr are owned by stable someapp, just delete them
+	if si.Stage == opsv1.StableStage && si.DeleteAction {
+		vs, err := toVersion(si.APIVersion, &istio_network_v1.VirtualService{
+			ObjectMeta: meta_v1.ObjectMeta{Name: someApp.Spec.AppName, Namespace: someApp.Namespace},
+		})
+		if err != nil {
+			return err
+		}
+		if err := deleteOwned(ctx, someApp, c, vs, log); err != nil {
+			return err
+		}
+		dr, err := toVersion(si.APIVersion, &istio_network_v1.DestinationRule{
+			ObjectMeta: meta_v1.ObjectMeta{Name: si.drName, Namespace: someApp.Namespace},
+		})
+		if err != nil {
+			return err
+		}
+		return deleteOwned(ctx, someApp, c, dr, log)
+	}
+
+	if si.Stage == opsv1.CanaryStage && !si.DeleteAction {
+		if err := si.checkStableDr(ctx, someApp, c); err != nil {
+			return err
+		}
+	}
+
 	if err := si.reconcileVs(ctx, someApp, c, scheme, log); err != nil {
 		return err
 	}
@@ -55,181 +117,396 @@ func (si *SomeIstio) Reconcile(ctx context.Context, someApp *opsv1.Someapp, c pk
 
 func (si *SomeIstio) reconcileVs(ctx context.Context, someApp *opsv1.Someapp, c pkgClient.Client, scheme *runtime.Scheme, log logr.Logger) error {
 
-	vs := &istio_network_v1beta1.VirtualService{ObjectMeta: meta_v1.ObjectMeta{
-		Name:      someApp.Spec.AppName,
-		Namespace: someApp.Namespace,
-	}}
+	// vs is shared by stable and all canary someapps, each try read it into a new object
+	var vs *istio_network_v1.VirtualService
+	newVs := func() {
+		vs = &istio_network_v1.VirtualService{ObjectMeta: meta_v1.ObjectMeta{
+			Name:      someApp.Spec.AppName,
+			Namespace: someApp.Namespace,
+		}}
+	}
 
 	// stable stage, create vs
+	// canary someapps update the same vs, retry on conflict
 	if si.Stage == opsv1.StableStage && !si.DeleteAction {
-		op_vs, err := controllerutil.CreateOrUpdate(ctx, c, vs, func() error {
+		var op_vs controllerutil.OperationResult
+		err := retry.RetryOnConflict(conflictBackoff, func() error {
+			// canary routes and resourceVersion of existing vs, not found is ok
+			newVs()
+			_, adopted, err := adoptObject(ctx, si.Adoption, c, si.APIVersion, pkgClient.ObjectKeyFromObject(vs), vs)
+			if err != nil {
+				return err
+			}
+			existingHttpRouters, resourceVersion := vs.Spec.Http, vs.ResourceVersion
+			// routes of adopted vs are replaced, no canary routes before adoption
+			if adopted {
+				existingHttpRouters = nil
+			}
 
-			if vs.ObjectMeta.CreationTimestamp.IsZero() {
-				vs.ObjectMeta.Labels = map[string]string{
-					"app":   someApp.Spec.AppName,
-					"type":  someApp.Spec.AppType,
-					"stage": si.Stage,
+			newVs()
+			vs.ObjectMeta.ResourceVersion = resourceVersion
+			vs.ObjectMeta.Labels = map[string]string{
+				"app":   someApp.Spec.AppName,
+				"type":  someApp.Spec.AppType,
+				"stage": si.Stage,
+			}
+
+			stableHttpRouter := &istio_api_network_v1.HTTPRoute{
+				Name:  si.vsHttpRouterName,
+				Match: httpMatches(someApp),
+				Route: []*istio_api_network_v1.HTTPRouteDestination{
+					{
+						Destination: &istio_api_network_v1.Destination{
+							Host:   si.svcHost,
+							Subset: si.subsetName,
+						},
+						Weight: 0,
+					},
+				},
+			}
+			setHttpPolicy(stableHttpRouter, someApp)
+
+			// keep canary routes added by canary someapps, they are before stable route,
+			// with match of stable route, so changed expose applies to canaries too
+			var httpRouters []*istio_api_network_v1.HTTPRoute
+			for _, v := range existingHttpRouters {
+				if v.Name != si.vsHttpRouterName {
+					v.Match = copyMatches(stableHttpRouter.Match)
+					httpRouters = append(httpRouters, v)
 				}
 			}
 
-			vs.Spec = istio_api_network_v1beta1.VirtualService{
+			vs.Spec = istio_api_network_v1.VirtualService{
 				Gateways: []string{"mesh"},
 				Hosts: []string{
 					si.svcHost,
 				},
-				Http: []*istio_api_network_v1beta1.HTTPRoute{
-					{
-						Name: si.vsHttpRouterName,
-						Route: []*istio_api_network_v1beta1.HTTPRouteDestination{
-							{
-								Destination: &istio_api_network_v1beta1.Destination{
-									Host:   si.svcHost,
-									Subset: si.subsetName,
-								},
-								Weight: 0,
-							},
-						},
-					},
-				},
+				Http: append(httpRouters, stableHttpRouter),
+				Tcp:  si.tcpRoutes(someApp),
+			}
+			if ExposeByGateway(someApp) {
+				vs.Spec.Gateways = append(vs.Spec.Gateways, someApp.Spec.Expose.Gateway)
+				vs.Spec.Hosts = append(vs.Spec.Hosts, someApp.Spec.Expose.Hosts...)
 			}
 			//used with careful, should turn off this on production
 			if err := controllerutil.SetOwnerReference(someApp, vs, scheme); err != nil {
 				return err
 			}
 
-			return nil
+			// fields of adopted one set by others are taken over, not drift
+			drifts := si.Drifts
+			if adopted {
+				drifts = nil
+			}
+			vsObj, err := toVersion(si.APIVersion, vs)
+			if err != nil {
+				return err
+			}
+			op_vs, err = apply.Apply(ctx, c, vsObj, drifts)
+			return err
 		})
 
 		if err != nil {
 			return err
 		}
 
+		metrics.ObserveOperation(dryrun.Enabled(c), "VirtualService", op_vs)
 		log.Info("vs reconcile success", "operation_result", op_vs)
 		return nil
 	}
 
-	// canary stage, delete or patch vs
-	key := pkgClient.ObjectKeyFromObject(vs)
-	if err := c.Get(ctx, key, vs); err != nil {
+	// canary stage, delete or patch vs, re-read and modify on conflict
+	stableRouterName := someApp.Spec.AppName + "-" + opsv1.StableStage
+	var op_vs controllerutil.OperationResult
+	err := retry.RetryOnConflict(conflictBackoff, func() error {
+		op_vs = controllerutil.OperationResultNone
+		newVs()
+
+		if err := getObject(ctx, c, si.APIVersion, pkgClient.ObjectKeyFromObject(vs), vs); err != nil {
+			return err
+		}
+		// vs is a fresh copy from Get, only http routes modified and applied
+		existing_vs := vs
+
+		// modify
+		stableRouterIndex := -1
+		canaryRouterIndex := -1
+
+		for i, v := range existing_vs.Spec.Http {
+			switch v.Name {
+			case stableRouterName:
+				stableRouterIndex = i
+			case si.vsHttpRouterName:
+				canaryRouterIndex = i
+			}
+		}
+
+		switch {
+		// do delete
+		case si.DeleteAction:
+			if canaryRouterIndex < 0 {
+				return nil
+			}
+			existing_vs.Spec.Http = append(existing_vs.Spec.Http[:canaryRouterIndex], existing_vs.Spec.Http[canaryRouterIndex+1:]...)
+
+		case canaryRouterIndex >= 0:
+			// match rebuilt from stable route, expose of stable may be changed
+			if stableRouterIndex >= 0 {
+				existing_vs.Spec.Http[canaryRouterIndex].Match = copyMatches(existing_vs.Spec.Http[stableRouterIndex].Match)
+			}
+			setHttpPolicy(existing_vs.Spec.Http[canaryRouterIndex], someApp)
+			si.setCanaryWeight(existing_vs.Spec.Http[canaryRouterIndex], someApp)
+
+		case stableRouterIndex < 0:
+			return fmt.Errorf("%w: http route %s not found in vs %s/%s", ErrWaitingForStable, stableRouterName, vs.Namespace, vs.Name)
+
+		default:
+			existing_vs.Spec.Http = append(existing_vs.Spec.Http[:stableRouterIndex],
+				append([]*istio_api_network_v1.HTTPRoute{si.canaryHttpRouter(existing_vs.Spec.Http[stableRouterIndex], someApp)},
+					existing_vs.Spec.Http[stableRouterIndex:]...)...)
+		}
+
+		// http routes are an atomic list, so applied all with canary field manager,
+		// hosts, gateways, labels and others stay with stable someapp,
+		// apply with resourceVersion read, conflict if changed by others
+		canaryVs := &istio_network_v1.VirtualService{
+			ObjectMeta: meta_v1.ObjectMeta{
+				Name:            vs.Name,
+				Namespace:       vs.Namespace,
+				ResourceVersion: vs.ResourceVersion,
+			},
+		}
+		canaryVs.Spec.Http = existing_vs.Spec.Http
+		vsObj, err := toVersion(si.APIVersion, canaryVs)
+		if err != nil {
+			return err
+		}
+		op_vs, err = apply.ApplyAs(ctx, c, vsObj, apply.CanaryFieldManager, si.Drifts)
+		return err
+	})
+	if err != nil {
 		if apierrors.IsNotFound(err) {
 			log.Info("stable vs not found", "vs_name", vs.Name, "vs_namespace", vs.Namespace)
-			// return nil will not enqueue this cr
-			return nil
+			if si.DeleteAction {
+				return nil
+			}
+			return fmt.Errorf("%w: vs %s/%s not found", ErrWaitingForStable, vs.Namespace, vs.Name)
 		}
 		return err
 	}
-	existing_vs := vs.DeepCopy()
+	metrics.ObserveOperation(dryrun.Enabled(c), "VirtualService", op_vs)
+	log.Info("canary vs reconcile success", "operation_result", op_vs)
 
-	// modify
-	var stableRouterIndex int
-	var canaryRouterExist bool
-	var canaryRouterIndex int
+	return nil
+}
 
-	for i, v := range existing_vs.Spec.Http {
-		if v.Name == someApp.Spec.AppName+"-stable" {
-			stableRouterIndex = i
-			break
+// checkStableDr check stable dr exist, stable subset used by canary routes
+func (si *SomeIstio) checkStableDr(ctx context.Context, someApp *opsv1.Someapp, c pkgClient.Client) error {
+	key := pkgClient.ObjectKey{Name: someApp.Spec.AppName, Namespace: someApp.Namespace}
+	if err := getObject(ctx, c, si.APIVersion, key, &istio_network_v1.DestinationRule{}); err != nil {
+		if apierrors.IsNotFound(err) {
+			return fmt.Errorf("%w: dr %s/%s not found", ErrWaitingForStable, key.Namespace, key.Name)
 		}
+		return err
 	}
+	return nil
+}
 
-	for i, v := range existing_vs.Spec.Http {
-		if v.Name == si.vsHttpRouterName {
-			canaryRouterExist = true
-			canaryRouterIndex = i
-			break
+// canaryHttpRouter copy stable route, with same match, so canary works on external host too,
+// stable destination weight 100 and canary destination weight 0, or weight of spec.istio.weight
+func (si *SomeIstio) canaryHttpRouter(stableHttpRouter *istio_api_network_v1.HTTPRoute, someApp *opsv1.Someapp) *istio_api_network_v1.HTTPRoute {
+	canaryHttpRouter := &istio_api_network_v1.HTTPRoute{
+		Name:  si.vsHttpRouterName,
+		Match: copyMatches(stableHttpRouter.Match),
+	}
+	for _, v := range stableHttpRouter.Route {
+		route := v.DeepCopy()
+		if route.Destination != nil && route.Destination.Subset == opsv1.StableStage {
+			route.Weight = 100
 		}
+		canaryHttpRouter.Route = append(canaryHttpRouter.Route, route)
 	}
+	canaryHttpRouter.Route = append(canaryHttpRouter.Route, &istio_api_network_v1.HTTPRouteDestination{
+		Destination: &istio_api_network_v1.Destination{
+			Host:   si.svcHost,
+			Subset: si.subsetName,
+		},
+		Weight: 0,
+	})
+	setHttpPolicy(canaryHttpRouter, someApp)
+	si.setCanaryWeight(canaryHttpRouter, someApp)
+
+	return canaryHttpRouter
+}
 
-	// do delete
-	if si.DeleteAction && canaryRouterExist {
+// copyMatches deep copy match of stable route for canary route
+func copyMatches(matches []*istio_api_network_v1.HTTPMatchRequest) []*istio_api_network_v1.HTTPMatchRequest {
+	var copied []*istio_api_network_v1.HTTPMatchRequest
+	for _, m := range matches {
+		copied = append(copied, m.DeepCopy())
+	}
+	return copied
+}
 
-		existing_vs.Spec.Http = append(existing_vs.Spec.Http[:canaryRouterIndex], existing_vs.Spec.Http[canaryRouterIndex+1:]...)
+// setCanaryWeight set canary destination weight of spec.istio.weight, stable destination the rest,
+// weights kept if not set
+func (si *SomeIstio) setCanaryWeight(canaryHttpRouter *istio_api_network_v1.HTTPRoute, someApp *opsv1.Someapp) {
+	if someApp.Spec.Istio == nil || someApp.Spec.Istio.Weight == nil {
+		return
+	}
+	weight := *someApp.Spec.Istio.Weight
+	for _, route := range canaryHttpRouter.Route {
+		if route.Destination == nil {
+			continue
+		}
+		switch route.Destination.Subset {
+		case si.subsetName:
+			route.Weight = weight
+		case opsv1.StableStage:
+			route.Weight = 100 - weight
+		}
+	}
+}
 
-	} else if !si.DeleteAction && !canaryRouterExist {
+// CanaryWeight return weight of canary destination on canary route of stable vs,
+// false if vs or canary route not found
+func CanaryWeight(ctx context.Context, c pkgClient.Reader, apiVersion string, someApp *opsv1.Someapp) (int32, bool, error) {
+	vs := &istio_network_v1.VirtualService{}
+	if err := getObject(ctx, c, apiVersion, pkgClient.ObjectKey{Namespace: someApp.Namespace, Name: someApp.Spec.AppName}, vs); err != nil {
+		return 0, false, pkgClient.IgnoreNotFound(err)
+	}
 
-		for i, v := range existing_vs.Spec.Http[stableRouterIndex].Route {
-			if v.Destination.Subset == "stable" {
-				existing_vs.Spec.Http[stableRouterIndex].Route[i].Weight = 100
+	subsetName := strings.ReplaceAll(someApp.Spec.AppVersion, ".", "-")
+	routeName := someApp.Spec.AppName + "-" + subsetName
+	for _, route := range vs.Spec.Http {
+		if route.Name != routeName {
+			continue
+		}
+		for _, dest := range route.Route {
+			if dest.Destination != nil && dest.Destination.Subset == subsetName {
+				return dest.Weight, true, nil
 			}
 		}
+	}
+	return 0, false, nil
+}
 
-		canaryHttpRouter := &istio_api_network_v1beta1.HTTPRoute{
-			Name: si.vsHttpRouterName,
-			Route: append(existing_vs.Spec.Http[stableRouterIndex].Route, &istio_api_network_v1beta1.HTTPRouteDestination{
-				Destination: &istio_api_network_v1beta1.Destination{
-					Host:   si.svcHost,
-					Subset: si.subsetName,
-				},
-				Weight: 0,
-			}),
-		}
+// ExposeByGateway check stable vs should attach to istio gateway of spec.expose
+func ExposeByGateway(someApp *opsv1.Someapp) bool {
+	return someApp.Spec.EnableIstio &&
+		someApp.Spec.AppType == opsv1.AppTypeApi &&
+		someApp.Spec.Expose != nil &&
+		len(someApp.Spec.Expose.Gateway) > 0
+}
 
-		existing_vs.Spec.Http = append(existing_vs.Spec.Http[:stableRouterIndex],
-			append([]*istio_api_network_v1beta1.HTTPRoute{canaryHttpRouter}, existing_vs.Spec.Http[stableRouterIndex:]...)...)
+// httpMatches return nil when not exposed, match all,
+// or match mesh, and path prefixes on gateway
+func httpMatches(someApp *opsv1.Someapp) []*istio_api_network_v1.HTTPMatchRequest {
+	if !ExposeByGateway(someApp) {
+		return nil
 	}
 
-	// update
-	if err := c.Update(ctx, existing_vs); err != nil {
-		return err
+	paths := someApp.Spec.Expose.Paths
+	if len(paths) == 0 {
+		paths = []string{"/"}
 	}
-	log.Info("canary vs reconcile success", "operation_result", controllerutil.OperationResultUpdated)
 
-	return nil
+	matches := []*istio_api_network_v1.HTTPMatchRequest{
+		{Gateways: []string{"mesh"}},
+	}
+	for _, p := range paths {
+		matches = append(matches, &istio_api_network_v1.HTTPMatchRequest{
+			Gateways: []string{someApp.Spec.Expose.Gateway},
+			Uri: &istio_api_network_v1.StringMatch{
+				MatchType: &istio_api_network_v1.StringMatch_Prefix{Prefix: p},
+			},
+		})
+	}
+	return matches
+}
+
+// tcpRoutes route plain tcp service ports to stable subset, http routes not work on them
+func (si *SomeIstio) tcpRoutes(someApp *opsv1.Someapp) []*istio_api_network_v1.TCPRoute {
+	var routes []*istio_api_network_v1.TCPRoute
+
+	for _, p := range service.Ports(someApp) {
+		if !service.IsTCPPort(p) {
+			continue
+		}
+		routes = append(routes, &istio_api_network_v1.TCPRoute{
+			Match: []*istio_api_network_v1.L4MatchAttributes{
+				{Port: uint32(p.Port), Gateways: []string{"mesh"}},
+			},
+			Route: []*istio_api_network_v1.RouteDestination{
+				{
+					Destination: &istio_api_network_v1.Destination{
+						Host:   si.svcHost,
+						Subset: si.subsetName,
+						Port:   &istio_api_network_v1.PortSelector{Number: uint32(p.Port)},
+					},
+				},
+			},
+		})
+	}
+
+	return routes
 }
+
 func (si *SomeIstio) reconcileDr(ctx context.Context, someApp *opsv1.Someapp, c pkgClient.Client, scheme *runtime.Scheme, log logr.Logger) error {
 
-	dr := &istio_network_v1beta1.DestinationRule{ObjectMeta: meta_v1.ObjectMeta{
+	dr := &istio_network_v1.DestinationRule{ObjectMeta: meta_v1.ObjectMeta{
 		Name:      si.drName,
 		Namespace: someApp.Namespace,
 	}}
 
-	// create or patch dr
-	// check dr exist,
-	dr_key := pkgClient.ObjectKeyFromObject(dr)
-
-	if err := c.Get(ctx, dr_key, dr); err != nil {
-		if apierrors.IsNotFound(err) {
-			log.Info("stable dr not found", "dr_name", dr.Name, "dr_namespace", dr.Namespace)
-			// return nil will not enqueue this cr
-			return nil
-		}
-	}
+	// already validated by Validate
+	tp, _ := trafficPolicy(someApp)
 
 	// create dr
 	if !si.DeleteAction && si.Stage == opsv1.StableStage {
-		op_dr, err := controllerutil.CreateOrUpdate(ctx, c, dr, func() error {
-
-			// patch canary subsetName on annotation, for delete  use
-			if dr.ObjectMeta.CreationTimestamp.IsZero() {
-				dr.ObjectMeta.Labels = map[string]string{
-					"app":   someApp.Spec.AppName,
-					"type":  someApp.Spec.AppType,
-					"stage": si.Stage,
-				}
-			}
+		dr.ObjectMeta.Labels = map[string]string{
+			"app":   someApp.Spec.AppName,
+			"type":  someApp.Spec.AppType,
+			"stage": si.Stage,
+		}
 
-			dr.Spec = istio_api_network_v1beta1.DestinationRule{
-				Host: si.svcHost,
-				Subsets: []*istio_api_network_v1beta1.Subset{
-					{
-						Labels: map[string]string{
-							"version": someApp.Spec.AppVersion,
-						},
-						Name: si.subsetName,
+		// stable traffic policy on dr level
+		dr.Spec = istio_api_network_v1.DestinationRule{
+			Host:          si.svcHost,
+			TrafficPolicy: tp,
+			Subsets: []*istio_api_network_v1.Subset{
+				{
+					Labels: map[string]string{
+						"version": someApp.Spec.AppVersion,
 					},
+					Name: si.subsetName,
 				},
-			}
+			},
+		}
 
-			if err := controllerutil.SetOwnerReference(someApp, dr, scheme); err != nil {
-				return err
-			}
+		_, adopted, err := adoptObject(ctx, si.Adoption, c, si.APIVersion, pkgClient.ObjectKeyFromObject(dr), &istio_network_v1.DestinationRule{})
+		if err != nil {
+			return err
+		}
 
-			return nil
-		})
+		if err := controllerutil.SetOwnerReference(someApp, dr, scheme); err != nil {
+			return err
+		}
 
+		// fields of adopted one set by others are taken over, not drift
+		drifts := si.Drifts
+		if adopted {
+			drifts = nil
+		}
+		drObj, err := toVersion(si.APIVersion, dr)
+		if err != nil {
+			return err
+		}
+		op_dr, err := apply.Apply(ctx, c, drObj, drifts)
 		if err != nil {
 			return err
 		}
+		metrics.ObserveOperation(dryrun.Enabled(c), "DestinationRule", op_dr)
 		log.Info("dr reconcile success", "operation_result", op_dr)
 		return nil
 
@@ -238,40 +515,115 @@ func (si *SomeIstio) reconcileDr(ctx context.Context, someApp *opsv1.Someapp, c
 	// patch dr
 	if si.Stage == opsv1.CanaryStage {
 
-		subsetExisting := false
-		subsetIndex := 0
-		existing_dr := dr.DeepCopy()
+		// canary dr is shared by all canary someapps, first one create it,
+		// re-read and modify on conflict or created by others
+		var op_dr controllerutil.OperationResult
+		err := retry.OnError(conflictBackoff, func(err error) bool {
+			return apierrors.IsConflict(err) || apierrors.IsAlreadyExists(err)
+		}, func() error {
+			op_dr = controllerutil.OperationResultNone
+			dr = &istio_network_v1.DestinationRule{ObjectMeta: meta_v1.ObjectMeta{
+				Name:      si.drName,
+				Namespace: someApp.Namespace,
+			}}
+
+			// check dr exist
+			drExisting := true
+			if err := getObject(ctx, c, si.APIVersion, pkgClient.ObjectKeyFromObject(dr), dr); err != nil {
+				if !apierrors.IsNotFound(err) {
+					return err
+				}
+				drExisting = false
+				if si.DeleteAction {
+					return nil
+				}
 
-		// check subset.Name already exist in dr
-		for i, v := range existing_dr.Spec.Subsets {
-			if v.Name == si.subsetName {
-				subsetExisting = true
-				subsetIndex = i
-				break
+				// first canary, create canary dr
+				dr.ObjectMeta.Labels = map[string]string{
+					"app":   someApp.Spec.AppName,
+					"type":  someApp.Spec.AppType,
+					"stage": si.Stage,
+				}
+				dr.Spec = istio_api_network_v1.DestinationRule{
+					Host: si.svcHost,
+				}
 			}
-		}
 
-		if si.DeleteAction && subsetExisting {
-			existing_dr.Spec.Subsets = append(existing_dr.Spec.Subsets[:subsetIndex], existing_dr.Spec.Subsets[subsetIndex+1:]...)
-		} else if !si.DeleteAction && !subsetExisting {
-			existing_dr.Spec.Subsets = append(existing_dr.Spec.Subsets, &istio_api_network_v1beta1.Subset{
-				Labels: map[string]string{
-					"version": someApp.Spec.AppVersion,
-				},
-				Name: si.subsetName,
-			})
+			// dr is a fresh copy from Get, modify it directly
+			existing_dr := dr
 
-			if err := controllerutil.SetOwnerReference(someApp, existing_dr, scheme); err != nil {
-				return err
+			// check subset.Name already exist in dr
+			subsetIndex := -1
+			for i, v := range existing_dr.Spec.Subsets {
+				if v.Name == si.subsetName {
+					subsetIndex = i
+					break
+				}
 			}
 
-		}
-		// update
-		if err := c.Update(ctx, existing_dr); err != nil {
+			// canary traffic policy on its own subset
+			switch {
+			case si.DeleteAction:
+				if subsetIndex < 0 && !owner.IsOwnedBy(existing_dr, someApp) {
+					return nil
+				}
+				if subsetIndex >= 0 {
+					existing_dr.Spec.Subsets = append(existing_dr.Spec.Subsets[:subsetIndex], existing_dr.Spec.Subsets[subsetIndex+1:]...)
+				}
+				owner.RemoveOwnerReference(existing_dr, someApp)
+
+				// last canary, delete canary dr, not changed by others since read
+				if len(existing_dr.Spec.Subsets) == 0 {
+					drObj, err := toVersion(si.APIVersion, existing_dr)
+					if err != nil {
+						return err
+					}
+					resourceVersion := existing_dr.ResourceVersion
+					if err := c.Delete(ctx, drObj, pkgClient.Preconditions{ResourceVersion: &resourceVersion}); pkgClient.IgnoreNotFound(err) != nil {
+						return err
+					}
+					log.Info("canary dr deleted", "dr_name", existing_dr.Name, "dr_namespace", existing_dr.Namespace)
+					return nil
+				}
+
+			case subsetIndex >= 0:
+				existing_dr.Spec.Subsets[subsetIndex].TrafficPolicy = tp
+
+			default:
+				existing_dr.Spec.Subsets = append(existing_dr.Spec.Subsets, &istio_api_network_v1.Subset{
+					Labels: map[string]string{
+						"version": someApp.Spec.AppVersion,
+					},
+					Name:          si.subsetName,
+					TrafficPolicy: tp,
+				})
+
+				if err := controllerutil.SetOwnerReference(someApp, existing_dr, scheme); err != nil {
+					return err
+				}
+			}
+
+			// create, or apply with resourceVersion read, conflict if changed by others
+			drObj, err := toVersion(si.APIVersion, dr)
+			if err != nil {
+				return err
+			}
+			if !drExisting {
+				if err := c.Create(ctx, drObj, pkgClient.FieldOwner(apply.FieldManager)); err != nil {
+					return err
+				}
+				op_dr = controllerutil.OperationResultCreated
+				return nil
+			}
+			op_dr, err = apply.Apply(ctx, c, drObj, si.Drifts)
+			return err
+		})
+		if err != nil {
 			return err
 		}
 
-		log.Info("canary dr reconcile success", "operation_result", controllerutil.OperationResultUpdated)
+		metrics.ObserveOperation(dryrun.Enabled(c), "DestinationRule", op_dr)
+		log.Info("canary dr reconcile success", "operation_result", op_dr)
 	}
 
 	return nil
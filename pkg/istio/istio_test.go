@@ -0,0 +1,168 @@
+package istio
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	istio_api_network_v1 "istio.io/api/networking/v1"
+	istio_network_v1 "istio.io/client-go/pkg/apis/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	opsv1 "github.com/changqings/some-app-operator/api/v1"
+)
+
+func TestCanaryVsConflictRetry(t *testing.T) {
+	tests := []struct {
+		name string
+		// concurrent writes of another canary before applies, by attempt
+		concurrentWrites int
+		wantApplies      int
+		wantConflict     bool
+	}{
+		{name: "no conflict", wantApplies: 1},
+		{name: "re-read after another canary changed vs", concurrentWrites: 1, wantApplies: 2},
+		{name: "re-read after many changes", concurrentWrites: 3, wantApplies: 4},
+		{name: "give up after backoff", concurrentWrites: conflictBackoff.Steps, wantApplies: conflictBackoff.Steps, wantConflict: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			stable := someApp("default", "app-a", nil)
+			canary := someApp("default", "app-a", nil)
+			canary.Name, canary.Spec.AppVersion, canary.UID = "app-a-canary", "canary-v1", "uid-canary"
+
+			for _, apiVersion := range []string{APIVersionV1, APIVersionV1beta1} {
+				applies, writes := 0, 0
+				c := applyAsCreate(fake.NewClientBuilder().WithScheme(testScheme()).WithObjects(stable.DeepCopy(), canary.DeepCopy()),
+					func(ctx context.Context, c client.WithWatch, obj client.Object) {
+						if obj.GetName() != "app-a" || obj.GetObjectKind().GroupVersionKind().Kind != "VirtualService" || len(obj.GetResourceVersion()) == 0 {
+							return
+						}
+						applies++
+						if writes >= tt.concurrentWrites {
+							return
+						}
+						writes++
+						// another canary adds its route between read and apply
+						vs := &istio_network_v1.VirtualService{}
+						if err := getObject(ctx, c, apiVersion, client.ObjectKeyFromObject(obj), vs); err != nil {
+							t.Fatal(err)
+						}
+						vs.Spec.Http = append([]*istio_api_network_v1.HTTPRoute{{Name: "app-a-other"}}, vs.Spec.Http...)
+						vsObj, err := toVersion(apiVersion, vs)
+						if err != nil {
+							t.Fatal(err)
+						}
+						if err := c.Update(ctx, vsObj); err != nil {
+							t.Fatal(err)
+						}
+					})
+
+				si := &SomeIstio{Stage: opsv1.StableStage, APIVersion: apiVersion}
+				if err := si.Reconcile(ctx, stable, c, c.Scheme(), logr.Discard()); err != nil {
+					t.Fatal(err)
+				}
+				applies = 0
+
+				si = &SomeIstio{Stage: opsv1.CanaryStage, APIVersion: apiVersion}
+				err := si.Reconcile(ctx, canary, c, c.Scheme(), logr.Discard())
+				if tt.wantConflict {
+					if !apierrors.IsConflict(err) {
+						t.Fatalf("%s: Reconcile() error = %v, want conflict", apiVersion, err)
+					}
+				} else if err != nil {
+					t.Fatalf("%s: Reconcile() error = %v", apiVersion, err)
+				}
+				if applies != tt.wantApplies {
+					t.Errorf("%s: applies = %d, want %d", apiVersion, applies, tt.wantApplies)
+				}
+				if tt.wantConflict {
+					continue
+				}
+
+				// routes of other canaries kept, canary route before stable route
+				vs := &istio_network_v1.VirtualService{}
+				if err := getObject(ctx, c, apiVersion, client.ObjectKey{Namespace: "default", Name: "app-a"}, vs); err != nil {
+					t.Fatal(err)
+				}
+				var names []string
+				for _, route := range vs.Spec.Http {
+					names = append(names, route.Name)
+				}
+				if len(names) != writes+2 || names[len(names)-2] != "app-a-canary-v1" || names[len(names)-1] != "app-a-stable" {
+					t.Errorf("%s: http routes = %v, want %d others, canary and stable", apiVersion, names, writes)
+				}
+				if len(vs.Spec.Hosts) == 0 {
+					t.Errorf("%s: hosts of stable removed by canary apply", apiVersion)
+				}
+			}
+		})
+	}
+}
+
+func TestCanaryMatchFollowsStable(t *testing.T) {
+	ctx := context.Background()
+	stable := someApp("default", "app-a", nil)
+	canary := someApp("default", "app-a", nil)
+	canary.Name, canary.Spec.AppVersion, canary.UID = "app-a-canary", "canary-v1", "uid-canary"
+	c := applyAsCreate(fake.NewClientBuilder().WithScheme(testScheme()).WithObjects(stable.DeepCopy(), canary.DeepCopy()))
+	key := client.ObjectKey{Namespace: "default", Name: "app-a"}
+
+	reconcile := func(someApp *opsv1.Someapp, stage string) {
+		si := &SomeIstio{Stage: stage, APIVersion: APIVersionV1}
+		if err := si.Reconcile(ctx, someApp, c, c.Scheme(), logr.Discard()); err != nil {
+			t.Fatalf("%s Reconcile() error = %v", stage, err)
+		}
+	}
+	// match of canary route same as stable route
+	checkMatch := func(step string) {
+		vs := &istio_network_v1.VirtualService{}
+		if err := getObject(ctx, c, APIVersionV1, key, vs); err != nil {
+			t.Fatal(err)
+		}
+		routes := map[string]*istio_api_network_v1.HTTPRoute{}
+		for _, route := range vs.Spec.Http {
+			routes[route.Name] = route
+		}
+		stableRoute, canaryRoute := routes["app-a-stable"], routes["app-a-canary-v1"]
+		if stableRoute == nil || canaryRoute == nil {
+			t.Fatalf("%s: http routes %v, want stable and canary", step, vs.Spec.Http)
+		}
+		if len(stableRoute.Match) == 0 || len(canaryRoute.Match) != len(stableRoute.Match) {
+			t.Fatalf("%s: canary match %v, want %v", step, canaryRoute.Match, stableRoute.Match)
+		}
+		for i := range stableRoute.Match {
+			if canaryRoute.Match[i].String() != stableRoute.Match[i].String() {
+				t.Errorf("%s: canary match %v, want %v", step, canaryRoute.Match[i], stableRoute.Match[i])
+			}
+		}
+	}
+
+	reconcile(stable, opsv1.StableStage)
+	reconcile(canary, opsv1.CanaryStage)
+
+	// exposed after canary added, stable reconcile updates canary route
+	stable.Spec.Expose = &opsv1.SomeExpose{Hosts: []string{"app.example.com"}, Gateway: "istio-system/ingressgateway", Paths: []string{"/a"}}
+	reconcile(stable, opsv1.StableStage)
+	checkMatch("stable exposed")
+
+	// stale canary match, like written by an older version, rebuilt by canary reconcile
+	vs := &istio_network_v1.VirtualService{}
+	if err := getObject(ctx, c, APIVersionV1, key, vs); err != nil {
+		t.Fatal(err)
+	}
+	for _, route := range vs.Spec.Http {
+		if route.Name == "app-a-canary-v1" {
+			route.Match = nil
+		}
+	}
+	if err := c.Update(ctx, vs); err != nil {
+		t.Fatal(err)
+	}
+	reconcile(canary, opsv1.CanaryStage)
+	checkMatch("canary reconciled")
+}
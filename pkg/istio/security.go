@@ -0,0 +1,254 @@
+package istio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	pkgClient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	opsv1 "github.com/changqings/some-app-operator/api/v1"
+	"github.com/changqings/some-app-operator/pkg/apply"
+	"github.com/changqings/some-app-operator/pkg/deployment"
+	"github.com/changqings/some-app-operator/pkg/dryrun"
+	"github.com/changqings/some-app-operator/pkg/metrics"
+	"github.com/changqings/some-app-operator/pkg/owner"
+	"github.com/changqings/some-app-operator/pkg/serviceaccount"
+	"github.com/go-logr/logr"
+
+	istio_api_security_v1beta1 "istio.io/api/security/v1beta1"
+	istio_api_type_v1beta1 "istio.io/api/type/v1beta1"
+	istio_security_v1beta1 "istio.io/client-go/pkg/apis/security/v1beta1"
+)
+
+// DefaultTrustDomain of istio, used in principals when SomeSecurity.TrustDomain not set
+const DefaultTrustDomain = "cluster.local"
+
+// ErrNoCallers no caller of spec.istio.security resolved, authorizationpolicy with no rules
+// is applied to deny all traffic, callers created later will enqueue the someapp
+var ErrNoCallers = errors.New("no callers resolved")
+
+// SomeSecurity create peerauthentication and authorizationpolicy of spec.istio.security,
+// select pods with label app, so stable and canary pods share them,
+// only stable someapp create them, not wanted ones will be deleted
+type SomeSecurity struct {
+	Stage string
+	// drifts found when apply, nil to ignore
+	Drifts *apply.Drifts
+	// trust domain of mesh, meshConfig.trustDomain, default DefaultTrustDomain
+	TrustDomain string
+}
+
+func (ss *SomeSecurity) Reconcile(ctx context.Context, someApp *opsv1.Someapp, c pkgClient.Client, scheme *runtime.Scheme, log logr.Logger) error {
+
+	if ss.Stage != opsv1.StableStage {
+		return nil
+	}
+
+	// enableIstio turned off, delete created ones
+	var security *opsv1.SomeIstioSecurity
+	if someApp.Spec.EnableIstio && someApp.Spec.Istio != nil {
+		security = someApp.Spec.Istio.Security
+	}
+
+	selector := &istio_api_type_v1beta1.WorkloadSelector{
+		MatchLabels: map[string]string{"app": someApp.Spec.AppName},
+	}
+	labels := map[string]string{
+		"app":   someApp.Spec.AppName,
+		"type":  someApp.Spec.AppType,
+		"stage": ss.Stage,
+	}
+
+	pa := &istio_security_v1beta1.PeerAuthentication{ObjectMeta: meta_v1.ObjectMeta{
+		Name:      someApp.Spec.AppName,
+		Namespace: someApp.Namespace,
+	}}
+
+	if security == nil || len(security.MTLSMode) == 0 {
+		if err := deleteOwned(ctx, someApp, c, pa, log); err != nil {
+			return err
+		}
+	} else {
+		pa.ObjectMeta.Labels = labels
+		pa.Spec = istio_api_security_v1beta1.PeerAuthentication{
+			Selector: selector,
+			Mtls: &istio_api_security_v1beta1.PeerAuthentication_MutualTLS{
+				Mode: istio_api_security_v1beta1.PeerAuthentication_MutualTLS_Mode(
+					istio_api_security_v1beta1.PeerAuthentication_MutualTLS_Mode_value[security.MTLSMode]),
+			},
+		}
+		if err := controllerutil.SetOwnerReference(someApp, pa, scheme); err != nil {
+			return err
+		}
+
+		op, err := apply.Apply(ctx, c, pa, ss.Drifts)
+		if err != nil {
+			return err
+		}
+		metrics.ObserveOperation(dryrun.Enabled(c), "PeerAuthentication", op)
+		log.Info("peerauthentication reconcile success", "operation_result", op)
+	}
+
+	ap := &istio_security_v1beta1.AuthorizationPolicy{ObjectMeta: meta_v1.ObjectMeta{
+		Name:      someApp.Spec.AppName,
+		Namespace: someApp.Namespace,
+	}}
+
+	if security == nil || (len(security.Apps) == 0 && len(security.Namespaces) == 0 && len(security.Principals) == 0) {
+		return deleteOwned(ctx, someApp, c, ap, log)
+	}
+
+	trustDomain := ss.TrustDomain
+	if len(trustDomain) == 0 {
+		trustDomain = DefaultTrustDomain
+	}
+	principals, err := callerPrincipals(ctx, someApp, c, trustDomain, security.Apps)
+	if err != nil {
+		return err
+	}
+	principals = append(principals, security.Principals...)
+
+	// from sources are ORed
+	var from []*istio_api_security_v1beta1.Rule_From
+	if len(principals) > 0 {
+		from = append(from, &istio_api_security_v1beta1.Rule_From{
+			Source: &istio_api_security_v1beta1.Source{Principals: principals},
+		})
+	}
+	if len(security.Namespaces) > 0 {
+		from = append(from, &istio_api_security_v1beta1.Rule_From{
+			Source: &istio_api_security_v1beta1.Source{Namespaces: security.Namespaces},
+		})
+	}
+
+	// allow with no rules deny all, so callers removed or deleted lose access
+	// instead of keeping the existing policy
+	var rules []*istio_api_security_v1beta1.Rule
+	if len(from) > 0 {
+		rules = []*istio_api_security_v1beta1.Rule{{From: from}}
+	}
+
+	ap.ObjectMeta.Labels = labels
+	ap.Spec = istio_api_security_v1beta1.AuthorizationPolicy{
+		Selector: selector,
+		Action:   istio_api_security_v1beta1.AuthorizationPolicy_ALLOW,
+		Rules:    rules,
+	}
+	if err := controllerutil.SetOwnerReference(someApp, ap, scheme); err != nil {
+		return err
+	}
+
+	op, err := apply.Apply(ctx, c, ap, ss.Drifts)
+	if err != nil {
+		return err
+	}
+	metrics.ObserveOperation(dryrun.Enabled(c), "AuthorizationPolicy", op)
+	log.Info("authorizationpolicy reconcile success", "operation_result", op)
+
+	if len(rules) == 0 {
+		return fmt.Errorf("%w, apps %s not found, authorizationpolicy deny all", ErrNoCallers, strings.Join(security.Apps, ", "))
+	}
+	return nil
+}
+
+// Callers return namespace/app of spec.istio.security.apps, for index someapps by callers
+func Callers(someApp *opsv1.Someapp) []string {
+	if someApp.Spec.Istio == nil || someApp.Spec.Istio.Security == nil {
+		return nil
+	}
+	callers := make([]string, 0, len(someApp.Spec.Istio.Security.Apps))
+	for _, app := range someApp.Spec.Istio.Security.Apps {
+		ns, name := callerKey(someApp, app)
+		callers = append(callers, ns+"/"+name)
+	}
+	return callers
+}
+
+// callerKey return namespace and name of app like app-a in same namespace, or ns-b/app-b
+func callerKey(someApp *opsv1.Someapp, app string) (string, string) {
+	if ns, name, found := strings.Cut(app, "/"); found {
+		return ns, name
+	}
+	return someApp.Namespace, app
+}
+
+// callerPrincipals return principals of serviceaccounts used by all someapps of apps
+func callerPrincipals(ctx context.Context, someApp *opsv1.Someapp, c pkgClient.Client, trustDomain string, apps []string) ([]string, error) {
+	principalSet := map[string]struct{}{}
+
+	for _, app := range apps {
+		ns, app := callerKey(someApp, app)
+
+		someAppList := &opsv1.SomeappList{}
+		if err := c.List(ctx, someAppList, pkgClient.InNamespace(ns)); err != nil {
+			return nil, err
+		}
+
+		for i := range someAppList.Items {
+			caller := &someAppList.Items[i]
+			if caller.Spec.AppName != app {
+				continue
+			}
+			sa := serviceaccount.Name(deployment.Name(caller), caller.Spec.ServiceAccount)
+			if len(sa) == 0 {
+				sa = "default"
+			}
+			principalSet[trustDomain+"/ns/"+ns+"/sa/"+sa] = struct{}{}
+		}
+	}
+
+	principals := make([]string, 0, len(principalSet))
+	for p := range principalSet {
+		principals = append(principals, p)
+	}
+	sort.Strings(principals)
+	return principals, nil
+}
+
+// HasOwned check stable vs, dr, peerauthentication or authorizationpolicy owned by someApp exist,
+// read by controller from cache, so they are deleted only once enableIstio turned off,
+// not read from api server in each reconcile of someapps never enabled istio
+func HasOwned(ctx context.Context, c pkgClient.Reader, apiVersion string, someApp *opsv1.Someapp) (bool, error) {
+	objs := append(OwnedTypes(apiVersion), &istio_security_v1beta1.PeerAuthentication{}, &istio_security_v1beta1.AuthorizationPolicy{})
+	key := pkgClient.ObjectKey{Namespace: someApp.Namespace, Name: someApp.Spec.AppName}
+	for _, obj := range objs {
+		if err := c.Get(ctx, key, obj); err != nil {
+			if apierrors.IsNotFound(err) || meta.IsNoMatchError(err) {
+				continue
+			}
+			return false, err
+		}
+		if owner.IsOwnedBy(obj, someApp) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// deleteOwned delete obj if it is owned by someApp
+func deleteOwned(ctx context.Context, someApp *opsv1.Someapp, c pkgClient.Client, obj pkgClient.Object, log logr.Logger) error {
+	if err := c.Get(ctx, pkgClient.ObjectKeyFromObject(obj), obj); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	if !owner.IsOwnedBy(obj, someApp) {
+		return nil
+	}
+
+	if err := c.Delete(ctx, obj); pkgClient.IgnoreNotFound(err) != nil {
+		return err
+	}
+	log.Info("istio resource deleted", "name", obj.GetName(), "namespace", obj.GetNamespace())
+	return nil
+}
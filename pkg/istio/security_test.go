@@ -0,0 +1,230 @@
+package istio
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/go-logr/logr"
+	istio_api_security_v1beta1 "istio.io/api/security/v1beta1"
+	istio_network_v1 "istio.io/client-go/pkg/apis/networking/v1"
+	istio_network_v1beta1 "istio.io/client-go/pkg/apis/networking/v1beta1"
+	istio_security_v1beta1 "istio.io/client-go/pkg/apis/security/v1beta1"
+	core_v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	opsv1 "github.com/changqings/some-app-operator/api/v1"
+)
+
+func testScheme() *runtime.Scheme {
+	s := runtime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(s))
+	utilruntime.Must(istio_network_v1.AddToScheme(s))
+	utilruntime.Must(istio_network_v1beta1.AddToScheme(s))
+	utilruntime.Must(istio_security_v1beta1.AddToScheme(s))
+	utilruntime.Must(opsv1.AddToScheme(s))
+	return s
+}
+
+// applyAsCreate build fake client, server-side apply not supported by it, done as create or merge patch,
+// beforeApply if not nil run before each apply
+func applyAsCreate(b *fake.ClientBuilder, beforeApply ...func(ctx context.Context, c client.WithWatch, obj client.Object)) client.Client {
+	return b.WithInterceptorFuncs(interceptor.Funcs{
+		Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+			if patch.Type() != types.ApplyPatchType {
+				return c.Patch(ctx, obj, patch, opts...)
+			}
+			for _, f := range beforeApply {
+				f(ctx, c, obj)
+			}
+			existing := &unstructured.Unstructured{}
+			existing.SetGroupVersionKind(obj.GetObjectKind().GroupVersionKind())
+			if err := c.Get(ctx, client.ObjectKeyFromObject(obj), existing); err != nil {
+				if apierrors.IsNotFound(err) {
+					return c.Create(ctx, obj)
+				}
+				return err
+			}
+			// merged, fields not applied kept and lists replaced, close to apply of atomic lists,
+			// resourceVersion applied is precondition, like api server
+			data, err := json.Marshal(obj)
+			if err != nil {
+				return err
+			}
+			return c.Patch(ctx, obj, client.RawPatch(types.MergePatchType, data))
+		},
+	}).Build()
+}
+
+func someApp(namespace, name string, security *opsv1.SomeIstioSecurity) *opsv1.Someapp {
+	return &opsv1.Someapp{
+		ObjectMeta: meta_v1.ObjectMeta{Name: name, Namespace: namespace, UID: types.UID("uid-" + name)},
+		Spec: opsv1.SomeappSpec{
+			AppName:     name,
+			AppType:     opsv1.AppTypeApi,
+			AppVersion:  opsv1.StableStage,
+			EnableIstio: true,
+			Istio:       &opsv1.SomeIstioConfig{Security: security},
+			Containers: []core_v1.Container{{
+				Name:  "app",
+				Image: "nginx",
+				Ports: []core_v1.ContainerPort{{Name: "http", ContainerPort: 80}},
+			}},
+		},
+	}
+}
+
+func TestCallers(t *testing.T) {
+	target := someApp("ns-a", "target", &opsv1.SomeIstioSecurity{Apps: []string{"app-a", "ns-b/app-b"}})
+	got := Callers(target)
+	want := []string{"ns-a/app-a", "ns-b/app-b"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("Callers() = %v, want %v", got, want)
+	}
+	if got := Callers(someApp("ns-a", "no-security", nil)); len(got) != 0 {
+		t.Errorf("Callers() = %v, want empty", got)
+	}
+}
+
+func TestSecurityPrincipals(t *testing.T) {
+	caller := someApp("ns-b", "app-b", nil)
+	caller.Spec.ServiceAccount = &opsv1.SomeServiceAccount{Name: "sa-b"}
+
+	tests := []struct {
+		name           string
+		security       *opsv1.SomeIstioSecurity
+		trustDomain    string
+		wantErr        error
+		wantPrincipals []string
+	}{
+		{
+			name:           "caller resolved with default trust domain",
+			security:       &opsv1.SomeIstioSecurity{Apps: []string{"ns-b/app-b"}},
+			wantPrincipals: []string{"cluster.local/ns/ns-b/sa/sa-b"},
+		},
+		{
+			name:           "caller resolved with trust domain",
+			security:       &opsv1.SomeIstioSecurity{Apps: []string{"ns-b/app-b"}},
+			trustDomain:    "prod.example.com",
+			wantPrincipals: []string{"prod.example.com/ns/ns-b/sa/sa-b"},
+		},
+		{
+			name:     "no caller resolved",
+			security: &opsv1.SomeIstioSecurity{Apps: []string{"app-missing"}},
+			wantErr:  ErrNoCallers,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := someApp("ns-a", "target", tt.security)
+			c := applyAsCreate(fake.NewClientBuilder().WithScheme(testScheme()).WithObjects(caller, target))
+
+			ss := SomeSecurity{Stage: opsv1.StableStage, TrustDomain: tt.trustDomain}
+			err := ss.Reconcile(context.Background(), target, c, c.Scheme(), logr.Discard())
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Reconcile() error = %v, want %v", err, tt.wantErr)
+			}
+
+			ap := &istio_security_v1beta1.AuthorizationPolicy{}
+			getErr := c.Get(context.Background(), client.ObjectKey{Namespace: "ns-a", Name: "target"}, ap)
+			if getErr != nil {
+				t.Fatal(getErr)
+			}
+			if tt.wantErr != nil {
+				if len(ap.Spec.Rules) > 0 {
+					t.Errorf("authorizationpolicy with no callers allow %v, want deny all", ap.Spec.Rules)
+				}
+				return
+			}
+			got := ap.Spec.Rules[0].From[0].Source.Principals
+			if len(got) != len(tt.wantPrincipals) || got[0] != tt.wantPrincipals[0] {
+				t.Errorf("principals = %v, want %v", got, tt.wantPrincipals)
+			}
+		})
+	}
+}
+
+func TestSecurityCallersRemoved(t *testing.T) {
+	caller := someApp("ns-a", "app-b", nil)
+	target := someApp("ns-a", "target", &opsv1.SomeIstioSecurity{Apps: []string{"app-b"}})
+	// apply remove fields no longer applied, merge patch of fake client keep them, so replaced
+	replaceAuthorizationPolicy := func(ctx context.Context, c client.WithWatch, obj client.Object) {
+		if obj.GetObjectKind().GroupVersionKind().Kind == "AuthorizationPolicy" {
+			_ = c.Delete(ctx, &istio_security_v1beta1.AuthorizationPolicy{ObjectMeta: meta_v1.ObjectMeta{Name: obj.GetName(), Namespace: obj.GetNamespace()}})
+		}
+	}
+	c := applyAsCreate(fake.NewClientBuilder().WithScheme(testScheme()).WithObjects(caller, target), replaceAuthorizationPolicy)
+	ss := SomeSecurity{Stage: opsv1.StableStage}
+	key := client.ObjectKey{Namespace: "ns-a", Name: "target"}
+
+	if err := ss.Reconcile(context.Background(), target, c, c.Scheme(), logr.Discard()); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	ap := &istio_security_v1beta1.AuthorizationPolicy{}
+	if err := c.Get(context.Background(), key, ap); err != nil {
+		t.Fatal(err)
+	}
+	if len(ap.Spec.Rules) != 1 {
+		t.Fatalf("rules = %v, want caller allowed", ap.Spec.Rules)
+	}
+
+	// caller deleted, its access removed instead of existing policy kept
+	if err := c.Delete(context.Background(), caller); err != nil {
+		t.Fatal(err)
+	}
+	if err := ss.Reconcile(context.Background(), target, c, c.Scheme(), logr.Discard()); !errors.Is(err, ErrNoCallers) {
+		t.Fatalf("Reconcile() error = %v, want %v", err, ErrNoCallers)
+	}
+	ap = &istio_security_v1beta1.AuthorizationPolicy{}
+	if err := c.Get(context.Background(), key, ap); err != nil {
+		t.Fatal(err)
+	}
+	if len(ap.Spec.Rules) > 0 || ap.Spec.Action != istio_api_security_v1beta1.AuthorizationPolicy_ALLOW {
+		t.Errorf("authorizationpolicy %v %v, want allow nothing", ap.Spec.Action, ap.Spec.Rules)
+	}
+}
+
+func TestHasOwned(t *testing.T) {
+	app := someApp("default", "app-a", nil)
+	ownerRef := meta_v1.OwnerReference{APIVersion: opsv1.GroupVersion.String(), Kind: "Someapp", Name: app.Name, UID: app.UID}
+	meta := func(refs ...meta_v1.OwnerReference) meta_v1.ObjectMeta {
+		return meta_v1.ObjectMeta{Name: "app-a", Namespace: "default", OwnerReferences: refs}
+	}
+
+	tests := []struct {
+		name       string
+		apiVersion string
+		objs       []client.Object
+		want       bool
+	}{
+		{name: "none", apiVersion: APIVersionV1},
+		{name: "not owned vs", apiVersion: APIVersionV1, objs: []client.Object{&istio_network_v1.VirtualService{ObjectMeta: meta()}}},
+		{name: "owned v1 dr", apiVersion: APIVersionV1, objs: []client.Object{&istio_network_v1.DestinationRule{ObjectMeta: meta(ownerRef)}}, want: true},
+		{name: "owned v1beta1 vs", apiVersion: APIVersionV1beta1, objs: []client.Object{&istio_network_v1beta1.VirtualService{ObjectMeta: meta(ownerRef)}}, want: true},
+		{name: "owned authorizationpolicy", apiVersion: APIVersionV1, objs: []client.Object{&istio_security_v1beta1.AuthorizationPolicy{ObjectMeta: meta(ownerRef)}}, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := fake.NewClientBuilder().WithScheme(testScheme()).WithObjects(tt.objs...).Build()
+			got, err := HasOwned(context.Background(), c, tt.apiVersion, app)
+			if err != nil {
+				t.Fatalf("HasOwned() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("HasOwned() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package istio
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	opsv1 "github.com/changqings/some-app-operator/api/v1"
+
+	istio_api_network_v1 "istio.io/api/networking/v1"
+)
+
+// trafficPolicy build dr traffic policy from spec.istio.trafficPolicy, nil if not set
+func trafficPolicy(someApp *opsv1.Someapp) (*istio_api_network_v1.TrafficPolicy, error) {
+	if someApp.Spec.Istio == nil || someApp.Spec.Istio.TrafficPolicy == nil {
+		return nil, nil
+	}
+	spec := someApp.Spec.Istio.TrafficPolicy
+	tp := &istio_api_network_v1.TrafficPolicy{}
+
+	if pool := spec.ConnectionPool; pool != nil {
+		connectTimeout, err := parseDuration(pool.ConnectTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("spec.istio.trafficPolicy.connectionPool.connectTimeout: %w", err)
+		}
+		idleTimeout, err := parseDuration(pool.IdleTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("spec.istio.trafficPolicy.connectionPool.idleTimeout: %w", err)
+		}
+		tp.ConnectionPool = &istio_api_network_v1.ConnectionPoolSettings{
+			Tcp: &istio_api_network_v1.ConnectionPoolSettings_TCPSettings{
+				MaxConnections: pool.MaxConnections,
+				ConnectTimeout: connectTimeout,
+			},
+			Http: &istio_api_network_v1.ConnectionPoolSettings_HTTPSettings{
+				Http1MaxPendingRequests:  pool.Http1MaxPendingRequests,
+				Http2MaxRequests:         pool.Http2MaxRequests,
+				MaxRequestsPerConnection: pool.MaxRequestsPerConnection,
+				MaxRetries:               pool.MaxRetries,
+				IdleTimeout:              idleTimeout,
+			},
+		}
+	}
+
+	if od := spec.OutlierDetection; od != nil {
+		interval, err := parseDuration(od.Interval)
+		if err != nil {
+			return nil, fmt.Errorf("spec.istio.trafficPolicy.outlierDetection.interval: %w", err)
+		}
+		baseEjectionTime, err := parseDuration(od.BaseEjectionTime)
+		if err != nil {
+			return nil, fmt.Errorf("spec.istio.trafficPolicy.outlierDetection.baseEjectionTime: %w", err)
+		}
+		tp.OutlierDetection = &istio_api_network_v1.OutlierDetection{
+			Interval:           interval,
+			BaseEjectionTime:   baseEjectionTime,
+			MaxEjectionPercent: od.MaxEjectionPercent,
+		}
+		if od.Consecutive5xxErrors != nil {
+			tp.OutlierDetection.Consecutive_5XxErrors = wrapperspb.UInt32(uint32(*od.Consecutive5xxErrors))
+		}
+	}
+
+	if lb := spec.LoadBalancer; lb != nil {
+		// checked by crd rules too, not by api servers without cel validation or offline render
+		if len(lb.Simple) > 0 && lb.ConsistentHash != nil {
+			return nil, fmt.Errorf("spec.istio.trafficPolicy.loadBalancer: only one of simple and consistentHash can be set")
+		}
+		tp.LoadBalancer = &istio_api_network_v1.LoadBalancerSettings{}
+		if len(lb.Simple) > 0 {
+			tp.LoadBalancer.LbPolicy = &istio_api_network_v1.LoadBalancerSettings_Simple{
+				Simple: istio_api_network_v1.LoadBalancerSettings_SimpleLB(
+					istio_api_network_v1.LoadBalancerSettings_SimpleLB_value[lb.Simple]),
+			}
+		}
+		if ch := lb.ConsistentHash; ch != nil {
+			if n := hashKeys(ch); n != 1 {
+				return nil, fmt.Errorf("spec.istio.trafficPolicy.loadBalancer.consistentHash: exactly one hash key must be set, got %d", n)
+			}
+			consistentHash := &istio_api_network_v1.LoadBalancerSettings_ConsistentHashLB{}
+			switch {
+			case len(ch.HttpHeaderName) > 0:
+				consistentHash.HashKey = &istio_api_network_v1.LoadBalancerSettings_ConsistentHashLB_HttpHeaderName{
+					HttpHeaderName: ch.HttpHeaderName,
+				}
+			case ch.HttpCookie != nil:
+				ttl, err := parseDuration(ch.HttpCookie.Ttl)
+				if err != nil {
+					return nil, fmt.Errorf("spec.istio.trafficPolicy.loadBalancer.consistentHash.httpCookie.ttl: %w", err)
+				}
+				consistentHash.HashKey = &istio_api_network_v1.LoadBalancerSettings_ConsistentHashLB_HttpCookie{
+					HttpCookie: &istio_api_network_v1.LoadBalancerSettings_ConsistentHashLB_HTTPCookie{
+						Name: ch.HttpCookie.Name,
+						Path: ch.HttpCookie.Path,
+						Ttl:  ttl,
+					},
+				}
+			case ch.UseSourceIp:
+				consistentHash.HashKey = &istio_api_network_v1.LoadBalancerSettings_ConsistentHashLB_UseSourceIp{
+					UseSourceIp: true,
+				}
+			case len(ch.HttpQueryParameterName) > 0:
+				consistentHash.HashKey = &istio_api_network_v1.LoadBalancerSettings_ConsistentHashLB_HttpQueryParameterName{
+					HttpQueryParameterName: ch.HttpQueryParameterName,
+				}
+			}
+			tp.LoadBalancer.LbPolicy = &istio_api_network_v1.LoadBalancerSettings_ConsistentHash{
+				ConsistentHash: consistentHash,
+			}
+		}
+	}
+
+	if len(spec.TLSMode) > 0 {
+		tp.Tls = &istio_api_network_v1.ClientTLSSettings{
+			Mode: istio_api_network_v1.ClientTLSSettings_TLSmode(
+				istio_api_network_v1.ClientTLSSettings_TLSmode_value[spec.TLSMode]),
+		}
+	}
+
+	return tp, nil
+}
+
+// hashKeys count hash keys set in consistentHash
+func hashKeys(ch *opsv1.SomeIstioConsistentHash) int {
+	n := 0
+	for _, set := range []bool{len(ch.HttpHeaderName) > 0, ch.HttpCookie != nil, ch.UseSourceIp, len(ch.HttpQueryParameterName) > 0} {
+		if set {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,58 @@
+package istio
+
+import (
+	"testing"
+
+	istio_api_network_v1 "istio.io/api/networking/v1"
+
+	opsv1 "github.com/changqings/some-app-operator/api/v1"
+)
+
+func TestTrafficPolicyLoadBalancer(t *testing.T) {
+	tests := []struct {
+		name    string
+		lb      *opsv1.SomeIstioLoadBalancer
+		wantErr bool
+		check   func(*istio_api_network_v1.LoadBalancerSettings) bool
+	}{
+		{
+			name: "simple",
+			lb:   &opsv1.SomeIstioLoadBalancer{Simple: "ROUND_ROBIN"},
+			check: func(lb *istio_api_network_v1.LoadBalancerSettings) bool {
+				return lb.GetSimple() == istio_api_network_v1.LoadBalancerSettings_ROUND_ROBIN
+			},
+		},
+		{
+			name: "consistent hash",
+			lb:   &opsv1.SomeIstioLoadBalancer{ConsistentHash: &opsv1.SomeIstioConsistentHash{HttpHeaderName: "x-user"}},
+			check: func(lb *istio_api_network_v1.LoadBalancerSettings) bool {
+				return lb.GetConsistentHash().GetHttpHeaderName() == "x-user"
+			},
+		},
+		{
+			name:    "simple and consistent hash",
+			lb:      &opsv1.SomeIstioLoadBalancer{Simple: "RANDOM", ConsistentHash: &opsv1.SomeIstioConsistentHash{UseSourceIp: true}},
+			wantErr: true,
+		},
+		{name: "no hash key", lb: &opsv1.SomeIstioLoadBalancer{ConsistentHash: &opsv1.SomeIstioConsistentHash{}}, wantErr: true},
+		{
+			name:    "two hash keys",
+			lb:      &opsv1.SomeIstioLoadBalancer{ConsistentHash: &opsv1.SomeIstioConsistentHash{UseSourceIp: true, HttpQueryParameterName: "u"}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := someApp("default", "app-a", nil)
+			app.Spec.Istio.TrafficPolicy = &opsv1.SomeIstioTrafficPolicy{LoadBalancer: tt.lb}
+			tp, err := trafficPolicy(app)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("trafficPolicy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !tt.check(tp.LoadBalancer) {
+				t.Errorf("loadBalancer = %v", tp.LoadBalancer)
+			}
+		})
+	}
+}
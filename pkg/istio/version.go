@@ -0,0 +1,136 @@
+package istio
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	pkgClient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	istio_network_v1 "istio.io/client-go/pkg/apis/networking/v1"
+	istio_network_v1beta1 "istio.io/client-go/pkg/apis/networking/v1beta1"
+
+	"github.com/changqings/some-app-operator/pkg/apply"
+	"github.com/changqings/some-app-operator/pkg/owner"
+)
+
+// networking.istio.io versions, v1 is GA since istio 1.22
+const (
+	APIVersionV1      = "v1"
+	APIVersionV1beta1 = "v1beta1"
+)
+
+// DetectAPIVersion return v1 if the control plane serve networking.istio.io/v1, or v1beta1
+func DetectAPIVersion(mapper meta.RESTMapper) string {
+	gk := schema.GroupKind{Group: istio_network_v1.SchemeGroupVersion.Group, Kind: "VirtualService"}
+	if _, err := mapper.RESTMapping(gk, APIVersionV1); err == nil {
+		return APIVersionV1
+	}
+	return APIVersionV1beta1
+}
+
+// OwnedTypes return vs and dr types of apiVersion, for controller to watch
+func OwnedTypes(apiVersion string) []pkgClient.Object {
+	if apiVersion == APIVersionV1 {
+		return []pkgClient.Object{&istio_network_v1.DestinationRule{}, &istio_network_v1.VirtualService{}}
+	}
+	return []pkgClient.Object{&istio_network_v1beta1.DestinationRule{}, &istio_network_v1beta1.VirtualService{}}
+}
+
+// MigrateOwned rewrite vs and dr owned by someapps through v1, so objects created by v1beta1 before
+// are re-stored with current storage version, and fields applied as v1beta1 are moved to v1 of
+// the same field manager, run once after v1 detected
+func MigrateOwned(ctx context.Context, c pkgClient.Client, log logr.Logger) error {
+	vsList := &istio_network_v1.VirtualServiceList{}
+	if err := c.List(ctx, vsList); err != nil {
+		return err
+	}
+	drList := &istio_network_v1.DestinationRuleList{}
+	if err := c.List(ctx, drList); err != nil {
+		return err
+	}
+
+	objs := make([]pkgClient.Object, 0, len(vsList.Items)+len(drList.Items))
+	for _, vs := range vsList.Items {
+		objs = append(objs, vs)
+	}
+	for _, dr := range drList.Items {
+		objs = append(objs, dr)
+	}
+
+	for _, obj := range objs {
+		if !owner.IsManaged(obj) {
+			continue
+		}
+		if err := apply.MigrateAPIVersion(ctx, c, obj); pkgClient.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("migrate %T %s/%s: %w", obj, obj.GetNamespace(), obj.GetName(), err)
+		}
+		log.Info("istio object migrated to v1", "kind", fmt.Sprintf("%T", obj), "name", obj.GetName(), "namespace", obj.GetNamespace())
+	}
+	return nil
+}
+
+// vs and dr are built as v1, and read or written as apiVersion by client,
+// v1 and v1beta1 have the same schema, so converted by json
+
+// toVersion return v1 vs or dr obj as object of apiVersion for client
+func toVersion(apiVersion string, obj pkgClient.Object) (pkgClient.Object, error) {
+	if apiVersion == APIVersionV1 {
+		return obj, nil
+	}
+
+	var versioned pkgClient.Object
+	switch obj.(type) {
+	case *istio_network_v1.VirtualService:
+		versioned = &istio_network_v1beta1.VirtualService{}
+	case *istio_network_v1.DestinationRule:
+		versioned = &istio_network_v1beta1.DestinationRule{}
+	default:
+		return nil, fmt.Errorf("%T has no version %s", obj, apiVersion)
+	}
+	return versioned, convert(obj, versioned)
+}
+
+// getObject read vs or dr of key as apiVersion into v1 obj
+func getObject(ctx context.Context, c pkgClient.Reader, apiVersion string, key pkgClient.ObjectKey, obj pkgClient.Object) error {
+	versioned, err := toVersion(apiVersion, obj)
+	if err != nil {
+		return err
+	}
+	if err := c.Get(ctx, key, versioned); err != nil {
+		return err
+	}
+	return convert(versioned, obj)
+}
+
+// adoptObject read vs or dr of key as apiVersion into v1 obj, checked by adoption like Adoption.Get
+func adoptObject(ctx context.Context, a *owner.Adoption, c pkgClient.Client, apiVersion string, key pkgClient.ObjectKey, obj pkgClient.Object) (found, adopted bool, err error) {
+	versioned, err := toVersion(apiVersion, obj)
+	if err != nil {
+		return false, false, err
+	}
+	found, adopted, err = a.Get(ctx, c, key, versioned)
+	if err != nil || !found {
+		return found, adopted, err
+	}
+	return found, adopted, convert(versioned, obj)
+}
+
+// convert in to out of another version, type meta not kept, as typed objects of client
+func convert(in, out pkgClient.Object) error {
+	if in == out {
+		return nil
+	}
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return err
+	}
+	out.GetObjectKind().SetGroupVersionKind(schema.GroupVersionKind{})
+	return nil
+}
@@ -0,0 +1,90 @@
+package istio
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	istio_network_v1 "istio.io/client-go/pkg/apis/networking/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	opsv1 "github.com/changqings/some-app-operator/api/v1"
+	"github.com/changqings/some-app-operator/pkg/apply"
+)
+
+func TestMigrateOwned(t *testing.T) {
+	const v1, v1beta1 = "networking.istio.io/v1", "networking.istio.io/v1beta1"
+	entry := func(manager, apiVersion, fields string) meta_v1.ManagedFieldsEntry {
+		return meta_v1.ManagedFieldsEntry{Manager: manager, Operation: meta_v1.ManagedFieldsOperationApply, APIVersion: apiVersion,
+			FieldsType: "FieldsV1", FieldsV1: &meta_v1.FieldsV1{Raw: []byte(fields)}}
+	}
+	app := someApp("default", "app-a", nil)
+	ownerRef := meta_v1.OwnerReference{APIVersion: opsv1.GroupVersion.String(), Kind: "Someapp", Name: app.Name, UID: app.UID}
+
+	tests := []struct {
+		name   string
+		owned  bool
+		fields []meta_v1.ManagedFieldsEntry
+		want   map[string]string // manager/apiVersion to fields
+	}{
+		{
+			name:  "owned, operator fields moved, others kept",
+			owned: true,
+			fields: []meta_v1.ManagedFieldsEntry{
+				entry(apply.FieldManager, v1beta1, `{"f:spec":{"f:hosts":{}}}`),
+				entry(apply.CanaryFieldManager, v1beta1, `{"f:spec":{"f:http":{}}}`),
+				entry("kubectl", v1beta1, `{"f:metadata":{"f:labels":{"f:a":{}}}}`),
+			},
+			want: map[string]string{
+				apply.FieldManager + "/" + v1:       `{"f:spec":{"f:hosts":{}}}`,
+				apply.CanaryFieldManager + "/" + v1: `{"f:spec":{"f:http":{}}}`,
+				"kubectl/" + v1beta1:                `{"f:metadata":{"f:labels":{"f:a":{}}}}`,
+			},
+		},
+		{
+			name:  "owned, both versions merged",
+			owned: true,
+			fields: []meta_v1.ManagedFieldsEntry{
+				entry(apply.FieldManager, v1beta1, `{"f:spec":{"f:hosts":{}}}`),
+				entry(apply.FieldManager, v1, `{"f:spec":{"f:gateways":{}}}`),
+			},
+			want: map[string]string{
+				apply.FieldManager + "/" + v1: `{"f:spec":{"f:gateways":{},"f:hosts":{}}}`,
+			},
+		},
+		{
+			name:   "not owned",
+			fields: []meta_v1.ManagedFieldsEntry{entry(apply.FieldManager, v1beta1, `{"f:spec":{"f:hosts":{}}}`)},
+			want:   map[string]string{apply.FieldManager + "/" + v1beta1: `{"f:spec":{"f:hosts":{}}}`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vs := &istio_network_v1.VirtualService{ObjectMeta: meta_v1.ObjectMeta{Name: "app-a", Namespace: "default", ManagedFields: tt.fields}}
+			if tt.owned {
+				vs.OwnerReferences = []meta_v1.OwnerReference{ownerRef}
+			}
+			c := fake.NewClientBuilder().WithScheme(testScheme()).WithObjects(vs).Build()
+
+			if err := MigrateOwned(context.Background(), c, logr.Discard()); err != nil {
+				t.Fatalf("MigrateOwned() error = %v", err)
+			}
+
+			got := &istio_network_v1.VirtualService{}
+			if err := c.Get(context.Background(), client.ObjectKeyFromObject(vs), got); err != nil {
+				t.Fatal(err)
+			}
+			if len(got.ManagedFields) != len(tt.want) {
+				t.Fatalf("managedFields = %v, want %v", got.ManagedFields, tt.want)
+			}
+			for _, entry := range got.ManagedFields {
+				if want := tt.want[entry.Manager+"/"+entry.APIVersion]; string(entry.FieldsV1.Raw) != want {
+					t.Errorf("fields of %s/%s = %s, want %s", entry.Manager, entry.APIVersion, entry.FieldsV1.Raw, want)
+				}
+			}
+		})
+	}
+}
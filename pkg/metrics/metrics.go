@@ -0,0 +1,47 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// DriftTotal count child resources changed out of band, and taken back by apply
+var DriftTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "someapp_drift_total",
+	Help: "Number of child resources found changed out of band and reverted, by namespace and kind",
+}, []string{"namespace", "kind"})
+
+// ReconcileDuration of each sub reconciler, like deployment, hpa, istio
+var ReconcileDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "someapp_subreconciler_duration_seconds",
+	Help:    "Duration of sub reconcilers of someapp child resources, by reconciler",
+	Buckets: prometheus.DefBuckets,
+}, []string{"reconciler"})
+
+// ChildOperationTotal count create or update results of child resources
+var ChildOperationTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "someapp_child_operation_total",
+	Help: "Number of child resource reconciles, by kind and result created, updated or unchanged",
+}, []string{"kind", "result"})
+
+// FinalizerCleanupFailureTotal count failed cleanups before finalizers removed,
+// like canary routes removed from stable vs and children orphaned
+var FinalizerCleanupFailureTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "someapp_finalizer_cleanup_failure_total",
+	Help: "Number of failed cleanups of someapp finalizers, by namespace and finalizer",
+}, []string{"namespace", "finalizer"})
+
+func init() {
+	// served on manager metrics endpoint
+	metrics.Registry.MustRegister(DriftTotal, ReconcileDuration, ChildOperationTotal, FinalizerCleanupFailureTotal)
+}
+
+// ObserveOperation count result of a child resource reconciled,
+// not counted in dry-run, nothing changed
+func ObserveOperation(dryRun bool, kind string, op controllerutil.OperationResult) {
+	if dryRun {
+		return
+	}
+	ChildOperationTotal.WithLabelValues(kind, string(op)).Inc()
+}
@@ -0,0 +1,230 @@
+package networkpolicy
+
+import (
+	"context"
+	"strings"
+
+	core_v1 "k8s.io/api/core/v1"
+	networking_v1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	opsv1 "github.com/changqings/some-app-operator/api/v1"
+	"github.com/changqings/some-app-operator/pkg/dryrun"
+	"github.com/changqings/some-app-operator/pkg/istio"
+	"github.com/changqings/some-app-operator/pkg/metrics"
+	"github.com/changqings/some-app-operator/pkg/owner"
+	"github.com/go-logr/logr"
+)
+
+// namespace label set by k8s automatically since 1.21
+const namespaceNameLabel = "kubernetes.io/metadata.name"
+
+const (
+	// istiod of default istio install, sidecars get config and certs by xds on 15012
+	istioNamespace    = "istio-system"
+	istiodXdsPort     = 15012
+	istiodAppLabel    = "istiod"
+	gatewayIstioLabel = "ingressgateway"
+)
+
+// SomeNetworkPolicy create networkpolicy select deployment pods,
+// peers of other someapps select pods with label app=spec.name,
+// spec.network not set, networkpolicy will be deleted
+type SomeNetworkPolicy struct {
+	StandardLabels map[string]string
+}
+
+func (sn *SomeNetworkPolicy) Reconcile(ctx context.Context, someApp *opsv1.Someapp, client client.Client, scheme *runtime.Scheme, log logr.Logger) error {
+
+	network := someApp.Spec.Network
+
+	np := &networking_v1.NetworkPolicy{ObjectMeta: meta_v1.ObjectMeta{
+		Name:      sn.StandardLabels["name"],
+		Namespace: someApp.Namespace,
+	}}
+
+	if network == nil || (network.Ingress == nil && network.Egress == nil) {
+		return sn.delete(ctx, someApp, client, np, log)
+	}
+
+	op, err := controllerutil.CreateOrUpdate(ctx, client, np, func() error {
+		if np.ObjectMeta.CreationTimestamp.IsZero() {
+			np.ObjectMeta.Labels = sn.StandardLabels
+		}
+
+		np.Spec = networking_v1.NetworkPolicySpec{
+			PodSelector: meta_v1.LabelSelector{
+				MatchLabels: map[string]string{
+					"app":  someApp.Spec.AppName,
+					"name": sn.StandardLabels["name"],
+				},
+			},
+		}
+
+		if network.Ingress != nil {
+			np.Spec.PolicyTypes = append(np.Spec.PolicyTypes, networking_v1.PolicyTypeIngress)
+			if peers, ports := rulePeers(network.Ingress), network.Ingress.Ports; len(peers) > 0 || len(ports) > 0 {
+				np.Spec.Ingress = []networking_v1.NetworkPolicyIngressRule{
+					{From: peers, Ports: ports},
+				}
+			}
+			// gateway pods forward to app pods directly, not through declared peers
+			if istio.ExposeByGateway(someApp) {
+				np.Spec.Ingress = append(np.Spec.Ingress, gatewayIngressRule(someApp))
+			}
+		}
+
+		if network.Egress != nil {
+			np.Spec.PolicyTypes = append(np.Spec.PolicyTypes, networking_v1.PolicyTypeEgress)
+			np.Spec.Egress = []networking_v1.NetworkPolicyEgressRule{dnsEgressRule()}
+			// sidecar never ready without istiod
+			if someApp.Spec.EnableIstio {
+				np.Spec.Egress = append(np.Spec.Egress, istiodEgressRule())
+			}
+			if peers, ports := rulePeers(network.Egress), network.Egress.Ports; len(peers) > 0 || len(ports) > 0 {
+				np.Spec.Egress = append(np.Spec.Egress, networking_v1.NetworkPolicyEgressRule{
+					To: peers, Ports: ports,
+				})
+			}
+		}
+
+		// add reference
+		if err := controllerutil.SetOwnerReference(someApp, np, scheme); err != nil {
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	metrics.ObserveOperation(dryrun.Enabled(client), "NetworkPolicy", op)
+	log.Info("networkpolicy reconcile success", "operation_result", op)
+	return nil
+}
+
+// rulePeers convert apps, namespaces and cidrs to networkpolicy peers
+func rulePeers(rule *opsv1.SomeNetworkRule) []networking_v1.NetworkPolicyPeer {
+	peers := make([]networking_v1.NetworkPolicyPeer, 0, len(rule.Apps)+len(rule.Namespaces)+len(rule.CIDRs))
+
+	for _, app := range rule.Apps {
+		peer := networking_v1.NetworkPolicyPeer{
+			PodSelector: &meta_v1.LabelSelector{},
+		}
+		// ns/app, other namespace someapp
+		if ns, name, found := strings.Cut(app, "/"); found {
+			app = name
+			peer.NamespaceSelector = &meta_v1.LabelSelector{
+				MatchLabels: map[string]string{namespaceNameLabel: ns},
+			}
+		}
+		peer.PodSelector.MatchLabels = map[string]string{"app": app}
+		peers = append(peers, peer)
+	}
+
+	for _, ns := range rule.Namespaces {
+		peers = append(peers, networking_v1.NetworkPolicyPeer{
+			NamespaceSelector: &meta_v1.LabelSelector{
+				MatchLabels: map[string]string{namespaceNameLabel: ns},
+			},
+		})
+	}
+
+	for _, cidr := range rule.CIDRs {
+		peers = append(peers, networking_v1.NetworkPolicyPeer{
+			IPBlock: &networking_v1.IPBlock{CIDR: cidr},
+		})
+	}
+
+	return peers
+}
+
+// dnsEgressRule allow dns to kube-system, or nothing can be resolved
+func dnsEgressRule() networking_v1.NetworkPolicyEgressRule {
+	udp, tcp := core_v1.ProtocolUDP, core_v1.ProtocolTCP
+	dnsPort := intstr.FromInt32(53)
+
+	return networking_v1.NetworkPolicyEgressRule{
+		To: []networking_v1.NetworkPolicyPeer{
+			{
+				NamespaceSelector: &meta_v1.LabelSelector{
+					MatchLabels: map[string]string{namespaceNameLabel: "kube-system"},
+				},
+			},
+		},
+		Ports: []networking_v1.NetworkPolicyPort{
+			{Protocol: &udp, Port: &dnsPort},
+			{Protocol: &tcp, Port: &dnsPort},
+		},
+	}
+}
+
+// istiodEgressRule allow sidecar to istiod xds port
+func istiodEgressRule() networking_v1.NetworkPolicyEgressRule {
+	tcp := core_v1.ProtocolTCP
+	xdsPort := intstr.FromInt32(istiodXdsPort)
+
+	return networking_v1.NetworkPolicyEgressRule{
+		To: []networking_v1.NetworkPolicyPeer{
+			{
+				NamespaceSelector: &meta_v1.LabelSelector{
+					MatchLabels: map[string]string{namespaceNameLabel: istioNamespace},
+				},
+				PodSelector: &meta_v1.LabelSelector{
+					MatchLabels: map[string]string{"app": istiodAppLabel},
+				},
+			},
+		},
+		Ports: []networking_v1.NetworkPolicyPort{
+			{Protocol: &tcp, Port: &xdsPort},
+		},
+	}
+}
+
+// gatewayIngressRule allow ingress gateway pods of spec.expose.gateway namespace,
+// gateway without namespace is in the namespace of someapp
+func gatewayIngressRule(someApp *opsv1.Someapp) networking_v1.NetworkPolicyIngressRule {
+	ns := someApp.Namespace
+	if gatewayNs, _, found := strings.Cut(someApp.Spec.Expose.Gateway, "/"); found {
+		ns = gatewayNs
+	}
+
+	return networking_v1.NetworkPolicyIngressRule{
+		From: []networking_v1.NetworkPolicyPeer{
+			{
+				NamespaceSelector: &meta_v1.LabelSelector{
+					MatchLabels: map[string]string{namespaceNameLabel: ns},
+				},
+				PodSelector: &meta_v1.LabelSelector{
+					MatchLabels: map[string]string{"istio": gatewayIstioLabel},
+				},
+			},
+		},
+	}
+}
+
+// delete networkpolicy created by this someApp before
+func (sn *SomeNetworkPolicy) delete(ctx context.Context, someApp *opsv1.Someapp, c client.Client, np *networking_v1.NetworkPolicy, log logr.Logger) error {
+	if err := c.Get(ctx, client.ObjectKeyFromObject(np), np); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	if !owner.IsOwnedBy(np, someApp) {
+		return nil
+	}
+
+	if err := c.Delete(ctx, np); client.IgnoreNotFound(err) != nil {
+		return err
+	}
+	log.Info("networkpolicy deleted", "networkpolicy_name", np.Name)
+	return nil
+}
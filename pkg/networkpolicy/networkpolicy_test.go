@@ -0,0 +1,190 @@
+package networkpolicy
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/go-logr/logr"
+	networking_v1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	opsv1 "github.com/changqings/some-app-operator/api/v1"
+)
+
+func TestRulePeers(t *testing.T) {
+	rule := &opsv1.SomeNetworkRule{
+		Apps:       []string{"app-b", "ns-c/app-c"},
+		Namespaces: []string{"monitoring"},
+		CIDRs:      []string{"10.0.0.0/8"},
+	}
+	want := []networking_v1.NetworkPolicyPeer{
+		{PodSelector: &meta_v1.LabelSelector{MatchLabels: map[string]string{"app": "app-b"}}},
+		{
+			PodSelector:       &meta_v1.LabelSelector{MatchLabels: map[string]string{"app": "app-c"}},
+			NamespaceSelector: &meta_v1.LabelSelector{MatchLabels: map[string]string{namespaceNameLabel: "ns-c"}},
+		},
+		{NamespaceSelector: &meta_v1.LabelSelector{MatchLabels: map[string]string{namespaceNameLabel: "monitoring"}}},
+		{IPBlock: &networking_v1.IPBlock{CIDR: "10.0.0.0/8"}},
+	}
+	if got := rulePeers(rule); !reflect.DeepEqual(got, want) {
+		t.Errorf("rulePeers() = %+v, want %+v", got, want)
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	scheme := runtime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(opsv1.AddToScheme(scheme))
+
+	someApp := &opsv1.Someapp{ObjectMeta: meta_v1.ObjectMeta{Name: "app-a", Namespace: "default", UID: types.UID("uid-a")}}
+	someApp.Spec.AppName = "app-a"
+	ownerRef := meta_v1.OwnerReference{APIVersion: opsv1.GroupVersion.String(), Kind: "Someapp", Name: "app-a", UID: someApp.UID}
+	existing := func(refs ...meta_v1.OwnerReference) client.Object {
+		return &networking_v1.NetworkPolicy{ObjectMeta: meta_v1.ObjectMeta{Name: "app-a", Namespace: "default", OwnerReferences: refs}}
+	}
+
+	tests := []struct {
+		name      string
+		network   *opsv1.SomeNetwork
+		existing  []client.Object
+		wantFound bool
+		// policy types, ingress rules and egress rules of networkpolicy
+		wantTypes   []networking_v1.PolicyType
+		wantIngress int
+		wantEgress  int
+	}{
+		{
+			name:        "ingress deny all",
+			network:     &opsv1.SomeNetwork{Ingress: &opsv1.SomeNetworkRule{}},
+			wantFound:   true,
+			wantTypes:   []networking_v1.PolicyType{networking_v1.PolicyTypeIngress},
+			wantIngress: 0,
+		},
+		{
+			name:        "ingress from app",
+			network:     &opsv1.SomeNetwork{Ingress: &opsv1.SomeNetworkRule{Apps: []string{"app-b"}}},
+			wantFound:   true,
+			wantTypes:   []networking_v1.PolicyType{networking_v1.PolicyTypeIngress},
+			wantIngress: 1,
+		},
+		{
+			name:       "egress keeps dns",
+			network:    &opsv1.SomeNetwork{Egress: &opsv1.SomeNetworkRule{}},
+			wantFound:  true,
+			wantTypes:  []networking_v1.PolicyType{networking_v1.PolicyTypeEgress},
+			wantEgress: 1,
+		},
+		{
+			name:        "both",
+			network:     &opsv1.SomeNetwork{Ingress: &opsv1.SomeNetworkRule{Namespaces: []string{"ns-b"}}, Egress: &opsv1.SomeNetworkRule{CIDRs: []string{"10.0.0.0/8"}}},
+			wantFound:   true,
+			wantTypes:   []networking_v1.PolicyType{networking_v1.PolicyTypeIngress, networking_v1.PolicyTypeEgress},
+			wantIngress: 1,
+			wantEgress:  2,
+		},
+		{name: "removed deletes owned", existing: []client.Object{existing(ownerRef)}},
+		{name: "removed keeps not owned", existing: []client.Object{existing()}, wantFound: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := someApp.DeepCopy()
+			app.Spec.Network = tt.network
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(tt.existing...).Build()
+
+			sn := &SomeNetworkPolicy{StandardLabels: map[string]string{"name": "app-a", "app": "app-a"}}
+			if err := sn.Reconcile(context.Background(), app, c, scheme, logr.Discard()); err != nil {
+				t.Fatalf("Reconcile() error = %v", err)
+			}
+
+			np := &networking_v1.NetworkPolicy{}
+			err := c.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: "app-a"}, np)
+			if found := !apierrors.IsNotFound(err); found != tt.wantFound {
+				t.Fatalf("found = %v, want %v, err %v", found, tt.wantFound, err)
+			}
+			if tt.network == nil {
+				return
+			}
+			if !reflect.DeepEqual(np.Spec.PolicyTypes, tt.wantTypes) || len(np.Spec.Ingress) != tt.wantIngress || len(np.Spec.Egress) != tt.wantEgress {
+				t.Errorf("networkpolicy spec = %+v", np.Spec)
+			}
+			if want := map[string]string{"app": "app-a", "name": "app-a"}; !reflect.DeepEqual(np.Spec.PodSelector.MatchLabels, want) {
+				t.Errorf("podSelector = %v, want %v", np.Spec.PodSelector.MatchLabels, want)
+			}
+		})
+	}
+}
+
+func TestReconcileIstio(t *testing.T) {
+	scheme := runtime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(opsv1.AddToScheme(scheme))
+
+	someApp := &opsv1.Someapp{ObjectMeta: meta_v1.ObjectMeta{Name: "app-a", Namespace: "default", UID: types.UID("uid-a")}}
+	someApp.Spec.AppName = "app-a"
+	someApp.Spec.AppType = opsv1.AppTypeApi
+	someApp.Spec.Network = &opsv1.SomeNetwork{Ingress: &opsv1.SomeNetworkRule{Apps: []string{"app-b"}}, Egress: &opsv1.SomeNetworkRule{Apps: []string{"app-b"}}}
+
+	tests := []struct {
+		name        string
+		enableIstio bool
+		gateway     string
+		// namespace of gateway pods allowed, empty for none
+		wantGatewayNs string
+		wantIstiod    bool
+	}{
+		{name: "istio disabled"},
+		{name: "istio without gateway", enableIstio: true, wantIstiod: true},
+		{name: "gateway with namespace", enableIstio: true, gateway: "istio-system/ingressgateway", wantGatewayNs: "istio-system", wantIstiod: true},
+		{name: "gateway of same namespace", enableIstio: true, gateway: "ingressgateway", wantGatewayNs: "default", wantIstiod: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := someApp.DeepCopy()
+			app.Spec.EnableIstio = tt.enableIstio
+			if len(tt.gateway) > 0 {
+				app.Spec.Expose = &opsv1.SomeExpose{Hosts: []string{"a.example.com"}, Gateway: tt.gateway}
+			}
+			c := fake.NewClientBuilder().WithScheme(scheme).Build()
+
+			sn := &SomeNetworkPolicy{StandardLabels: map[string]string{"name": "app-a", "app": "app-a"}}
+			if err := sn.Reconcile(context.Background(), app, c, scheme, logr.Discard()); err != nil {
+				t.Fatalf("Reconcile() error = %v", err)
+			}
+			np := &networking_v1.NetworkPolicy{}
+			if err := c.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: "app-a"}, np); err != nil {
+				t.Fatal(err)
+			}
+
+			peers := rulePeers(&opsv1.SomeNetworkRule{Apps: []string{"app-b"}})
+			wantIngress := []networking_v1.NetworkPolicyIngressRule{{From: peers}}
+			wantEgress := []networking_v1.NetworkPolicyEgressRule{dnsEgressRule()}
+			if len(tt.wantGatewayNs) > 0 {
+				rule := gatewayIngressRule(app)
+				if got := rule.From[0].NamespaceSelector.MatchLabels[namespaceNameLabel]; got != tt.wantGatewayNs {
+					t.Errorf("gateway namespace = %s, want %s", got, tt.wantGatewayNs)
+				}
+				wantIngress = append(wantIngress, rule)
+			}
+			if tt.wantIstiod {
+				wantEgress = append(wantEgress, istiodEgressRule())
+			}
+			wantEgress = append(wantEgress, networking_v1.NetworkPolicyEgressRule{To: peers})
+			if !reflect.DeepEqual(np.Spec.Ingress, wantIngress) {
+				t.Errorf("ingress = %+v, want %+v", np.Spec.Ingress, wantIngress)
+			}
+			if !reflect.DeepEqual(np.Spec.Egress, wantEgress) {
+				t.Errorf("egress = %+v, want %+v", np.Spec.Egress, wantEgress)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package owner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+
+	opsv1 "github.com/changqings/some-app-operator/api/v1"
+	"github.com/changqings/some-app-operator/pkg/apply"
+)
+
+// ErrNotOwned existing resource not created by any someapp, not changed without adoption
+var ErrNotOwned = errors.New("not owned by someapp")
+
+// Adoption of existing resources not owned by any someapp, like hand-written deployments,
+// enabled by annotation ops.some.cn/adopt
+type Adoption struct {
+	Enabled bool
+	// Kind/Name adopted in one reconcile
+	Adopted []string
+}
+
+// IsManaged check obj has an ownerReference to any someapp, shared ones like canary dr included
+func IsManaged(obj meta_v1.Object) bool {
+	for _, ref := range obj.GetOwnerReferences() {
+		gv, err := schema.ParseGroupVersion(ref.APIVersion)
+		if err == nil && gv.Group == opsv1.GroupVersion.Group && ref.Kind == "Someapp" {
+			return true
+		}
+	}
+	return false
+}
+
+// Get existing resource of key into obj, check it is owned by someapp, or adopt it when enabled,
+// fields of its previous managers are taken over by apply.TakeOver.
+// found is false when not exists, adopted is true when not owned and adopted.
+// Nil Adoption only get, not check. checks refuse adoption of obj with error before anything taken over.
+func (a *Adoption) Get(ctx context.Context, c client.Client, key client.ObjectKey, obj client.Object, checks ...func(client.Object) error) (found, adopted bool, err error) {
+	if err := c.Get(ctx, key, obj); err != nil {
+		if apierrors.IsNotFound(err) {
+			return false, false, nil
+		}
+		return false, false, err
+	}
+	if a == nil || IsManaged(obj) {
+		return true, false, nil
+	}
+
+	kind := fmt.Sprintf("%T", obj)
+	if gvk, err := apiutil.GVKForObject(obj, c.Scheme()); err == nil {
+		kind = gvk.Kind
+	}
+	if !a.Enabled {
+		return true, false, fmt.Errorf("%s %s exists and %w, set annotation %s: \"true\" to adopt it", kind, key.Name, ErrNotOwned, opsv1.AdoptAnnotation)
+	}
+
+	adoptedName := kind + "/" + key.Name
+	for _, check := range checks {
+		if err := check(obj); err != nil {
+			return true, false, fmt.Errorf("%s %s exists and %w, can not adopt it: %w", kind, key.Name, ErrNotOwned, err)
+		}
+	}
+	for _, name := range a.Adopted {
+		if name == adoptedName {
+			return true, true, nil
+		}
+	}
+	// fields of previous managers become ours, so next apply removes ones someapp does not set
+	if err := apply.TakeOver(ctx, c, obj); err != nil {
+		return true, true, fmt.Errorf("take over fields of %s: %w", adoptedName, err)
+	}
+	a.Adopted = append(a.Adopted, adoptedName)
+	return true, true, nil
+}
@@ -0,0 +1,27 @@
+package owner
+
+import (
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// IsOwnedBy check obj has an ownerReference to owner, controller or not
+func IsOwnedBy(obj, owner meta_v1.Object) bool {
+	for _, ref := range obj.GetOwnerReferences() {
+		if ref.UID == owner.GetUID() {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoveOwnerReference remove ownerReference to owner from obj, if any
+func RemoveOwnerReference(obj, owner meta_v1.Object) {
+	refs := obj.GetOwnerReferences()
+	kept := make([]meta_v1.OwnerReference, 0, len(refs))
+	for _, ref := range refs {
+		if ref.UID != owner.GetUID() {
+			kept = append(kept, ref)
+		}
+	}
+	obj.SetOwnerReferences(kept)
+}
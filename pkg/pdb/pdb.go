@@ -0,0 +1,101 @@
+package pdb
+
+import (
+	"context"
+
+	policy_v1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	opsv1 "github.com/changqings/some-app-operator/api/v1"
+	"github.com/changqings/some-app-operator/pkg/dryrun"
+	"github.com/changqings/some-app-operator/pkg/hpa"
+	"github.com/changqings/some-app-operator/pkg/metrics"
+	"github.com/changqings/some-app-operator/pkg/owner"
+	"github.com/go-logr/logr"
+)
+
+// SomePdb create pdb select deployment pods with standard labels,
+// spec.disruption not set and hpa min < 2, pdb will be deleted
+type SomePdb struct {
+	StandardLabels map[string]string
+}
+
+func (sp *SomePdb) Reconcile(ctx context.Context, someApp *opsv1.Someapp, client client.Client, scheme *runtime.Scheme, log logr.Logger) error {
+
+	pdb := &policy_v1.PodDisruptionBudget{ObjectMeta: meta_v1.ObjectMeta{
+		Name:      sp.StandardLabels["name"],
+		Namespace: someApp.Namespace,
+	}}
+
+	disruption := desiredDisruption(someApp)
+	if disruption == nil {
+		return sp.delete(ctx, someApp, client, pdb, log)
+	}
+
+	op, err := controllerutil.CreateOrUpdate(ctx, client, pdb, func() error {
+		if pdb.ObjectMeta.CreationTimestamp.IsZero() {
+			pdb.ObjectMeta.Labels = sp.StandardLabels
+		}
+
+		pdb.Spec.Selector = &meta_v1.LabelSelector{
+			MatchLabels: sp.StandardLabels,
+		}
+		pdb.Spec.MinAvailable = disruption.MinAvailable
+		pdb.Spec.MaxUnavailable = disruption.MaxUnavailable
+
+		// add reference
+		if err := controllerutil.SetOwnerReference(someApp, pdb, scheme); err != nil {
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	metrics.ObserveOperation(dryrun.Enabled(client), "PodDisruptionBudget", op)
+	log.Info("pdb reconcile success", "operation_result", op)
+	return nil
+}
+
+// desiredDisruption return spec.disruption, or maxUnavailable=1 when hpa min >= 2
+func desiredDisruption(someApp *opsv1.Someapp) *opsv1.SomeDisruption {
+	if d := someApp.Spec.Disruption; d != nil && (d.MinAvailable != nil || d.MaxUnavailable != nil) {
+		return d
+	}
+
+	if len(someApp.Spec.SetHpa) > 0 {
+		if hpaMin, _ := hpa.MinMax(someApp.Spec.SetHpa); hpaMin >= 2 {
+			maxUnavailable := intstr.FromInt32(1)
+			return &opsv1.SomeDisruption{MaxUnavailable: &maxUnavailable}
+		}
+	}
+
+	return nil
+}
+
+// delete pdb created by this someApp before
+func (sp *SomePdb) delete(ctx context.Context, someApp *opsv1.Someapp, c client.Client, pdb *policy_v1.PodDisruptionBudget, log logr.Logger) error {
+	if err := c.Get(ctx, client.ObjectKeyFromObject(pdb), pdb); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	if !owner.IsOwnedBy(pdb, someApp) {
+		return nil
+	}
+
+	if err := c.Delete(ctx, pdb); client.IgnoreNotFound(err) != nil {
+		return err
+	}
+	log.Info("pdb deleted", "pdb_name", pdb.Name)
+	return nil
+}
@@ -0,0 +1,225 @@
+package render
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/go-logr/logr"
+	istio_network_v1 "istio.io/client-go/pkg/apis/networking/v1"
+	istio_network_v1beta1 "istio.io/client-go/pkg/apis/networking/v1beta1"
+	istio_security_v1beta1 "istio.io/client-go/pkg/apis/security/v1beta1"
+	apps_v1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	core_v1 "k8s.io/api/core/v1"
+	networking_v1 "k8s.io/api/networking/v1"
+	policy_v1 "k8s.io/api/policy/v1"
+	rbac_v1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	opsv1 "github.com/changqings/some-app-operator/api/v1"
+	"github.com/changqings/some-app-operator/pkg/children"
+	"github.com/changqings/some-app-operator/pkg/deployment"
+	"github.com/changqings/some-app-operator/pkg/istio"
+)
+
+var scheme = runtime.NewScheme()
+
+func init() {
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(istio_network_v1beta1.AddToScheme(scheme))
+	utilruntime.Must(istio_network_v1.AddToScheme(scheme))
+	utilruntime.Must(istio_security_v1beta1.AddToScheme(scheme))
+	utilruntime.Must(opsv1.AddToScheme(scheme))
+}
+
+// Scheme of someapps and their children
+func Scheme() *runtime.Scheme {
+	return scheme
+}
+
+// Render run reconciles of someApps against a fake client, offline,
+// return children the operator would create, ordered by kind and name.
+// Stable someapps run first, so canaries find stable vs and dr.
+func Render(ctx context.Context, someApps []*opsv1.Someapp, istioAPIVersion, istioTrustDomain string) ([]*unstructured.Unstructured, error) {
+	c := applyClient{fake.NewClientBuilder().WithScheme(scheme).Build()}
+	log := logr.Discard()
+
+	// defaulted before sorted, version not set is stable
+	sorted := make([]*opsv1.Someapp, 0, len(someApps))
+	for _, someApp := range someApps {
+		someApp = someApp.DeepCopy()
+		Default(someApp)
+		sorted = append(sorted, someApp)
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return deployment.Stage(sorted[i]) == opsv1.StableStage && deployment.Stage(sorted[j]) != opsv1.StableStage
+	})
+
+	// all created first, so callers of spec.istio.security.apps are found
+	for _, someApp := range sorted {
+		// uid for ownerReferences, like created by api server
+		someApp.UID = types.UID(someApp.Namespace + "/" + someApp.Name)
+		if err := c.Create(ctx, someApp); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, someApp := range sorted {
+		if err := reconcile(ctx, c, someApp, istioAPIVersion, istioTrustDomain, log); err != nil {
+			return nil, fmt.Errorf("someapp %s/%s: %w", someApp.Namespace, someApp.Name, err)
+		}
+	}
+
+	return list(ctx, c, istioAPIVersion)
+}
+
+// reconcile children of someApp by steps of the controller, nothing changed out of band
+// or adopted offline, so drifts and adoption not checked
+func reconcile(ctx context.Context, c client.Client, someApp *opsv1.Someapp, istioAPIVersion, istioTrustDomain string, log logr.Logger) error {
+	ch := children.Children{IstioAPIVersion: istioAPIVersion, IstioTrustDomain: istioTrustDomain}
+	steps, err := ch.Steps(ctx, c, someApp)
+	if err != nil {
+		return err
+	}
+	for _, step := range steps {
+		if step.Validate != nil {
+			if err := step.Validate(someApp); err != nil {
+				return err
+			}
+		}
+		// authorizationpolicy of no callers denies all, applied by the operator too
+		if err := step.Reconciler.Reconcile(ctx, someApp, c, scheme, log); err != nil && !errors.Is(err, istio.ErrNoCallers) {
+			return fmt.Errorf("%s: %w", step.Name, err)
+		}
+	}
+	return nil
+}
+
+// list all objects created, as manifests without fields set by api server
+func list(ctx context.Context, c client.Client, istioAPIVersion string) ([]*unstructured.Unstructured, error) {
+	childTypes := append([]client.Object{
+		&core_v1.ConfigMap{},
+		&core_v1.ServiceAccount{},
+		&rbac_v1.Role{},
+		&rbac_v1.RoleBinding{},
+		&apps_v1.Deployment{},
+		&autoscalingv2.HorizontalPodAutoscaler{},
+		&policy_v1.PodDisruptionBudget{},
+		&networking_v1.NetworkPolicy{},
+		&core_v1.Service{},
+		&networking_v1.Ingress{},
+	}, istio.OwnedTypes(istioAPIVersion)...)
+	childTypes = append(childTypes, &istio_security_v1beta1.PeerAuthentication{}, &istio_security_v1beta1.AuthorizationPolicy{})
+
+	var objs []*unstructured.Unstructured
+	for _, obj := range childTypes {
+		gvk, err := apiutil.GVKForObject(obj, scheme)
+		if err != nil {
+			return nil, err
+		}
+		newList, err := scheme.New(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+		if err != nil {
+			return nil, err
+		}
+		list := newList.(client.ObjectList)
+		if err := c.List(ctx, list); err != nil {
+			return nil, err
+		}
+		items, err := meta.ExtractList(list)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range items {
+			content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(item)
+			if err != nil {
+				return nil, err
+			}
+			u := &unstructured.Unstructured{Object: content}
+			u.SetGroupVersionKind(gvk)
+			delete(u.Object, "status")
+			for _, field := range []string{"creationTimestamp", "generation", "managedFields", "ownerReferences", "resourceVersion", "uid"} {
+				unstructured.RemoveNestedField(u.Object, "metadata", field)
+			}
+			objs = append(objs, u)
+		}
+	}
+	return objs, nil
+}
+
+// Default set defaults of kubebuilder:default markers in api/v1, done by api server in cluster
+func Default(someApp *opsv1.Someapp) {
+	spec := &someApp.Spec
+	if len(spec.AppType) == 0 {
+		spec.AppType = opsv1.AppTypeApi
+	}
+	if len(spec.AppVersion) == 0 {
+		spec.AppVersion = opsv1.StableStage
+	}
+	if spec.HpaCpuUsage == 0 {
+		spec.HpaCpuUsage = 100
+	}
+	for i := range spec.Volumes {
+		for j := range spec.Volumes[i].Mounts {
+			if len(spec.Volumes[i].Mounts[j].Container) == 0 {
+				spec.Volumes[i].Mounts[j].Container = "app"
+			}
+		}
+	}
+	if spec.Config != nil {
+		if len(spec.Config.MountPath) == 0 {
+			spec.Config.MountPath = "/app/config"
+		}
+		if spec.Config.RevisionHistoryLimit == nil {
+			spec.Config.RevisionHistoryLimit = pointer.Int32(5)
+		}
+	}
+	if spec.Service != nil {
+		if len(spec.Service.Type) == 0 {
+			spec.Service.Type = string(core_v1.ServiceTypeClusterIP)
+		}
+		for i := range spec.Service.Ports {
+			if len(spec.Service.Ports[i].Protocol) == 0 {
+				spec.Service.Ports[i].Protocol = core_v1.ProtocolTCP
+			}
+		}
+	}
+	if spec.DeletionPolicy != nil && len(spec.DeletionPolicy.Policy) == 0 {
+		spec.DeletionPolicy.Policy = opsv1.DeletionPolicyDelete
+	}
+}
+
+// applyClient fake client has no server-side apply, apply patches are create or update of the whole object,
+// fine offline as no fields set by others
+type applyClient struct {
+	client.Client
+}
+
+func (c applyClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if patch.Type() != types.ApplyPatchType {
+		return c.Client.Patch(ctx, obj, patch, opts...)
+	}
+
+	existing := &unstructured.Unstructured{}
+	existing.SetGroupVersionKind(obj.GetObjectKind().GroupVersionKind())
+	if err := c.Get(ctx, client.ObjectKeyFromObject(obj), existing); err != nil {
+		if apierrors.IsNotFound(err) {
+			return c.Create(ctx, obj)
+		}
+		return err
+	}
+	obj.SetResourceVersion(existing.GetResourceVersion())
+	return c.Update(ctx, obj)
+}
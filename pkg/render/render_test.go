@@ -0,0 +1,147 @@
+package render
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	core_v1 "k8s.io/api/core/v1"
+	rbac_v1 "k8s.io/api/rbac/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	opsv1 "github.com/changqings/some-app-operator/api/v1"
+	"github.com/changqings/some-app-operator/pkg/istio"
+)
+
+func testSomeapp(name, version string, mutate ...func(*opsv1.Someapp)) *opsv1.Someapp {
+	someApp := &opsv1.Someapp{
+		ObjectMeta: meta_v1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: opsv1.SomeappSpec{
+			AppName:    "app-a",
+			AppVersion: version,
+			Containers: []core_v1.Container{{
+				Name:  "app",
+				Image: "nginx",
+				Ports: []core_v1.ContainerPort{{Name: "http", ContainerPort: 8080}},
+			}},
+		},
+	}
+	for _, f := range mutate {
+		f(someApp)
+	}
+	return someApp
+}
+
+func enableIstio(someApp *opsv1.Someapp) { someApp.Spec.EnableIstio = true }
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name       string
+		someApps   []*opsv1.Someapp
+		apiVersion string
+		want       []string
+		wantErr    error
+	}{
+		{
+			name:       "stable defaulted",
+			someApps:   []*opsv1.Someapp{testSomeapp("app-a", "")},
+			apiVersion: istio.APIVersionV1,
+			want:       []string{"apps/v1/Deployment/app-a", "v1/Service/app-a"},
+		},
+		{
+			name:       "canary listed before stable",
+			someApps:   []*opsv1.Someapp{testSomeapp("app-a-canary", "canary-v1", enableIstio), testSomeapp("app-a", "", enableIstio)},
+			apiVersion: istio.APIVersionV1,
+			want: []string{
+				"apps/v1/Deployment/app-a", "apps/v1/Deployment/app-a-canary-v1",
+				"v1/Service/app-a", "v1/Service/app-a-canary",
+				"networking.istio.io/v1/DestinationRule/app-a", "networking.istio.io/v1/DestinationRule/app-a-canary",
+				"networking.istio.io/v1/VirtualService/app-a",
+			},
+		},
+		{
+			name:       "v1beta1 istio",
+			someApps:   []*opsv1.Someapp{testSomeapp("app-a", opsv1.StableStage, enableIstio)},
+			apiVersion: istio.APIVersionV1beta1,
+			want: []string{
+				"apps/v1/Deployment/app-a", "v1/Service/app-a",
+				"networking.istio.io/v1beta1/DestinationRule/app-a", "networking.istio.io/v1beta1/VirtualService/app-a",
+			},
+		},
+		{
+			name: "callers not found, deny all as the operator",
+			someApps: []*opsv1.Someapp{testSomeapp("app-a", "", enableIstio, func(someApp *opsv1.Someapp) {
+				someApp.Spec.Istio = &opsv1.SomeIstioConfig{Security: &opsv1.SomeIstioSecurity{Apps: []string{"app-b"}}}
+			})},
+			apiVersion: istio.APIVersionV1,
+			want: []string{
+				"apps/v1/Deployment/app-a", "v1/Service/app-a",
+				"networking.istio.io/v1/DestinationRule/app-a", "networking.istio.io/v1/VirtualService/app-a",
+				"security.istio.io/v1beta1/AuthorizationPolicy/app-a",
+			},
+		},
+		{
+			name:       "canary without stable",
+			someApps:   []*opsv1.Someapp{testSomeapp("app-a-canary", "canary-v1", enableIstio)},
+			apiVersion: istio.APIVersionV1,
+			wantErr:    istio.ErrWaitingForStable,
+		},
+		{
+			name: "serviceaccount rule not allowed",
+			someApps: []*opsv1.Someapp{testSomeapp("app-a", "", func(someApp *opsv1.Someapp) {
+				someApp.Spec.ServiceAccount = &opsv1.SomeServiceAccount{Create: true, Rules: []rbac_v1.PolicyRule{
+					{APIGroups: []string{""}, Resources: []string{"secrets"}, Verbs: []string{"delete"}},
+				}}
+			})},
+			apiVersion: istio.APIVersionV1,
+			wantErr:    errAny,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := make([]*opsv1.Someapp, 0, len(tt.someApps))
+			for _, someApp := range tt.someApps {
+				in = append(in, someApp.DeepCopy())
+			}
+
+			objs, err := Render(context.Background(), in, tt.apiVersion, istio.DefaultTrustDomain)
+			if tt.wantErr != nil {
+				if err == nil || tt.wantErr != errAny && !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Render() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Render() error = %v", err)
+			}
+			if got := manifestKeys(objs); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Render() = %v, want %v", got, tt.want)
+			}
+			for _, obj := range objs {
+				if len(obj.GetResourceVersion()) > 0 || len(obj.GetOwnerReferences()) > 0 {
+					t.Errorf("%s/%s has fields set by api server", obj.GetKind(), obj.GetName())
+				}
+			}
+			// someapps of caller not changed
+			for i := range in {
+				if !reflect.DeepEqual(in[i], tt.someApps[i]) {
+					t.Errorf("someapp %s changed by Render", in[i].Name)
+				}
+			}
+		})
+	}
+}
+
+// errAny any error is wanted
+var errAny = errors.New("any error")
+
+func manifestKeys(objs []*unstructured.Unstructured) []string {
+	keys := make([]string, 0, len(objs))
+	for _, obj := range objs {
+		keys = append(keys, obj.GetAPIVersion()+"/"+obj.GetKind()+"/"+obj.GetName())
+	}
+	return keys
+}
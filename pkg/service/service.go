@@ -2,24 +2,35 @@ package service
 
 import (
 	"context"
+	"fmt"
+	"strings"
 
 	core_v1 "k8s.io/api/core/v1"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	k8s_utils_pointer "k8s.io/utils/pointer"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 
 	opsv1 "github.com/changqings/some-app-operator/api/v1"
+	"github.com/changqings/some-app-operator/pkg/apply"
+	"github.com/changqings/some-app-operator/pkg/dryrun"
+	"github.com/changqings/some-app-operator/pkg/metrics"
+	"github.com/changqings/some-app-operator/pkg/owner"
 	"github.com/go-logr/logr"
 )
 
-// for safe, service not add ownerReference, plase delete it manually
+// owned by someapp, kept when someapp deleted by spec.deletionPolicy
 // only select someApp.Spec.AppType="api"
 // labelSelector  targetPort="http"
 type SomeService struct {
 	Stage string
+	// drifts found when apply, nil to ignore
+	Drifts *apply.Drifts
+	// existing service not owned by someapp, adopted or not, nil to not check
+	Adoption *owner.Adoption
 }
 
 // stable svc use one svc cr
@@ -33,10 +44,112 @@ func (sv *SomeService) Reconcile(ctx context.Context, someApp *opsv1.Someapp, cl
 			"app":   someApp.Spec.AppName,
 			"stage": sv.Stage,
 		}
+		serviceSpec = someApp.Spec.Service
+		serviceType = core_v1.ServiceTypeClusterIP
+		headless    = false
+	)
+
+	if serviceSpec != nil && len(serviceSpec.Type) > 0 {
+		if serviceSpec.Type == opsv1.ServiceTypeHeadless {
+			headless = true
+		} else {
+			serviceType = core_v1.ServiceType(serviceSpec.Type)
+		}
+	}
+
+	// reconcile
+	service := &core_v1.Service{ObjectMeta: meta_v1.ObjectMeta{
+		Name:      Name(someApp.Spec.AppName, sv.Stage),
+		Namespace: someApp.Namespace,
+	}}
+
+	_, adopted, err := sv.Adoption.Get(ctx, client, types.NamespacedName{Namespace: service.Namespace, Name: service.Name}, &core_v1.Service{})
+	if err != nil {
+		return err
+	}
+
+	// clusterIP is immutable, headless changed need recreate
+	if err := sv.deleteIfHeadlessChanged(ctx, client, someApp, service, headless, log); err != nil {
+		return err
+	}
+
+	service.ObjectMeta.Labels = selectTargetLabels
+	if serviceSpec != nil {
+		service.ObjectMeta.Annotations = serviceSpec.Annotations
+	}
+
+	// clusterIP allocated is not set, so kept
+	service.Spec = core_v1.ServiceSpec{
+		Selector: selectTargetLabels,
+		Type:     serviceType,
+		Ports:    Ports(someApp),
+	}
+	if headless {
+		service.Spec.ClusterIP = core_v1.ClusterIPNone
+	}
+	if serviceSpec != nil {
+		service.Spec.SessionAffinity = serviceSpec.SessionAffinity
+	}
+
+	if err := controllerutil.SetOwnerReference(someApp, service, scheme); err != nil {
+		return err
+	}
+
+	// fields of adopted one set by others are taken over, not drift
+	drifts := sv.Drifts
+	if adopted {
+		drifts = nil
+	}
+	op, err := apply.Apply(ctx, client, service, drifts)
+	if err != nil {
+		return err
+	}
+
+	metrics.ObserveOperation(dryrun.Enabled(client), "Service", op)
+	log.Info("service reconcile success", "operation_result", op)
+	return nil
+
+}
+
+// Name return service name of stage, canary service with suffix -canary
+func Name(appName, stage string) string {
+	if stage == opsv1.CanaryStage {
+		return appName + "-canary"
+	}
+	return appName
+}
+
+// Ports return service ports of spec.service,
+// if not set, one http port 80 to container app port named http, api or unnamed
+func Ports(someApp *opsv1.Someapp) []core_v1.ServicePort {
+
+	if someApp.Spec.Service != nil && len(someApp.Spec.Service.Ports) > 0 {
+		ports := make([]core_v1.ServicePort, 0, len(someApp.Spec.Service.Ports))
+		for _, p := range someApp.Spec.Service.Ports {
+			targetPort := intstr.FromInt32(p.Port)
+			if p.TargetPort != nil {
+				targetPort = *p.TargetPort
+			}
+			protocol := p.Protocol
+			if len(protocol) == 0 {
+				protocol = core_v1.ProtocolTCP
+			}
+			ports = append(ports, core_v1.ServicePort{
+				Name:        p.Name,
+				Protocol:    protocol,
+				Port:        p.Port,
+				TargetPort:  targetPort,
+				AppProtocol: p.AppProtocol,
+				NodePort:    p.NodePort,
+			})
+		}
+		return ports
+	}
+
+	var (
 		appContainerPort  int32
 		appContainerIndex int
 		someAppContainer  = someApp.Spec.Containers
-		serviceName       = someApp.Spec.AppName
 	)
 
 	for i, c := range someApp.Spec.Containers {
@@ -53,46 +166,47 @@ func (sv *SomeService) Reconcile(ctx context.Context, someApp *opsv1.Someapp, cl
 		}
 	}
 
-	if sv.Stage == "canary" {
-		serviceName = serviceName + "-canary"
+	return []core_v1.ServicePort{
+		{
+			Name:        "http",
+			Protocol:    "TCP",
+			Port:        80,
+			TargetPort:  intstr.FromInt32(appContainerPort),
+			AppProtocol: k8s_utils_pointer.String("http"),
+		},
 	}
+}
 
-	// reconcile
-	service := &core_v1.Service{ObjectMeta: meta_v1.ObjectMeta{
-		Name:      serviceName,
-		Namespace: someApp.Namespace,
-	}}
+// IsTCPPort check istio will treat this port as plain tcp, by appProtocol or name prefix
+func IsTCPPort(p core_v1.ServicePort) bool {
+	if p.Protocol != "" && p.Protocol != core_v1.ProtocolTCP {
+		return false
+	}
+	if p.AppProtocol != nil {
+		return *p.AppProtocol == "tcp"
+	}
+	return p.Name == "tcp" || strings.HasPrefix(p.Name, "tcp-")
+}
 
-	op, err := controllerutil.CreateOrPatch(ctx, client, service, func() error {
+// deleteIfHeadlessChanged delete existing service, when it change between headless and not,
+// only owned one, adopted or others' ones are not deleted
+func (sv *SomeService) deleteIfHeadlessChanged(ctx context.Context, c client.Client, someApp *opsv1.Someapp, service *core_v1.Service, headless bool, log logr.Logger) error {
+	existing := &core_v1.Service{}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(service), existing); err != nil {
+		return client.IgnoreNotFound(err)
+	}
 
-		if service.ObjectMeta.CreationTimestamp.IsZero() {
-			service.ObjectMeta.Labels = selectTargetLabels
-		}
+	if (existing.Spec.ClusterIP == core_v1.ClusterIPNone) == headless {
+		return nil
+	}
 
-		service.Spec = core_v1.ServiceSpec{
-			Selector: selectTargetLabels,
-			Type:     core_v1.ServiceTypeClusterIP,
-			Ports: []core_v1.ServicePort{
-				{
-					Name:        "http",
-					Protocol:    "TCP",
-					Port:        80,
-					TargetPort:  intstr.FromInt32(appContainerPort),
-					AppProtocol: k8s_utils_pointer.String("http"),
-				},
-			},
-		}
-		if err := controllerutil.SetOwnerReference(someApp, service, scheme); err != nil {
-			return err
-		}
+	if !owner.IsOwnedBy(existing, someApp) {
+		return fmt.Errorf("Service %s exists and %w, clusterIP can not change between headless and not, recreate it by hand", existing.Name, owner.ErrNotOwned)
+	}
 
-		return nil
-	})
-	if err != nil {
+	if err := c.Delete(ctx, existing); client.IgnoreNotFound(err) != nil {
 		return err
 	}
-
-	log.Info("service reconcile success", "operation_result", op)
+	log.Info("service deleted for headless changed, will recreate", "service_name", existing.Name)
 	return nil
-
 }
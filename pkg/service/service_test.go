@@ -0,0 +1,59 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/go-logr/logr"
+	core_v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	opsv1 "github.com/changqings/some-app-operator/api/v1"
+	"github.com/changqings/some-app-operator/pkg/owner"
+)
+
+func TestDeleteIfHeadlessChanged(t *testing.T) {
+	someApp := &opsv1.Someapp{ObjectMeta: meta_v1.ObjectMeta{Name: "app-a", Namespace: "default", UID: types.UID("uid-a")}}
+	ownerRef := meta_v1.OwnerReference{APIVersion: opsv1.GroupVersion.String(), Kind: "Someapp", Name: "app-a", UID: someApp.UID}
+
+	tests := []struct {
+		name        string
+		clusterIP   string
+		owners      []meta_v1.OwnerReference
+		headless    bool
+		wantErr     error
+		wantDeleted bool
+	}{
+		{name: "not changed", clusterIP: "10.0.0.1", owners: []meta_v1.OwnerReference{ownerRef}},
+		{name: "owned to headless", clusterIP: "10.0.0.1", owners: []meta_v1.OwnerReference{ownerRef}, headless: true, wantDeleted: true},
+		{name: "owned from headless", clusterIP: core_v1.ClusterIPNone, owners: []meta_v1.OwnerReference{ownerRef}, wantDeleted: true},
+		{name: "adopted or not owned", clusterIP: "10.0.0.1", headless: true, wantErr: owner.ErrNotOwned},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			existing := &core_v1.Service{
+				ObjectMeta: meta_v1.ObjectMeta{Name: "app-a", Namespace: "default", OwnerReferences: tt.owners},
+				Spec:       core_v1.ServiceSpec{ClusterIP: tt.clusterIP},
+			}
+			c := fake.NewClientBuilder().WithObjects(existing).Build()
+
+			sv := &SomeService{Stage: opsv1.StableStage}
+			service := &core_v1.Service{ObjectMeta: meta_v1.ObjectMeta{Name: "app-a", Namespace: "default"}}
+			err := sv.deleteIfHeadlessChanged(context.Background(), c, someApp, service, tt.headless, logr.Discard())
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("deleteIfHeadlessChanged() error = %v, want %v", err, tt.wantErr)
+			}
+
+			err = c.Get(context.Background(), client.ObjectKeyFromObject(existing), &core_v1.Service{})
+			if deleted := apierrors.IsNotFound(err); deleted != tt.wantDeleted {
+				t.Errorf("deleted = %v, want %v", deleted, tt.wantDeleted)
+			}
+		})
+	}
+}
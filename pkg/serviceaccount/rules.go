@@ -0,0 +1,65 @@
+package serviceaccount
+
+import (
+	"fmt"
+
+	rbac_v1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	opsv1 "github.com/changqings/some-app-operator/api/v1"
+)
+
+var readVerbs = sets.New("get", "list", "watch")
+
+// allowedRules is what spec.serviceAccount.rules may grant, by group resource.
+// Operator must hold all of them itself, kubebuilder rbac markers of controller,
+// as api server refuse roles granting more than the creator has.
+// Wildcards are never allowed, so rules can not escalate to the operator's own permissions.
+var allowedRules = map[schema.GroupResource]sets.Set[string]{
+	{Resource: "configmaps"}: readVerbs,
+	{Resource: "secrets"}:    readVerbs,
+	{Resource: "pods"}:       readVerbs,
+	{Resource: "services"}:   readVerbs,
+	{Resource: "endpoints"}:  readVerbs,
+	{Resource: "events"}:     sets.New("create", "patch"),
+	{Group: "discovery.k8s.io", Resource: "endpointslices"}: readVerbs,
+	{Group: "coordination.k8s.io", Resource: "leases"}:      sets.New("get", "list", "watch", "create", "update", "patch", "delete"),
+}
+
+// Validate check spec.serviceAccount.rules against allowedRules, invalid spec should not be reconciled
+func Validate(someApp *opsv1.Someapp) error {
+	spec := someApp.Spec.ServiceAccount
+	if spec == nil {
+		return nil
+	}
+	for i, rule := range spec.Rules {
+		if err := validateRule(rule); err != nil {
+			return fmt.Errorf("serviceAccount.rules[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func validateRule(rule rbac_v1.PolicyRule) error {
+	if len(rule.NonResourceURLs) > 0 {
+		return fmt.Errorf("nonResourceURLs not allowed")
+	}
+	if len(rule.APIGroups) == 0 || len(rule.Resources) == 0 || len(rule.Verbs) == 0 {
+		return fmt.Errorf("apiGroups, resources and verbs are required")
+	}
+	for _, group := range rule.APIGroups {
+		for _, resource := range rule.Resources {
+			verbs, ok := allowedRules[schema.GroupResource{Group: group, Resource: resource}]
+			if !ok {
+				return fmt.Errorf("resource %q of apiGroup %q not allowed", resource, group)
+			}
+			for _, verb := range rule.Verbs {
+				if !verbs.Has(verb) {
+					return fmt.Errorf("verb %q on %q not allowed, allowed verbs %v", verb, resource, sets.List(verbs))
+				}
+			}
+		}
+	}
+	return nil
+}
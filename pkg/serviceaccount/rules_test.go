@@ -0,0 +1,68 @@
+package serviceaccount
+
+import (
+	"testing"
+
+	rbac_v1 "k8s.io/api/rbac/v1"
+
+	opsv1 "github.com/changqings/some-app-operator/api/v1"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   []rbac_v1.PolicyRule
+		wantErr bool
+	}{
+		{name: "no rules"},
+		{
+			name:  "read configmaps and pods",
+			rules: []rbac_v1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"configmaps", "pods"}, Verbs: []string{"get", "list", "watch"}}},
+		},
+		{
+			name:  "leader election leases",
+			rules: []rbac_v1.PolicyRule{{APIGroups: []string{"coordination.k8s.io"}, Resources: []string{"leases"}, Verbs: []string{"get", "create", "update"}}},
+		},
+		{
+			name:    "wildcard verbs",
+			rules:   []rbac_v1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"configmaps"}, Verbs: []string{"*"}}},
+			wantErr: true,
+		},
+		{
+			name:    "wildcard resources",
+			rules:   []rbac_v1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"*"}, Verbs: []string{"get"}}},
+			wantErr: true,
+		},
+		{
+			name:    "write secrets",
+			rules:   []rbac_v1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"secrets"}, Verbs: []string{"update"}}},
+			wantErr: true,
+		},
+		{
+			name:    "escalate with roles",
+			rules:   []rbac_v1.PolicyRule{{APIGroups: []string{"rbac.authorization.k8s.io"}, Resources: []string{"roles"}, Verbs: []string{"create"}}},
+			wantErr: true,
+		},
+		{
+			name:    "missing apiGroups",
+			rules:   []rbac_v1.PolicyRule{{Resources: []string{"pods"}, Verbs: []string{"get"}}},
+			wantErr: true,
+		},
+		{
+			name:    "nonResourceURLs",
+			rules:   []rbac_v1.PolicyRule{{NonResourceURLs: []string{"/metrics"}, Verbs: []string{"get"}}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			someApp := &opsv1.Someapp{Spec: opsv1.SomeappSpec{
+				ServiceAccount: &opsv1.SomeServiceAccount{Create: true, Rules: tt.rules},
+			}}
+			if err := Validate(someApp); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
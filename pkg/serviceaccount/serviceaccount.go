@@ -0,0 +1,180 @@
+package serviceaccount
+
+import (
+	"context"
+	"fmt"
+
+	core_v1 "k8s.io/api/core/v1"
+	rbac_v1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	opsv1 "github.com/changqings/some-app-operator/api/v1"
+	"github.com/changqings/some-app-operator/pkg/apply"
+	"github.com/changqings/some-app-operator/pkg/dryrun"
+	"github.com/changqings/some-app-operator/pkg/metrics"
+	"github.com/changqings/some-app-operator/pkg/owner"
+	"github.com/go-logr/logr"
+)
+
+// SomeServiceAccount create serviceaccount, role and rolebinding of spec.serviceAccount,
+// all named the same as serviceaccount, owned ones not wanted any more will be deleted
+type SomeServiceAccount struct {
+	StandardLabels map[string]string
+}
+
+// Name return pod serviceaccount name, empty means namespace default
+func Name(baseName string, sa *opsv1.SomeServiceAccount) string {
+	if sa == nil {
+		return ""
+	}
+	if len(sa.Name) > 0 {
+		return sa.Name
+	}
+	if sa.Create {
+		return baseName
+	}
+	return ""
+}
+
+func (ss *SomeServiceAccount) Reconcile(ctx context.Context, someApp *opsv1.Someapp, client client.Client, scheme *runtime.Scheme, log logr.Logger) error {
+
+	var (
+		spec   = someApp.Spec.ServiceAccount
+		create = spec != nil && spec.Create
+		name   = Name(ss.StandardLabels["name"], spec)
+	)
+
+	if !create {
+		// serviceaccount maybe created before, with deployment name or spec name
+		for _, n := range []string{ss.StandardLabels["name"], name} {
+			if len(n) == 0 {
+				continue
+			}
+			if err := ss.deleteOwned(ctx, someApp, client, n, true, log); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// applied, annotations removed from spec are removed from serviceaccount too
+	sa := &core_v1.ServiceAccount{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Name:        name,
+			Namespace:   someApp.Namespace,
+			Labels:      ss.StandardLabels,
+			Annotations: spec.Annotations,
+		},
+		AutomountServiceAccountToken: spec.AutomountToken,
+	}
+
+	// add reference
+	if err := controllerutil.SetOwnerReference(someApp, sa, scheme); err != nil {
+		return err
+	}
+
+	op, err := apply.Apply(ctx, client, sa, nil)
+	if err != nil {
+		return err
+	}
+	metrics.ObserveOperation(dryrun.Enabled(client), "ServiceAccount", op)
+	log.Info("serviceaccount reconcile success", "operation_result", op)
+
+	if len(spec.Rules) == 0 {
+		return ss.deleteOwned(ctx, someApp, client, name, false, log)
+	}
+
+	return ss.reconcileRbac(ctx, someApp, client, scheme, name, spec.Rules, log)
+}
+
+// reconcileRbac create role of rules, and bind it to serviceaccount
+func (ss *SomeServiceAccount) reconcileRbac(ctx context.Context, someApp *opsv1.Someapp, c client.Client, scheme *runtime.Scheme, name string, rules []rbac_v1.PolicyRule, log logr.Logger) error {
+
+	role := &rbac_v1.Role{ObjectMeta: meta_v1.ObjectMeta{
+		Name:      name,
+		Namespace: someApp.Namespace,
+	}}
+
+	op, err := controllerutil.CreateOrUpdate(ctx, c, role, func() error {
+		if role.ObjectMeta.CreationTimestamp.IsZero() {
+			role.ObjectMeta.Labels = ss.StandardLabels
+		}
+		role.Rules = rules
+
+		return controllerutil.SetOwnerReference(someApp, role, scheme)
+	})
+	if err != nil {
+		return err
+	}
+	metrics.ObserveOperation(dryrun.Enabled(c), "Role", op)
+	log.Info("role reconcile success", "operation_result", op)
+
+	roleBinding := &rbac_v1.RoleBinding{ObjectMeta: meta_v1.ObjectMeta{
+		Name:      name,
+		Namespace: someApp.Namespace,
+	}}
+
+	op, err = controllerutil.CreateOrUpdate(ctx, c, roleBinding, func() error {
+		// roleRef is immutable, name is the same, so set it when create
+		if roleBinding.ObjectMeta.CreationTimestamp.IsZero() {
+			roleBinding.ObjectMeta.Labels = ss.StandardLabels
+			roleBinding.RoleRef = rbac_v1.RoleRef{
+				APIGroup: rbac_v1.GroupName,
+				Kind:     "Role",
+				Name:     name,
+			}
+		}
+		roleBinding.Subjects = []rbac_v1.Subject{
+			{
+				Kind:      rbac_v1.ServiceAccountKind,
+				Name:      name,
+				Namespace: someApp.Namespace,
+			},
+		}
+
+		return controllerutil.SetOwnerReference(someApp, roleBinding, scheme)
+	})
+	if err != nil {
+		return err
+	}
+	metrics.ObserveOperation(dryrun.Enabled(c), "RoleBinding", op)
+	log.Info("rolebinding reconcile success", "operation_result", op)
+
+	return nil
+}
+
+// deleteOwned delete role and rolebinding owned by this someApp, and serviceaccount if withSa
+func (ss *SomeServiceAccount) deleteOwned(ctx context.Context, someApp *opsv1.Someapp, c client.Client, name string, withSa bool, log logr.Logger) error {
+
+	objs := []client.Object{
+		&rbac_v1.RoleBinding{},
+		&rbac_v1.Role{},
+	}
+	if withSa {
+		objs = append(objs, &core_v1.ServiceAccount{})
+	}
+
+	for _, obj := range objs {
+		if err := c.Get(ctx, client.ObjectKey{Namespace: someApp.Namespace, Name: name}, obj); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return err
+		}
+
+		if !owner.IsOwnedBy(obj, someApp) {
+			continue
+		}
+
+		if err := c.Delete(ctx, obj); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+		log.Info("serviceaccount resource deleted", "name", name, "type", fmt.Sprintf("%T", obj))
+	}
+
+	return nil
+}